@@ -2,10 +2,12 @@ package bindings
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"reflect"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -19,7 +21,14 @@ import (
 	"github.com/balazsgrill/potatodrive/bindings/sftp"
 	"github.com/balazsgrill/potatodrive/core"
 	cfapi "github.com/balazsgrill/potatodrive/core/cfapi/filesystem"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/fsck"
+	"github.com/balazsgrill/potatodrive/core/planner"
 	prjfs "github.com/balazsgrill/potatodrive/core/projfs/filesystem"
+	"github.com/balazsgrill/potatodrive/core/quota"
+	"github.com/balazsgrill/potatodrive/core/schedule"
+	"github.com/balazsgrill/potatodrive/core/trash"
+	"github.com/balazsgrill/potatodrive/core/versions"
 	"github.com/spf13/afero"
 )
 
@@ -45,6 +54,28 @@ type BaseConfig struct {
 	LocalPath string `flag:"localpath,Local folder" reg:"LocalPath"`
 	Type      string `flag:"type,Type of binding" reg:"Type"`
 	API       string `flag:"api,Type of API to be used of" reg:"API"`
+	Conflict  string `flag:"conflict,Conflict resolution policy (keep-both|prefer-local|prefer-remote)" reg:"Conflict"`
+	// TombstoneRetention is a duration like "720h"
+	TombstoneRetention string `flag:"tombstone-retention,Period deletions are remembered for (default 720h)" reg:"TombstoneRetention"`
+	// Ignore are gitignore-style patterns separated by ';', applied before the rules of .potatoignore at the remote root
+	Ignore string `flag:"ignore,Patterns of files excluded from sync separated by ';'" reg:"Ignore"`
+	// CacheSize is a size like "20GB", CacheMaxAge is a duration like "720h"
+	CacheSize   string `flag:"cache-size,Maximum size of file contents kept locally" reg:"CacheSize"`
+	CacheMaxAge string `flag:"cache-max-age,Period after unused files are dehydrated" reg:"CacheMaxAge"`
+	// SyncInterval is a duration like "30s"
+	SyncInterval string `flag:"sync-interval,Time between synchronizations (default 30s)" reg:"SyncInterval"`
+	Transfers    string `flag:"transfers,Number of files transferred in parallel (default 4)" reg:"Transfers"`
+	// TrashRetention is a duration like "720h", TrashSize is a size like "10GB"
+	TrashRetention string `flag:"trash-retention,Period deleted files are kept in the remote trash (default 720h)" reg:"TrashRetention"`
+	TrashSize      string `flag:"trash-size,Maximum size of the remote trash" reg:"TrashSize"`
+	// Versions is the number of previous versions kept of overwritten files, VersionMaxAge is a duration like "720h"
+	Versions      string `flag:"versions,Number of previous versions kept of overwritten files" reg:"Versions"`
+	VersionMaxAge string `flag:"version-max-age,Period previous versions of overwritten files are kept for" reg:"VersionMaxAge"`
+	Hash          string `flag:"hash,Content hash algorithm (md5|sha256|xxhash)" reg:"Hash"`
+	// State selects where hashes and deletions of remote files are kept
+	State string `flag:"state,Where the state of remote files is kept (remote|local|manifest)" reg:"State"`
+	// SkipHidden restores the behavior of earlier versions, which did not synchronize any file starting with a dot
+	SkipHidden bool `flag:"skip-hidden,Do not synchronize files and folders starting with a dot" reg:"SkipHidden"`
 }
 
 func (config *BaseConfig) IsCFAPI() bool {
@@ -55,6 +86,120 @@ func (config *BaseConfig) IsSimplfied() bool {
 	return config.API == APIType_CFAPI_Simplfied
 }
 
+func (config *BaseConfig) ConflictPolicy() (core.ConflictPolicy, error) {
+	if config.Conflict == "" {
+		return core.ConflictKeepBoth, nil
+	}
+	for _, policy := range core.ConflictPolicies {
+		if string(policy) == config.Conflict {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy: %s", config.Conflict)
+}
+
+func (config *BaseConfig) TombstoneRetentionPeriod() (time.Duration, error) {
+	if config.TombstoneRetention == "" {
+		return core.DefaultTombstoneRetention, nil
+	}
+	return time.ParseDuration(config.TombstoneRetention)
+}
+
+func (config *BaseConfig) CachePolicy() (quota.Policy, error) {
+	var policy quota.Policy
+	var err error
+	if config.CacheSize != "" {
+		policy.MaxSize, err = quota.ParseSize(config.CacheSize)
+		if err != nil {
+			return policy, err
+		}
+	}
+	if config.CacheMaxAge != "" {
+		policy.MaxAge, err = time.ParseDuration(config.CacheMaxAge)
+	}
+	return policy, err
+}
+
+func (config *BaseConfig) TrashPolicy() (trash.Policy, error) {
+	policy := trash.Policy{Retention: trash.DefaultRetention}
+	var err error
+	if config.TrashRetention != "" {
+		policy.Retention, err = time.ParseDuration(config.TrashRetention)
+		if err != nil {
+			return policy, err
+		}
+	}
+	if config.TrashSize != "" {
+		policy.MaxSize, err = quota.ParseSize(config.TrashSize)
+	}
+	return policy, err
+}
+
+func (config *BaseConfig) VersionPolicy() (versions.Policy, error) {
+	var policy versions.Policy
+	var err error
+	if config.Versions != "" {
+		policy.Count, err = strconv.Atoi(config.Versions)
+		if err != nil {
+			return policy, err
+		}
+		if policy.Count < 0 {
+			return policy, fmt.Errorf("invalid number of versions: %d", policy.Count)
+		}
+	}
+	if config.VersionMaxAge != "" {
+		policy.MaxAge, err = time.ParseDuration(config.VersionMaxAge)
+	}
+	return policy, err
+}
+
+// HashAlgorithm returns the configured content hash algorithm, digest.Default if not set
+func (config *BaseConfig) HashAlgorithm() (digest.Algorithm, error) {
+	return digest.Parse(config.Hash)
+}
+
+func (config *BaseConfig) StateLocation() (core.StateLocation, error) {
+	if config.State == "" {
+		return core.StateRemote, nil
+	}
+	for _, location := range core.StateLocations {
+		if string(location) == config.State {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("unknown state location: %s", config.State)
+}
+
+func (config *BaseConfig) SyncSchedule() (schedule.Config, error) {
+	result := schedule.DefaultConfig()
+	if config.SyncInterval == "" {
+		return result, nil
+	}
+	interval, err := time.ParseDuration(config.SyncInterval)
+	if err != nil {
+		return result, err
+	}
+	if interval <= 0 {
+		return result, fmt.Errorf("invalid synchronization interval: %s", config.SyncInterval)
+	}
+	result.Interval = interval
+	return result, nil
+}
+
+func (config *BaseConfig) TransferCount() (int, error) {
+	if config.Transfers == "" {
+		return core.DefaultTransfers, nil
+	}
+	count, err := strconv.Atoi(config.Transfers)
+	if err != nil {
+		return 0, err
+	}
+	if count < 1 {
+		return 0, fmt.Errorf("invalid number of transfers: %d", count)
+	}
+	return count, nil
+}
+
 func ConfigToFlags(config any) {
 	structPtrValue := reflect.ValueOf(config)
 	structValue := structPtrValue.Elem()
@@ -102,10 +247,25 @@ func CloseOnSigTerm(closers ...io.Closer) {
 	os.Exit(1)
 }
 
-type closerFunc func() error
+// Instance is a running binding
+type Instance interface {
+	io.Closer
+	// SyncNow requests a synchronization as soon as possible
+	SyncNow()
+}
+
+type instance struct {
+	scheduler *schedule.Scheduler
+	close     func() error
+}
 
-func (f closerFunc) Close() error {
-	return f()
+func (i *instance) SyncNow() {
+	i.scheduler.SyncNow()
+}
+
+func (i *instance) Close() error {
+	i.scheduler.Close()
+	return i.close()
 }
 
 type InstanceContext struct {
@@ -126,9 +286,90 @@ func (context InstanceContext) ConnectionStateChanged(id string, syninprogress b
 	context.StateCallback(state)
 }
 
-func BindVirtualizationInstance(id string, config *BaseConfig, remotefs afero.Fs, context InstanceContext) (io.Closer, error) {
+// Options returns the settings of the binding with the given id
+func (config *BaseConfig) Options(id string) (core.Options, error) {
+	var options core.Options
+	datadir, err := core.BindingDataDir(id)
+	if err != nil {
+		return options, err
+	}
+	statedb, err := core.StateDBPath()
+	if err != nil {
+		return options, err
+	}
+	conflict, err := config.ConflictPolicy()
+	if err != nil {
+		return options, err
+	}
+	retention, err := config.TombstoneRetentionPeriod()
+	if err != nil {
+		return options, err
+	}
+	cache, err := config.CachePolicy()
+	if err != nil {
+		return options, err
+	}
+	transfers, err := config.TransferCount()
+	if err != nil {
+		return options, err
+	}
+	trashpolicy, err := config.TrashPolicy()
+	if err != nil {
+		return options, err
+	}
+	versionpolicy, err := config.VersionPolicy()
+	if err != nil {
+		return options, err
+	}
+	hash, err := config.HashAlgorithm()
+	if err != nil {
+		return options, err
+	}
+	state, err := config.StateLocation()
+	if err != nil {
+		return options, err
+	}
+	return core.Options{ID: id, DataDir: datadir, StateDB: statedb, Conflict: conflict, TombstoneRetention: retention, Ignore: config.Ignore, Cache: cache, Transfers: transfers, Trash: trashpolicy, Versions: versionpolicy, Hash: hash, State: state, SkipHidden: config.SkipHidden}, nil
+}
+
+// DryRun plans the synchronization of the binding without changing anything on either side
+func DryRun(id string, config *BaseConfig, remotefs afero.Fs, logger zerolog.Logger) (planner.Plan, error) {
+	options, err := config.Options(id)
+	if err != nil {
+		return nil, err
+	}
+	options.ReadOnly = true
+	if config.IsCFAPI() {
+		return cfapi.DryRun(config.LocalPath, remotefs, logger, options)
+	}
+	return prjfs.DryRun(config.LocalPath, remotefs, logger, options)
+}
+
+// Fsck checks the remote of the binding and the state kept about it, see fsck.Checker
+func Fsck(id string, config *BaseConfig, remotefs afero.Fs, checkoptions fsck.Options) ([]fsck.Problem, error) {
+	options, err := config.Options(id)
+	if err != nil {
+		return nil, err
+	}
+	options.ReadOnly = !checkoptions.Repair
+	state, err := core.OpenRemoteStateCache(remotefs, options)
+	if err != nil {
+		return nil, err
+	}
+	defer core.CloseRemoteStateCache(state)
+	return fsck.New(remotefs, state, checkoptions).Check()
+}
+
+func BindVirtualizationInstance(id string, config *BaseConfig, remotefs afero.Fs, context InstanceContext) (Instance, error) {
 	var closer core.Virtualization
-	var err error
+	options, err := config.Options(id)
+	if err != nil {
+		return nil, err
+	}
+	scheduling, err := config.SyncSchedule()
+	if err != nil {
+		return nil, err
+	}
 	if config.IsCFAPI() {
 		if config.IsSimplfied() {
 			uid := uuid.NewMD5(uuid.UUID{}, []byte(id))
@@ -140,40 +381,39 @@ func BindVirtualizationInstance(id string, config *BaseConfig, remotefs afero.Fs
 		if err != nil {
 			return nil, err
 		}
-		closer, err = cfapi.StartProjecting(config.LocalPath, remotefs, context.Logger)
+		closer, err = cfapi.StartProjecting(config.LocalPath, remotefs, context.Logger, options)
 	} else {
-		closer, err = prjfs.StartProjecting(config.LocalPath, remotefs, context.Logger)
+		closer, err = prjfs.StartProjecting(config.LocalPath, remotefs, context.Logger, options)
 	}
 	if err != nil {
 		return nil, err
 	}
 	closer.SetStateCallbacks(context.FileStateCallback)
 
-	internalSynchronize := func() {
+	internalSynchronize := func() error {
 		context.ConnectionStateChanged(id, true, nil)
-		err = closer.PerformSynchronization()
+		err := closer.PerformSynchronization()
 		if err != nil {
 			context.Logger.Err(err).Send()
 			context.ConnectionStateChanged(id, false, err)
 		} else {
 			context.ConnectionStateChanged(id, false, nil)
 		}
+		return err
 	}
 
-	t := time.NewTicker(30 * time.Second)
-	go func() {
-		internalSynchronize()
-		for range t.C {
-			internalSynchronize()
-		}
-	}()
+	scheduler := schedule.New(scheduling, schedule.SystemClock, internalSynchronize)
+	closer.SetChangeCallback(scheduler.Changed)
+	scheduler.Start()
 
-	return (closerFunc)(func() error {
-		t.Stop()
-		err := closer.Close()
-		if config.IsCFAPI() && config.IsSimplfied() {
-			cfapi.UnregisterRootPathSimple(config.LocalPath)
-		}
-		return err
-	}), nil
+	return &instance{
+		scheduler: scheduler,
+		close: func() error {
+			err := closer.Close()
+			if config.IsCFAPI() && config.IsSimplfied() {
+				cfapi.UnregisterRootPathSimple(config.LocalPath)
+			}
+			return err
+		},
+	}, nil
 }
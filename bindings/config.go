@@ -1,11 +1,36 @@
 package bindings
 
+import (
+	"path/filepath"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+)
+
 type Config struct {
 	ID string
 	BaseConfig
 	BindingConfig
 }
 
+// RemoteFileSystem connects to the remote of the binding. Names Windows can not represent are encoded, see
+// utils.WindowsNamesFs, names are presented in normalized form and names differing only by case or normalization
+// are disambiguated by aliases kept in the data folder of the binding, see utils.CaseAliasFs. Aliases are not saved if
+// readonly is set, so the remote can be inspected while the binding is running.
+func (config Config) RemoteFileSystem(logger zerolog.Logger, readonly bool) (afero.Fs, error) {
+	fs, err := config.ToFileSystem(logger)
+	if err != nil {
+		return nil, err
+	}
+	datadir, err := core.BindingDataDir(config.ID)
+	if err != nil {
+		return nil, err
+	}
+	return utils.OpenCaseAliasFs(utils.NewWindowsNamesFs(fs), afero.NewOsFs(), filepath.Join(datadir, "casealiases.json"), readonly)
+}
+
 type ConfigProvider interface {
 	Keys() []string
 	ReadConfig(key string) (Config, error)
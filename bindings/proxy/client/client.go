@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -27,6 +29,21 @@ func Connect(url string, httpclient *http.Client) (afero.Fs, error) {
 	if err != nil {
 		return nil, err
 	}
-	client := proxy.NewFilesystemClientFactory(transport, protocol)
+	client := proxy.NewFilesystemClient(&serialClient{
+		TClient: thrift.NewTStandardClient(protocol.GetProtocol(transport), protocol.GetProtocol(transport)),
+	})
 	return New(client), nil
 }
+
+// serialClient runs one call at a time, the transport is shared by all calls and parallel transfers
+// would mix up their requests and responses otherwise
+type serialClient struct {
+	thrift.TClient
+	lock sync.Mutex
+}
+
+func (c *serialClient) Call(ctx context.Context, method string, args, result thrift.TStruct) (thrift.ResponseMeta, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.TClient.Call(ctx, method, args, result)
+}
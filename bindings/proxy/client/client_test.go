@@ -0,0 +1,93 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/proxy/client"
+	"github.com/balazsgrill/potatodrive/bindings/proxy/server"
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/spf13/afero"
+)
+
+func TestChangesAreListedThroughProxy(t *testing.T) {
+	watched, err := utils.WatchDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watched.Close()
+	httpserver := httptest.NewServer(http.HandlerFunc(server.Handler(watched)))
+	defer httpserver.Close()
+
+	fs, err := client.Connect(httpserver.URL, httpserver.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister, ok := fs.(core.ChangeLister)
+	if !ok {
+		t.Fatal("proxy client does not list changes")
+	}
+	_, cursor, err := lister.Changes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = afero.WriteFile(fs, "a.txt", []byte("a"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []string
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Contains(reported, "a.txt") {
+		if time.Now().After(deadline) {
+			t.Fatalf("change is not reported, got %v", reported)
+		}
+		var changes []string
+		changes, cursor, err = lister.Changes(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported = append(reported, changes...)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, _, err = lister.Changes("unknown:0")
+	if err == nil {
+		t.Error("unknown cursor is accepted")
+	}
+}
+
+func TestModTimeIsPreservedThroughProxy(t *testing.T) {
+	served := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	httpserver := httptest.NewServer(http.HandlerFunc(server.Handler(served)))
+	defer httpserver.Close()
+	fs, err := client.Connect(httpserver.URL, httpserver.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := core.HashFilesRemotely(fs)
+
+	err = afero.WriteFile(fs, "a.txt", []byte("a"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	err = core.PreserveModTime(fs, state, "a.txt", modtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := served.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modtime) {
+		t.Errorf("expected %v on the server, got %v", modtime, info.ModTime())
+	}
+	if stored, _ := state.GetModTime("a.txt"); stored != nil {
+		t.Error("modification time is stored although the proxy has set it")
+	}
+}
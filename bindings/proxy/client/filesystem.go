@@ -5,7 +5,10 @@ import (
 	"io/fs"
 	"time"
 
+	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/balazsgrill/potatodrive/bindings/proxy"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
 	"github.com/spf13/afero"
 )
 
@@ -100,3 +103,31 @@ func (f *filesystemClient) Stat(name string) (fs.FileInfo, error) {
 	fi, err := f.client.Stat(context.Background(), name)
 	return fi, eurap("stat", err)
 }
+
+var _ core.ChangeLister = (*filesystemClient)(nil)
+var _ digest.Checksummer = (*filesystemClient)(nil)
+
+// Checksum implements digest.Checksummer. Servers of earlier versions do not know the call, checksums are
+// not supported by them.
+func (f *filesystemClient) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	checksum, err := f.client.Checksum(context.Background(), name, string(algorithm))
+	if e, ok := err.(thrift.TApplicationException); ok && e.TypeId() == thrift.UNKNOWN_METHOD {
+		return digest.Digest{}, nil
+	}
+	if err != nil {
+		return digest.Digest{}, eurap("checksum", err)
+	}
+	if checksum == "" {
+		return digest.Digest{}, nil
+	}
+	return digest.ParseString(checksum)
+}
+
+// Changes implements core.ChangeLister.
+func (f *filesystemClient) Changes(cursor string) ([]string, string, error) {
+	changes, err := f.client.Changes(context.Background(), cursor)
+	if err != nil {
+		return nil, "", eurap("changes", err)
+	}
+	return changes.Paths, changes.Cursor, nil
+}
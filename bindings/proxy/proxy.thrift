@@ -16,6 +16,11 @@ struct FileInfo {
   5: bool fisDir
 }
 
+struct ChangeSet {
+  1: list<string> paths
+  2: string cursor
+}
+
 service Filesystem{
     FileHandle create(1:string name) throws(1:FilesystemException error)
     void mkdir(1:string path, 2:FileMode perm) throws(1:FilesystemException error)
@@ -30,6 +35,10 @@ service Filesystem{
     void chmod(1:string name, 2:FileMode mode) throws(1:FilesystemException error)
     void chown(1:string name, 2:i32 uid, 3:i32 gid) throws(1:FilesystemException error)
     void chtimes(1:string name, 2:Timestamp atime, 3:Timestamp mtime) throws(1:FilesystemException error)
+    // Paths changed since the cursor, see core.ChangeLister
+    ChangeSet changes(1:string cursor) throws(1:FilesystemException error)
+    // Checksum of a file as "algorithm:hex", empty if the algorithm is not supported, see digest.Checksummer
+    string checksum(1:string name, 2:string algorithm) throws(1:FilesystemException error)
 
     // File operations
     // Closer
@@ -52,4 +61,4 @@ service Filesystem{
     void fsync(1:FileHandle file) throws(1:FilesystemException error)
     void ftruncate(1:FileHandle file, 2:i64 size) throws(1:FilesystemException error)
     i32 fwriteString(1:FileHandle file, 2:string value) throws(1:FilesystemException error)
-}
\ No newline at end of file
+}
\ No newline at end of file
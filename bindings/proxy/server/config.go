@@ -4,7 +4,7 @@ import (
 	"errors"
 	"net/http"
 
-	"github.com/spf13/afero"
+	"github.com/balazsgrill/potatodrive/bindings/utils"
 )
 
 type Config struct {
@@ -31,7 +31,11 @@ func (c *Config) Validate() error {
 }
 
 func (c *Config) ToHandler() (string, http.HandlerFunc, error) {
-	fs := afero.NewBasePathFs(afero.NewOsFs(), c.Directory)
+	// changes of the directory are recorded, so clients do not need to walk the whole tree on every synchronization
+	fs, err := utils.WatchDir(c.Directory)
+	if err != nil {
+		return "", nil, err
+	}
 	return c.Pattern, c.authMiddleware(Handler(fs)), nil
 }
 
@@ -8,7 +8,7 @@ import (
 )
 
 func (fs *FilesystemServer) Fclose(ctx context.Context, file proxy.FileHandle) (_err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return os.ErrInvalid
 	}
@@ -16,12 +16,12 @@ func (fs *FilesystemServer) Fclose(ctx context.Context, file proxy.FileHandle) (
 	if err != nil {
 		return ewrap(err)
 	}
-	delete(fs.openfiles, file)
+	fs.forget(file)
 	return nil
 }
 
 func (fs *FilesystemServer) Fname(ctx context.Context, file proxy.FileHandle) (_r string, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return "", os.ErrInvalid
 	}
@@ -29,7 +29,7 @@ func (fs *FilesystemServer) Fname(ctx context.Context, file proxy.FileHandle) (_
 }
 
 func (fs *FilesystemServer) Fread(ctx context.Context, file proxy.FileHandle, bufferSize int64) (_r []byte, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return nil, os.ErrInvalid
 	}
@@ -39,7 +39,7 @@ func (fs *FilesystemServer) Fread(ctx context.Context, file proxy.FileHandle, bu
 }
 
 func (fs *FilesystemServer) FreadAt(ctx context.Context, file proxy.FileHandle, bufferSize int64, offset int64) (_r []byte, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return nil, os.ErrInvalid
 	}
@@ -50,7 +50,7 @@ func (fs *FilesystemServer) FreadAt(ctx context.Context, file proxy.FileHandle,
 
 // Freaddir implements proxy.Filesystem.
 func (fs *FilesystemServer) Freaddir(ctx context.Context, file proxy.FileHandle, count int32) (_r []*proxy.FileInfo, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return nil, os.ErrInvalid
 	}
@@ -65,7 +65,7 @@ func (fs *FilesystemServer) Freaddir(ctx context.Context, file proxy.FileHandle,
 }
 
 func (fs *FilesystemServer) Freaddirnames(ctx context.Context, file proxy.FileHandle, count int32) (_r []string, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return nil, os.ErrInvalid
 	}
@@ -74,7 +74,7 @@ func (fs *FilesystemServer) Freaddirnames(ctx context.Context, file proxy.FileHa
 }
 
 func (fs *FilesystemServer) Fseek(ctx context.Context, file proxy.FileHandle, offset int64, whence int32) (_r int64, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return 0, os.ErrInvalid
 	}
@@ -83,7 +83,7 @@ func (fs *FilesystemServer) Fseek(ctx context.Context, file proxy.FileHandle, of
 }
 
 func (fs *FilesystemServer) Fstat(ctx context.Context, file proxy.FileHandle) (_r *proxy.FileInfo, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return nil, os.ErrInvalid
 	}
@@ -95,7 +95,7 @@ func (fs *FilesystemServer) Fstat(ctx context.Context, file proxy.FileHandle) (_
 }
 
 func (fs *FilesystemServer) Fsync(ctx context.Context, file proxy.FileHandle) (_err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return os.ErrInvalid
 	}
@@ -103,7 +103,7 @@ func (fs *FilesystemServer) Fsync(ctx context.Context, file proxy.FileHandle) (_
 }
 
 func (fs *FilesystemServer) Ftruncate(ctx context.Context, file proxy.FileHandle, size int64) (_err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return os.ErrInvalid
 	}
@@ -111,7 +111,7 @@ func (fs *FilesystemServer) Ftruncate(ctx context.Context, file proxy.FileHandle
 }
 
 func (fs *FilesystemServer) Fwrite(ctx context.Context, file proxy.FileHandle, buffer []byte) (_r int32, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return 0, os.ErrInvalid
 	}
@@ -120,7 +120,7 @@ func (fs *FilesystemServer) Fwrite(ctx context.Context, file proxy.FileHandle, b
 }
 
 func (fs *FilesystemServer) FwriteAt(ctx context.Context, file proxy.FileHandle, buffer []byte, offset int64) (_r int32, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return 0, os.ErrInvalid
 	}
@@ -129,7 +129,7 @@ func (fs *FilesystemServer) FwriteAt(ctx context.Context, file proxy.FileHandle,
 }
 
 func (fs *FilesystemServer) FwriteString(ctx context.Context, file proxy.FileHandle, value string) (_r int32, _err error) {
-	f, opened := fs.openfiles[file]
+	f, opened := fs.file(file)
 	if !opened {
 		return 0, os.ErrInvalid
 	}
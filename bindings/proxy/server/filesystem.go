@@ -2,14 +2,20 @@ package server
 
 import (
 	"context"
+	"errors"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/balazsgrill/potatodrive/bindings/proxy"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
 	"github.com/spf13/afero"
 )
 
 type FilesystemServer struct {
+	// lock guards open files, requests are served in parallel
+	lock      sync.Mutex
 	openfiles map[proxy.FileHandle]afero.File
 	count     int32
 	fs        afero.Fs
@@ -25,6 +31,29 @@ func New(fs afero.Fs) proxy.Filesystem {
 
 var _ proxy.Filesystem = (*FilesystemServer)(nil)
 
+// register assigns a new handle to an open file
+func (fs *FilesystemServer) register(file afero.File) proxy.FileHandle {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.count++
+	handle := proxy.FileHandle(fs.count)
+	fs.openfiles[handle] = file
+	return handle
+}
+
+func (fs *FilesystemServer) file(handle proxy.FileHandle) (afero.File, bool) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, opened := fs.openfiles[handle]
+	return file, opened
+}
+
+func (fs *FilesystemServer) forget(handle proxy.FileHandle) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	delete(fs.openfiles, handle)
+}
+
 func (fs *FilesystemServer) Chown(ctx context.Context, name string, uid int32, gid int32) (_err error) {
 	return ewrap(fs.fs.Chown(name, int(uid), int(gid)))
 }
@@ -33,15 +62,49 @@ func (fs *FilesystemServer) Chtimes(ctx context.Context, name string, atime prox
 	return ewrap(fs.fs.Chtimes(name, time.UnixMicro(int64(atime)), time.UnixMicro(int64(mtime))))
 }
 
+// Changes lists the changes of the served file system if it implements core.ChangeLister
+func (fs *FilesystemServer) Changes(ctx context.Context, cursor string) (_r *proxy.ChangeSet, _err error) {
+	lister, ok := fs.fs.(core.ChangeLister)
+	if !ok {
+		return nil, ewrap(errors.New("listing changes is not supported"))
+	}
+	paths, next, err := lister.Changes(cursor)
+	if err != nil {
+		return nil, ewrap(err)
+	}
+	return &proxy.ChangeSet{Paths: paths, Cursor: next}, nil
+}
+
+// Checksum returns the checksum calculated by the served file system, or reads the file on the server if it can not
+// calculate it, so the client does not have to download it
+func (fs *FilesystemServer) Checksum(ctx context.Context, name string, algorithm string) (_r string, _err error) {
+	hash, err := digest.Native(fs.fs, name, digest.Algorithm(algorithm))
+	if err != nil {
+		return "", ewrap(err)
+	}
+	if hash.IsZero() {
+		file, err := fs.fs.Open(name)
+		if err != nil {
+			return "", ewrap(err)
+		}
+		defer file.Close()
+		hash, err = digest.Sum(digest.Algorithm(algorithm), file)
+		if err != nil {
+			return "", ewrap(err)
+		}
+	}
+	if hash.IsZero() {
+		return "", nil
+	}
+	return hash.String(), nil
+}
+
 func (fs *FilesystemServer) Create(ctx context.Context, name string) (_r proxy.FileHandle, _err error) {
 	file, err := fs.fs.Create(name)
 	if err != nil {
 		return 0, ewrap(err)
 	}
-	fs.count++
-	handle := proxy.FileHandle(fs.count)
-	fs.openfiles[handle] = file
-	return handle, nil
+	return fs.register(file), nil
 }
 
 func (fs *FilesystemServer) Mkdir(ctx context.Context, path string, perm proxy.FileMode) (_err error) {
@@ -61,10 +124,7 @@ func (fs *FilesystemServer) Open(ctx context.Context, name string) (_r proxy.Fil
 	if err != nil {
 		return 0, ewrap(err)
 	}
-	fs.count++
-	handle := proxy.FileHandle(fs.count)
-	fs.openfiles[handle] = file
-	return handle, nil
+	return fs.register(file), nil
 }
 
 func (fs *FilesystemServer) OpenFile(ctx context.Context, name string, flag int32, perm proxy.FileMode) (_r proxy.FileHandle, _err error) {
@@ -72,10 +132,7 @@ func (fs *FilesystemServer) OpenFile(ctx context.Context, name string, flag int3
 	if err != nil {
 		return 0, ewrap(err)
 	}
-	fs.count++
-	handle := proxy.FileHandle(fs.count)
-	fs.openfiles[handle] = file
-	return handle, nil
+	return fs.register(file), nil
 }
 
 func (fs *FilesystemServer) Remove(ctx context.Context, name string) (_err error) {
@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/balazsgrill/potatodrive/core/digest"
+)
+
+var _ digest.Checksummer = (*renamingFs)(nil)
+
+// Checksum implements digest.Checksummer from the metadata of the object. The ETag is the md5 sum of the content
+// only if the object is uploaded at once and not encrypted by KMS or a customer key. A sha256 checksum is known
+// if it has been given on upload; checksums of multipart uploads are checksums of the parts, those are not usable.
+func (fs *renamingFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	if algorithm != digest.MD5 && algorithm != digest.SHA256 {
+		return digest.Digest{}, nil
+	}
+	head, err := fs.api.HeadObject(&awss3.HeadObjectInput{
+		Bucket:       aws.String(fs.bucket),
+		Key:          aws.String(name),
+		ChecksumMode: aws.String(awss3.ChecksumModeEnabled),
+	})
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == http.StatusNotFound {
+		return digest.Digest{}, &os.PathError{Op: "checksum", Path: name, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	switch algorithm {
+	case digest.MD5:
+		etag := strings.Trim(aws.StringValue(head.ETag), `"`)
+		sse := aws.StringValue(head.ServerSideEncryption)
+		if strings.Contains(etag, "-") || (sse != "" && sse != awss3.ServerSideEncryptionAes256) || head.SSECustomerAlgorithm != nil {
+			return digest.Digest{}, nil
+		}
+		sum, err := hex.DecodeString(etag)
+		if err != nil || len(sum) != 16 {
+			return digest.Digest{}, nil
+		}
+		return digest.Digest{Algorithm: digest.MD5, Sum: sum}, nil
+	case digest.SHA256:
+		checksum := aws.StringValue(head.ChecksumSHA256)
+		if checksum == "" || strings.Contains(checksum, "-") {
+			return digest.Digest{}, nil
+		}
+		sum, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil {
+			return digest.Digest{}, nil
+		}
+		return digest.Digest{Algorithm: digest.SHA256, Sum: sum}, nil
+	}
+	return digest.Digest{}, nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/balazsgrill/potatodrive/bindings/utils"
 	s3 "github.com/fclairamb/afero-s3"
 	"github.com/rs/zerolog"
@@ -54,6 +55,6 @@ func (c *Config) ToFileSystem(logger zerolog.Logger) (afero.Fs, error) {
 
 	fs := s3.NewFs(c.Bucket, sess)
 	fs.MkdirAll("root", 0777)
-	rootfs := utils.NewBasePathFs(fs, "root")
+	rootfs := utils.NewBasePathFs(&renamingFs{Fs: fs, api: awss3.New(sess), bucket: c.Bucket}, "root")
 	return rootfs, nil
 }
@@ -0,0 +1,39 @@
+package s3
+
+import (
+	"net/url"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/spf13/afero"
+)
+
+// renamingFs renames objects by a server-side copy. The Rename of afero-s3 only works for keys starting with "/".
+// Directories can not be renamed this way, those are moved object by object by utils.Move.
+type renamingFs struct {
+	afero.Fs
+	api    s3iface.S3API
+	bucket string
+}
+
+func (fs *renamingFs) Rename(oldname string, newname string) error {
+	if oldname == newname {
+		return nil
+	}
+	source := url.URL{Path: path.Join(fs.bucket, oldname)}
+	_, err := fs.api.CopyObject(&awss3.CopyObjectInput{
+		Bucket:     aws.String(fs.bucket),
+		CopySource: aws.String(source.EscapedPath()),
+		Key:        aws.String(newname),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fs.api.DeleteObject(&awss3.DeleteObjectInput{
+		Bucket: aws.String(fs.bucket),
+		Key:    aws.String(oldname),
+	})
+	return err
+}
@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"bytes"
+	"mime"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/balazsgrill/potatodrive/core/upload"
+)
+
+// partSize is the size of the parts of multipart uploads, S3 requires at least 5MB for all but the last one
+const partSize = 8 * 1024 * 1024
+
+var _ upload.Resumer = (*renamingFs)(nil)
+
+// objectUpload uploads an object by a single request on Commit, an interrupted upload starts over
+type objectUpload struct {
+	api    s3iface.S3API
+	bucket string
+	key    string
+	buffer []byte
+}
+
+func (u *objectUpload) Write(p []byte) (int, error) {
+	u.buffer = append(u.buffer, p...)
+	return len(p), nil
+}
+
+func (u *objectUpload) Offset() int64 {
+	return 0
+}
+
+func (u *objectUpload) Session() string {
+	return ""
+}
+
+func (u *objectUpload) Commit() error {
+	_, err := u.api.PutObject(&awss3.PutObjectInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(u.key),
+		ContentType: aws.String(mime.TypeByExtension(path.Ext(u.key))),
+		Body:        bytes.NewReader(u.buffer),
+	})
+	u.buffer = nil
+	return err
+}
+
+func (u *objectUpload) Close() error {
+	u.buffer = nil
+	return nil
+}
+
+// multipartUpload uploads an object part by part, acknowledged parts are kept by S3 until the upload is completed or aborted
+type multipartUpload struct {
+	api      s3iface.S3API
+	bucket   string
+	key      string
+	uploadID string
+	parts    []*awss3.CompletedPart
+	// offset is the size of the uploaded parts
+	offset int64
+	buffer []byte
+}
+
+// ResumeUpload implements upload.Resumer by S3 multipart uploads. Files smaller than a part are uploaded at once,
+// so the ETag of the object is its md5 sum.
+func (fs *renamingFs) ResumeUpload(name string, checkpoint upload.Checkpoint) (upload.Target, error) {
+	if checkpoint.Session == "" && checkpoint.Size < partSize {
+		return &objectUpload{api: fs.api, bucket: fs.bucket, key: name}, nil
+	}
+	u := &multipartUpload{
+		api:    fs.api,
+		bucket: fs.bucket,
+		key:    name,
+	}
+	if checkpoint.Session != "" && u.listParts(checkpoint.Session) == nil {
+		return u, nil
+	}
+	output, err := fs.api.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+		Bucket:      aws.String(fs.bucket),
+		Key:         aws.String(name),
+		ContentType: aws.String(mime.TypeByExtension(path.Ext(name))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.uploadID = aws.StringValue(output.UploadId)
+	return u, nil
+}
+
+// AbortUpload implements upload.Resumer
+func (fs *renamingFs) AbortUpload(name string, checkpoint upload.Checkpoint) error {
+	if checkpoint.Session == "" {
+		return nil
+	}
+	_, err := fs.api.AbortMultipartUpload(&awss3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(checkpoint.Session),
+	})
+	return err
+}
+
+// listParts continues an existing upload after the parts acknowledged in order
+func (u *multipartUpload) listParts(uploadID string) error {
+	var parts []*awss3.Part
+	err := u.api.ListPartsPages(&awss3.ListPartsInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(u.key),
+		UploadId: aws.String(uploadID),
+	}, func(output *awss3.ListPartsOutput, last bool) bool {
+		parts = append(parts, output.Parts...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	u.uploadID = uploadID
+	for i, part := range parts {
+		// parts are listed by number, a missing or short part ends the usable sequence
+		if aws.Int64Value(part.PartNumber) != int64(i+1) || aws.Int64Value(part.Size) != partSize {
+			break
+		}
+		u.parts = append(u.parts, &awss3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		u.offset += partSize
+	}
+	return nil
+}
+
+func (u *multipartUpload) Write(p []byte) (int, error) {
+	u.buffer = append(u.buffer, p...)
+	for len(u.buffer) >= partSize {
+		err := u.uploadPart(u.buffer[:partSize])
+		if err != nil {
+			return 0, err
+		}
+		u.buffer = u.buffer[partSize:]
+	}
+	return len(p), nil
+}
+
+func (u *multipartUpload) uploadPart(data []byte) error {
+	number := aws.Int64(int64(len(u.parts) + 1))
+	output, err := u.api.UploadPart(&awss3.UploadPartInput{
+		Bucket:     aws.String(u.bucket),
+		Key:        aws.String(u.key),
+		UploadId:   aws.String(u.uploadID),
+		PartNumber: number,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+	u.parts = append(u.parts, &awss3.CompletedPart{ETag: output.ETag, PartNumber: number})
+	u.offset += int64(len(data))
+	return nil
+}
+
+func (u *multipartUpload) Offset() int64 {
+	return u.offset
+}
+
+func (u *multipartUpload) Session() string {
+	return u.uploadID
+}
+
+func (u *multipartUpload) Commit() error {
+	// the last part may be smaller, an empty object still needs one part
+	if len(u.buffer) > 0 || len(u.parts) == 0 {
+		err := u.uploadPart(u.buffer)
+		if err != nil {
+			return err
+		}
+		u.buffer = nil
+	}
+	_, err := u.api.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(u.key),
+		UploadId:        aws.String(u.uploadID),
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: u.parts},
+	})
+	return err
+}
+
+func (u *multipartUpload) Close() error {
+	// buffered data is not acknowledged, it is uploaded again when the upload is continued
+	u.buffer = nil
+	return nil
+}
@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/balazsgrill/potatodrive/core/upload"
+)
+
+var _ upload.Versioner = (*renamingFs)(nil)
+
+// ReadVersion implements upload.Versioner, the version of an object is its ETag
+func (fs *renamingFs) ReadVersion(name string) ([]byte, string, error) {
+	output, err := fs.api.GetObject(&awss3.GetObjectInput{
+		Bucket: aws.String(fs.bucket),
+		Key:    aws.String(name),
+	})
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == http.StatusNotFound {
+		return nil, "", &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, aws.StringValue(output.ETag), nil
+}
+
+// WriteVersion implements upload.Versioner by the conditional writes of S3. The SDK does not know the headers of
+// conditional writes yet, they are set on the request.
+func (fs *renamingFs) WriteVersion(name string, data []byte, version string) error {
+	request, _ := fs.api.PutObjectRequest(&awss3.PutObjectInput{
+		Bucket:      aws.String(fs.bucket),
+		Key:         aws.String(name),
+		ContentType: aws.String(mime.TypeByExtension(path.Ext(name))),
+		Body:        bytes.NewReader(data),
+	})
+	if version == "" {
+		request.HTTPRequest.Header.Set("If-None-Match", "*")
+	} else {
+		request.HTTPRequest.Header.Set("If-Match", version)
+	}
+	err := request.Send()
+	if failure, ok := err.(awserr.RequestFailure); ok && (failure.StatusCode() == http.StatusPreconditionFailed || failure.StatusCode() == http.StatusConflict) {
+		return upload.ErrVersionChanged
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package sftp
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ digest.Checksummer = (*posixRenameFs)(nil)
+
+// checksumCommands are the commands calculating the checksum of a file on the server
+var checksumCommands = map[digest.Algorithm]string{
+	digest.MD5:    "md5sum",
+	digest.SHA256: "sha256sum",
+}
+
+// exitCommandNotFound is the exit status of the shell if the command is not available
+const exitCommandNotFound = 127
+
+// remoteCommands runs checksum commands on the server, it remembers those that are not available
+type remoteCommands struct {
+	conn        *ssh.Client
+	lock        sync.Mutex
+	unsupported map[string]bool
+}
+
+func (r *remoteCommands) isSupported(command string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !r.unsupported[command]
+}
+
+func (r *remoteCommands) setUnsupported(command string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.unsupported == nil {
+		r.unsupported = make(map[string]bool)
+	}
+	r.unsupported[command] = true
+}
+
+// quote makes the name a single argument of the shell
+func quote(name string) string {
+	return "'" + strings.ReplaceAll(name, "'", `'\''`) + "'"
+}
+
+// Checksum implements digest.Checksummer by running md5sum or sha256sum on the server. The digest is zero if the
+// server does not allow running commands or the file can not be read.
+func (fs *posixRenameFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	command, ok := checksumCommands[algorithm]
+	if !ok || fs.commands == nil || !fs.commands.isSupported(command) {
+		return digest.Digest{}, nil
+	}
+	session, err := fs.commands.conn.NewSession()
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	defer session.Close()
+	output, err := session.Output(command + " " + quote(name))
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && exit.ExitStatus() == exitCommandNotFound {
+		fs.commands.setUnsupported(command)
+		return digest.Digest{}, nil
+	}
+	if err != nil {
+		return digest.Digest{}, nil
+	}
+	// the output is the hex checksum followed by the name
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return digest.Digest{}, nil
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(fields[0], `\`))
+	if err != nil || len(sum) != algorithm.New().Size() {
+		return digest.Digest{}, nil
+	}
+	return digest.Digest{Algorithm: algorithm, Sum: sum}, nil
+}
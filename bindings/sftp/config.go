@@ -89,7 +89,7 @@ func (c *configWithLogger) Connect(onDisconnect func(error)) (afero.Fs, error) {
 		onDisconnect(err)
 	}()
 
-	return sftpfs.New(client), nil
+	return &posixRenameFs{Fs: sftpfs.New(client), client: client, commands: &remoteCommands{conn: conn}}, nil
 }
 
 func (c *Config) ToFileSystem(logger zerolog.Logger) (afero.Fs, error) {
@@ -0,0 +1,24 @@
+package sftp
+
+import (
+	sftpclient "github.com/pkg/sftp"
+	"github.com/spf13/afero"
+)
+
+// posixRenameFs replaces existing files on rename. The plain SFTP rename fails if the target exists,
+// servers supporting the posix-rename extension replace it atomically.
+type posixRenameFs struct {
+	afero.Fs
+	client *sftpclient.Client
+	// commands calculates checksums on the server, it is nil if not connected by ssh
+	commands *remoteCommands
+}
+
+func (fs *posixRenameFs) Rename(oldname string, newname string) error {
+	err := fs.client.PosixRename(oldname, newname)
+	if err == nil {
+		return nil
+	}
+	// the server may not support the extension
+	return fs.Fs.Rename(oldname, newname)
+}
@@ -11,12 +11,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/upload"
 	"github.com/spf13/afero"
 )
 
 var (
-	_ afero.Lstater  = (*BasePathFs)(nil)
-	_ fs.ReadDirFile = (*BasePathFile)(nil)
+	_ afero.Lstater      = (*BasePathFs)(nil)
+	_ fs.ReadDirFile     = (*BasePathFile)(nil)
+	_ upload.Resumer     = (*BasePathFs)(nil)
+	_ digest.Checksummer = (*BasePathFs)(nil)
 )
 
 // The BasePathFs restricts all operations to a given path within an Fs.
@@ -256,3 +260,48 @@ func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
 	}
 	return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
 }
+
+// ResumeUpload implements upload.Resumer, native uploads of the source are used if it supports them
+func (b *BasePathFs) ResumeUpload(name string, checkpoint upload.Checkpoint) (upload.Target, error) {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "upload", Path: name, Err: err}
+	}
+	return upload.OpenTarget(b.source, name, checkpoint)
+}
+
+// AbortUpload implements upload.Resumer
+func (b *BasePathFs) AbortUpload(name string, checkpoint upload.Checkpoint) error {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return &os.PathError{Op: "upload", Path: name, Err: err}
+	}
+	return upload.AbortTarget(b.source, name, checkpoint)
+}
+
+// ReadVersion implements upload.Versioner, versions are known if the source supports them
+func (b *BasePathFs) ReadVersion(name string) ([]byte, string, error) {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return nil, "", &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return upload.ReadVersion(b.source, name)
+}
+
+// WriteVersion implements upload.Versioner, errors.ErrUnsupported is returned if the source does not support versions
+func (b *BasePathFs) WriteVersion(name string, data []byte, version string) error {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	return upload.WriteVersion(b.source, name, data, version)
+}
+
+// Checksum implements digest.Checksummer, checksums are calculated by the source if it supports them
+func (b *BasePathFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	name, err := b.RealPath(name)
+	if err != nil {
+		return digest.Digest{}, &os.PathError{Op: "checksum", Path: name, Err: err}
+	}
+	return digest.Native(b.source, name, algorithm)
+}
@@ -0,0 +1,477 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/names"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+var (
+	_ afero.Lstater      = (*CaseAliasFs)(nil)
+	_ upload.Resumer     = (*CaseAliasFs)(nil)
+	_ digest.Checksummer = (*CaseAliasFs)(nil)
+	_ fs.ReadDirFile     = (*caseAliasFile)(nil)
+)
+
+// CaseAliasFs presents the files of the source whose names differ from another one in the same directory only by case
+// or Unicode normalization under a disambiguated name, and other names in normalized form (see names.CaseAliases), so
+// a case-sensitive remote can be projected to a case-insensitive local folder, and names created by macOS match the
+// names Windows creates. Aliases are assigned when a directory is listed and remembered in a file, so uploads of an
+// aliased file reach the remote file it stands for even before the directory is listed again.
+type CaseAliasFs struct {
+	source afero.Fs
+	store  afero.Fs
+	// filename is where the aliases are remembered, they are kept in memory only if empty
+	filename string
+	// readonly keeps changes of the aliases loaded from filename in memory, so inspecting a remote does not overwrite
+	// the aliases of a running binding
+	readonly bool
+
+	lock sync.Mutex
+	// aliases are the local names of remote files presented differently by remote name, in each directory by its
+	// local path
+	aliases map[string]map[string]string
+}
+
+// changeListingCaseAliasFs lists the changes of a source implementing core.ChangeLister
+type changeListingCaseAliasFs struct {
+	*CaseAliasFs
+}
+
+// OpenCaseAliasFs loads the aliases remembered in the given file of the store, and presents the source with them.
+// Changed aliases are not written back to the file if readonly is set.
+func OpenCaseAliasFs(source afero.Fs, store afero.Fs, filename string, readonly bool) (afero.Fs, error) {
+	c := &CaseAliasFs{
+		source:   source,
+		store:    store,
+		filename: filename,
+		readonly: readonly,
+		aliases:  make(map[string]map[string]string),
+	}
+	if filename != "" {
+		data, err := afero.ReadFile(store, filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &c.aliases)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, ok := source.(changeLister); ok {
+		return changeListingCaseAliasFs{c}, nil
+	}
+	return c, nil
+}
+
+// Changes implements core.ChangeLister. The parent directory of each change is listed, so a new file colliding with
+// an existing one is reported by its alias.
+func (c changeListingCaseAliasFs) Changes(cursor string) ([]string, string, error) {
+	changes, next, err := c.source.(changeLister).Changes(cursor)
+	for i, change := range changes {
+		change = strings.Trim(change, "/")
+		if change == "" {
+			continue
+		}
+		listerr := c.list(c.LocalPath(path.Dir(change)))
+		if listerr != nil && !os.IsNotExist(listerr) {
+			return nil, "", listerr
+		}
+		changes[i] = c.LocalPath(change)
+	}
+	return changes, next, err
+}
+
+// segments splits a path to its names, the root is ""
+func segments(name string) []string {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	if name == "" || name == "." {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// RemotePath returns the path of the source a local path stands for
+func (c *CaseAliasFs) RemotePath(name string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	localdir := ""
+	var remote []string
+	for _, segment := range segments(name) {
+		remote = append(remote, remoteName(c.aliases[localdir], segment))
+		localdir = path.Join(localdir, segment)
+	}
+	return strings.Join(remote, "/")
+}
+
+// LocalPath returns the path a path of the source is presented at
+func (c *CaseAliasFs) LocalPath(remotepath string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	localdir := ""
+	for _, segment := range segments(remotepath) {
+		localdir = path.Join(localdir, localName(c.aliases[localdir], segment))
+	}
+	return localdir
+}
+
+// localName returns the name a file of the source is presented by in a directory with the given aliases. Hidden files
+// kept next to a remote file, like its hash, follow the name of that file.
+func localName(aliases map[string]string, remotename string) string {
+	if alias, ok := aliases[remotename]; ok {
+		return alias
+	}
+	if prefix, target, ok := metadata.SplitPrefix(remotename); ok {
+		if alias, ok := aliases[target]; ok {
+			return prefix + alias
+		}
+	}
+	return remotename
+}
+
+// remoteName returns the name of the file of the source presented by a local name, the reverse of localName
+func remoteName(aliases map[string]string, localname string) string {
+	prefix, target, _ := metadata.SplitPrefix(localname)
+	for original, alias := range aliases {
+		if alias == localname {
+			return original
+		}
+		if prefix != "" && alias == target {
+			return prefix + original
+		}
+	}
+	return localname
+}
+
+// CaseAlias implements core.CaseAliaser. Names only presented in normalized form are not disambiguated ones.
+func (c *CaseAliasFs) CaseAlias(name string) (string, bool) {
+	localdir, localname := path.Split(strings.Trim(filepath.ToSlash(name), "/"))
+	localdir = strings.TrimSuffix(localdir, "/")
+	c.lock.Lock()
+	aliased := false
+	for original, alias := range c.aliases[localdir] {
+		if alias == localname {
+			aliased = names.IsCollisionAlias(original, alias)
+			break
+		}
+	}
+	c.lock.Unlock()
+	if !aliased {
+		return "", false
+	}
+	return c.RemotePath(name), true
+}
+
+// assign decides the aliases of a listed directory, and remembers them if changed
+func (c *CaseAliasFs) assign(localdir string, remotenames []string) (map[string]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	previous := c.aliases[localdir]
+	// names of PotatoDrive are never projected, so they do not collide
+	remotenames = slices.DeleteFunc(slices.Clone(remotenames), metadata.IsReserved)
+	aliases := names.CaseAliases(remotenames, previous)
+	if len(aliases) == len(previous) {
+		unchanged := true
+		for name, alias := range aliases {
+			if previous[name] != alias {
+				unchanged = false
+				break
+			}
+		}
+		if unchanged {
+			return aliases, nil
+		}
+	}
+	if len(aliases) == 0 {
+		delete(c.aliases, localdir)
+	} else {
+		c.aliases[localdir] = aliases
+	}
+	return aliases, c.save()
+}
+
+// forget drops the aliases of a local path and everything below it, and moves them to newpath if it is not empty
+func (c *CaseAliasFs) forget(name string, newpath string) error {
+	name = strings.Join(segments(name), "/")
+	newpath = strings.Join(segments(newpath), "/")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	localdir, localname := path.Split(name)
+	localdir = strings.TrimSuffix(localdir, "/")
+	changed := false
+	for original, alias := range c.aliases[localdir] {
+		if alias == localname {
+			delete(c.aliases[localdir], original)
+			changed = true
+		}
+	}
+	if len(c.aliases[localdir]) == 0 {
+		delete(c.aliases, localdir)
+	}
+	for dir, aliases := range c.aliases {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(c.aliases, dir)
+			if newpath != "" {
+				c.aliases[newpath+strings.TrimPrefix(dir, name)] = aliases
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the aliases to a temporary file first, then replaces the previous one. The lock is held by the caller.
+func (c *CaseAliasFs) save() error {
+	if c.filename == "" || c.readonly {
+		return nil
+	}
+	data, err := json.Marshal(c.aliases)
+	if err != nil {
+		return err
+	}
+	err = c.store.MkdirAll(filepath.Dir(c.filename), 0777)
+	if err != nil {
+		return err
+	}
+	tmpfile := c.filename + ".tmp"
+	err = afero.WriteFile(c.store, tmpfile, data, 0666)
+	if err != nil {
+		return err
+	}
+	return c.store.Rename(tmpfile, c.filename)
+}
+
+// list assigns the aliases of a directory of the source
+func (c *CaseAliasFs) list(localdir string) error {
+	dir, err := c.source.Open(c.RemotePath(localdir))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	remotenames, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	_, err = c.assign(localdir, remotenames)
+	return err
+}
+
+// caseAliasFileInfo reports a file by its local name
+type caseAliasFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i caseAliasFileInfo) Name() string {
+	return i.name
+}
+
+// caseAliasFile lists a directory of the source with the aliases of its entries. The whole directory is read by the
+// first call, because aliases depend on all names.
+type caseAliasFile struct {
+	afero.File
+	fs        *CaseAliasFs
+	localpath string
+	listed    bool
+	entries   []os.FileInfo
+}
+
+func (f *caseAliasFile) Name() string {
+	return f.localpath
+}
+
+func (f *caseAliasFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	return f.fs.wrapInfo(f.localpath, info, err)
+}
+
+func (f *caseAliasFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.listed {
+		entries, err := f.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		remotenames := make([]string, len(entries))
+		for i, entry := range entries {
+			remotenames[i] = entry.Name()
+		}
+		aliases, err := f.fs.assign(f.localpath, remotenames)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			if localname := localName(aliases, entry.Name()); localname != entry.Name() {
+				entries[i] = caseAliasFileInfo{entry, localname}
+			}
+		}
+		f.entries = entries
+		f.listed = true
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(f.entries))
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *caseAliasFile) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	localnames := make([]string, len(entries))
+	for i, entry := range entries {
+		localnames[i] = entry.Name()
+	}
+	return localnames, err
+}
+
+func (f *caseAliasFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return readDirFile{f}.ReadDir(n)
+}
+
+func (c *CaseAliasFs) wrapFile(name string, file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &caseAliasFile{File: file, fs: c, localpath: strings.Join(segments(name), "/")}, nil
+}
+
+func (c *CaseAliasFs) wrapInfo(name string, info os.FileInfo, err error) (os.FileInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	localpath := segments(name)
+	if len(localpath) > 0 && localpath[len(localpath)-1] != info.Name() {
+		return caseAliasFileInfo{info, localpath[len(localpath)-1]}, nil
+	}
+	return info, nil
+}
+
+func (c *CaseAliasFs) Name() string {
+	return "CaseAliasFs"
+}
+
+func (c *CaseAliasFs) Create(name string) (afero.File, error) {
+	file, err := c.source.Create(c.RemotePath(name))
+	return c.wrapFile(name, file, err)
+}
+
+func (c *CaseAliasFs) Mkdir(name string, perm os.FileMode) error {
+	return c.source.Mkdir(c.RemotePath(name), perm)
+}
+
+func (c *CaseAliasFs) MkdirAll(name string, perm os.FileMode) error {
+	return c.source.MkdirAll(c.RemotePath(name), perm)
+}
+
+func (c *CaseAliasFs) Open(name string) (afero.File, error) {
+	file, err := c.source.Open(c.RemotePath(name))
+	return c.wrapFile(name, file, err)
+}
+
+func (c *CaseAliasFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := c.source.OpenFile(c.RemotePath(name), flag, perm)
+	return c.wrapFile(name, file, err)
+}
+
+func (c *CaseAliasFs) Remove(name string) error {
+	err := c.source.Remove(c.RemotePath(name))
+	if err != nil {
+		return err
+	}
+	return c.forget(name, "")
+}
+
+func (c *CaseAliasFs) RemoveAll(name string) error {
+	err := c.source.RemoveAll(c.RemotePath(name))
+	if err != nil {
+		return err
+	}
+	return c.forget(name, "")
+}
+
+// Rename moves the file in the source, an alias of the old name is not kept for the new one
+func (c *CaseAliasFs) Rename(oldname string, newname string) error {
+	err := c.source.Rename(c.RemotePath(oldname), c.RemotePath(newname))
+	if err != nil {
+		return err
+	}
+	return c.forget(oldname, newname)
+}
+
+func (c *CaseAliasFs) Stat(name string) (os.FileInfo, error) {
+	info, err := c.source.Stat(c.RemotePath(name))
+	return c.wrapInfo(name, info, err)
+}
+
+func (c *CaseAliasFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	remotepath := c.RemotePath(name)
+	if lstater, ok := c.source.(afero.Lstater); ok {
+		info, lstat, err := lstater.LstatIfPossible(remotepath)
+		info, err = c.wrapInfo(name, info, err)
+		return info, lstat, err
+	}
+	info, err := c.source.Stat(remotepath)
+	info, err = c.wrapInfo(name, info, err)
+	return info, false, err
+}
+
+func (c *CaseAliasFs) Chmod(name string, mode os.FileMode) error {
+	return c.source.Chmod(c.RemotePath(name), mode)
+}
+
+func (c *CaseAliasFs) Chown(name string, uid int, gid int) error {
+	return c.source.Chown(c.RemotePath(name), uid, gid)
+}
+
+func (c *CaseAliasFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.source.Chtimes(c.RemotePath(name), atime, mtime)
+}
+
+// ResumeUpload implements upload.Resumer, native uploads of the source are used if it supports them
+func (c *CaseAliasFs) ResumeUpload(name string, checkpoint upload.Checkpoint) (upload.Target, error) {
+	return upload.OpenTarget(c.source, c.RemotePath(name), checkpoint)
+}
+
+// AbortUpload implements upload.Resumer
+func (c *CaseAliasFs) AbortUpload(name string, checkpoint upload.Checkpoint) error {
+	return upload.AbortTarget(c.source, c.RemotePath(name), checkpoint)
+}
+
+// ReadVersion implements upload.Versioner, versions are known if the source supports them
+func (c *CaseAliasFs) ReadVersion(name string) ([]byte, string, error) {
+	return upload.ReadVersion(c.source, c.RemotePath(name))
+}
+
+// WriteVersion implements upload.Versioner, errors.ErrUnsupported is returned if the source does not support versions
+func (c *CaseAliasFs) WriteVersion(name string, data []byte, version string) error {
+	return upload.WriteVersion(c.source, c.RemotePath(name), data, version)
+}
+
+// Checksum implements digest.Checksummer, checksums are calculated by the source if it supports them
+func (c *CaseAliasFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	return digest.Native(c.source, c.RemotePath(name), algorithm)
+}
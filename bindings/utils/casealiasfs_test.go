@@ -0,0 +1,203 @@
+package utils_test
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/spf13/afero"
+)
+
+func walkNames(t *testing.T, fsys afero.Fs) []string {
+	t.Helper()
+	var walked []string
+	err := utils.Walk(fsys, "", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walked
+}
+
+func TestCaseCollisionsAreAliased(t *testing.T) {
+	source := afero.NewMemMapFs()
+	afero.WriteFile(source, "dir/Report.pdf", []byte("upper"), 0666)
+	afero.WriteFile(source, "dir/report.pdf", []byte("lower"), 0666)
+	afero.WriteFile(source, "dir/other.txt", []byte("o"), 0666)
+	local, err := utils.OpenCaseAliasFs(source, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"", "dir", "dir/Report.pdf", "dir/other.txt", "dir/report (case 2).pdf"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+	if data, _ := afero.ReadFile(local, "dir/report (case 2).pdf"); string(data) != "lower" {
+		t.Errorf("alias reads %q", data)
+	}
+	info, err := local.Stat("dir/report (case 2).pdf")
+	if err != nil || info.Name() != "report (case 2).pdf" {
+		t.Errorf("alias is not found: %v", err)
+	}
+	if remotepath, ok := local.(core.CaseAliaser).CaseAlias("dir/report (case 2).pdf"); !ok || remotepath != "dir/report.pdf" {
+		t.Errorf("alias stands for %q", remotepath)
+	}
+	if _, ok := local.(core.CaseAliaser).CaseAlias("dir/Report.pdf"); ok {
+		t.Error("name kept is reported as an alias")
+	}
+
+	// uploads of the alias reach the remote file
+	err = afero.WriteFile(local, "dir/report (case 2).pdf", []byte("edited"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(source, "dir/report.pdf"); string(data) != "edited" {
+		t.Errorf("remote file is %q", data)
+	}
+	if exists, _ := afero.Exists(source, "dir/report (case 2).pdf"); exists {
+		t.Error("alias is created remotely")
+	}
+	// hidden files of the remote file follow its name
+	err = afero.WriteFile(local, "dir/.hash_report (case 2).pdf", []byte("hash"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(source, "dir/.hash_report.pdf"); !exists {
+		t.Error("hash of the alias is not kept next to the remote file")
+	}
+	expected = []string{"", "dir", "dir/.hash_report (case 2).pdf", "dir/Report.pdf", "dir/other.txt", "dir/report (case 2).pdf"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+}
+
+func TestCaseAliasesAreRemembered(t *testing.T) {
+	source := afero.NewMemMapFs()
+	store := afero.NewMemMapFs()
+	afero.WriteFile(source, "Report.pdf", []byte("upper"), 0666)
+	afero.WriteFile(source, "report.pdf", []byte("lower"), 0666)
+	local, err := utils.OpenCaseAliasFs(source, store, "aliases.json", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkNames(t, local)
+
+	// aliases are known before the directory is listed again
+	local, err = utils.OpenCaseAliasFs(source, store, "aliases.json", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = afero.WriteFile(local, "report (case 2).pdf", []byte("edited"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(source, "report.pdf"); string(data) != "edited" {
+		t.Errorf("remote file is %q", data)
+	}
+
+	// the alias is kept after the file it collided with has been deleted
+	source.Remove("Report.pdf")
+	expected := []string{"", "report (case 2).pdf"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+
+	// and forgotten when the file is deleted
+	err = local.Remove("report (case 2).pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	afero.WriteFile(source, "report.pdf", []byte("new"), 0666)
+	expected = []string{"", "report.pdf"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+}
+
+func TestReadOnlyCaseAliasesAreNotSaved(t *testing.T) {
+	source := afero.NewMemMapFs()
+	store := afero.NewMemMapFs()
+	afero.WriteFile(source, "Report.pdf", []byte("upper"), 0666)
+	afero.WriteFile(source, "report.pdf", []byte("lower"), 0666)
+	local, err := utils.OpenCaseAliasFs(source, store, "aliases.json", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkNames(t, local)
+	saved, _ := afero.ReadFile(store, "aliases.json")
+
+	// the aliases of the binding are used
+	afero.WriteFile(source, "dir/Notes.txt", []byte("upper"), 0666)
+	afero.WriteFile(source, "dir/notes.txt", []byte("lower"), 0666)
+	local, err = utils.OpenCaseAliasFs(source, store, "aliases.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(local, "report (case 2).pdf"); string(data) != "lower" {
+		t.Errorf("aliased file is %q", data)
+	}
+	expected := []string{"", "Report.pdf", "dir", "dir/Notes.txt", "dir/notes (case 2).txt", "report (case 2).pdf"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+	if data, _ := afero.ReadFile(store, "aliases.json"); string(data) != string(saved) {
+		t.Errorf("aliases are saved: %s", data)
+	}
+}
+
+func TestCaseCollisionsOfChangesAreAliased(t *testing.T) {
+	source := listingFs{Fs: afero.NewMemMapFs(), ChangeLog: utils.NewChangeLog(10)}
+	afero.WriteFile(source, "dir/Report.pdf", []byte("upper"), 0666)
+	local, err := utils.OpenCaseAliasFs(source, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister, ok := local.(core.ChangeLister)
+	if !ok {
+		t.Fatal("changes of the source are not listed")
+	}
+	walkNames(t, local)
+	_, cursor, _ := lister.Changes("")
+
+	afero.WriteFile(source, "dir/report.pdf", []byte("lower"), 0666)
+	source.Record("dir/report.pdf")
+	changes, _, err := lister.Changes(cursor)
+	if err != nil || !reflect.DeepEqual(changes, []string{"dir/report (case 2).pdf"}) {
+		t.Errorf("changes are listed as %q (%v)", changes, err)
+	}
+}
+
+func TestDecomposedNamesAreNormalized(t *testing.T) {
+	source := afero.NewMemMapFs()
+	afero.WriteFile(source, "cafe\u0301.txt", []byte("decomposed"), 0666)
+	local, err := utils.OpenCaseAliasFs(source, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"", "caf\u00e9.txt"}
+	if walked := walkNames(t, local); !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+	if _, ok := local.(core.CaseAliaser).CaseAlias("caf\u00e9.txt"); ok {
+		t.Error("normalized name is reported as an alias")
+	}
+	err = afero.WriteFile(local, "caf\u00e9.txt", []byte("edited"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(source, "cafe\u0301.txt"); string(data) != "edited" {
+		t.Errorf("remote file is %q", data)
+	}
+	if exists, _ := afero.Exists(source, "caf\u00e9.txt"); exists {
+		t.Error("normalized name is created remotely")
+	}
+}
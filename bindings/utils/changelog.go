@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCursorExpired is returned for cursors of changes that are not kept any more
+var ErrCursorExpired = errors.New("change cursor expired")
+
+// DefaultChangeLogSize is the number of changes kept by a ChangeLog if not specified otherwise
+const DefaultChangeLogSize = 100000
+
+// ChangeLog keeps the most recently changed paths, so clients can list the changes since a cursor (see core.ChangeLister).
+// A cursor consists of the epoch of the log and a sequence number, cursors of a previous epoch are rejected.
+type ChangeLog struct {
+	lock    sync.Mutex
+	epoch   string
+	first   uint64
+	entries []string
+	size    int
+}
+
+func NewChangeLog(size int) *ChangeLog {
+	l := &ChangeLog{size: size}
+	l.Reset()
+	return l
+}
+
+// Reset starts a new epoch, clients have to compare the whole tree again
+func (l *ChangeLog) Reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	l.first = 0
+	l.entries = nil
+}
+
+// Record adds a changed path
+func (l *ChangeLog) Record(path string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, path)
+	if len(l.entries) > l.size {
+		dropped := len(l.entries) - l.size
+		l.entries = slices.Delete(l.entries, 0, dropped)
+		l.first += uint64(dropped)
+	}
+}
+
+func (l *ChangeLog) cursor() string {
+	return fmt.Sprintf("%s:%d", l.epoch, l.first+uint64(len(l.entries)))
+}
+
+// Changes implements core.ChangeLister, every changed path is returned once
+func (l *ChangeLog) Changes(cursor string) ([]string, string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if cursor == "" {
+		return nil, l.cursor(), nil
+	}
+	epoch, seqstr, found := strings.Cut(cursor, ":")
+	seq, err := strconv.ParseUint(seqstr, 10, 64)
+	if !found || err != nil || epoch != l.epoch || seq < l.first || seq > l.first+uint64(len(l.entries)) {
+		return nil, "", ErrCursorExpired
+	}
+	var changes []string
+	seen := make(map[string]bool)
+	for _, path := range l.entries[seq-l.first:] {
+		if !seen[path] {
+			seen[path] = true
+			changes = append(changes, path)
+		}
+	}
+	return changes, l.cursor(), nil
+}
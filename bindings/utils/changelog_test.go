@@ -0,0 +1,90 @@
+package utils_test
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/spf13/afero"
+)
+
+func TestChangeLog(t *testing.T) {
+	log := utils.NewChangeLog(3)
+	_, cursor, err := log.Changes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Record("a.txt")
+	log.Record("b.txt")
+	log.Record("a.txt")
+
+	changes, next, err := log.Changes(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, []string{"a.txt", "b.txt"}) {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	changes, _, err = log.Changes(next)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("unexpected changes %v %v", changes, err)
+	}
+
+	log.Record("c.txt")
+	if _, _, err = log.Changes(cursor); err != utils.ErrCursorExpired {
+		t.Errorf("dropped changes are not reported: %v", err)
+	}
+	changes, _, err = log.Changes(next)
+	if err != nil || !reflect.DeepEqual(changes, []string{"c.txt"}) {
+		t.Errorf("unexpected changes %v %v", changes, err)
+	}
+
+	log.Reset()
+	if _, _, err = log.Changes(next); err != utils.ErrCursorExpired {
+		t.Errorf("cursor of previous epoch is accepted: %v", err)
+	}
+}
+
+// waitForChanges polls the watched directory until all expected paths are reported
+func waitForChanges(t *testing.T, fs *utils.WatchedFs, cursor string, expected ...string) string {
+	t.Helper()
+	var reported []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		changes, next, err := fs.Changes(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported = append(reported, changes...)
+		cursor = next
+		missing := slices.DeleteFunc(slices.Clone(expected), func(p string) bool {
+			return slices.Contains(reported, p)
+		})
+		if len(missing) == 0 {
+			return cursor
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected changes of %v, got %v", expected, reported)
+	return cursor
+}
+
+func TestWatchDir(t *testing.T) {
+	fs, err := utils.WatchDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	_, cursor, _ := fs.Changes("")
+
+	afero.WriteFile(fs, "a.txt", []byte("a"), 0666)
+	fs.Mkdir("dir", 0777)
+	cursor = waitForChanges(t, fs, cursor, "a.txt", "dir")
+
+	// changes in new directories are watched too
+	afero.WriteFile(fs, "dir/b.txt", []byte("b"), 0666)
+	fs.Remove("a.txt")
+	waitForChanges(t, fs, cursor, "dir/b.txt", "a.txt")
+}
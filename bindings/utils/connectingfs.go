@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/upload"
 	"github.com/spf13/afero"
 )
 
@@ -16,6 +18,9 @@ type ConnectingFs struct {
 }
 
 var _ afero.Fs = (*ConnectingFs)(nil)
+var _ digest.Checksummer = (*ConnectingFs)(nil)
+var _ upload.Resumer = (*ConnectingFs)(nil)
+var _ upload.Versioner = (*ConnectingFs)(nil)
 
 func (cfs *ConnectingFs) Chmod(name string, mode os.FileMode) error {
 	return cfs.withFs(func(fs afero.Fs) error {
@@ -98,6 +103,55 @@ func (cfs *ConnectingFs) Chown(name string, uid, gid int) error {
 		return fs.Chown(name, uid, gid)
 	})
 }
+
+// Checksum implements digest.Checksummer if the connected file system does
+func (cfs *ConnectingFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	var result digest.Digest
+	err := cfs.withFs(func(fs afero.Fs) error {
+		var err error
+		result, err = digest.Native(fs, name, algorithm)
+		return err
+	})
+	return result, err
+}
+
+// ResumeUpload implements upload.Resumer, native uploads of the connected file system are used if it supports them
+func (cfs *ConnectingFs) ResumeUpload(name string, checkpoint upload.Checkpoint) (upload.Target, error) {
+	var target upload.Target
+	err := cfs.withFs(func(fs afero.Fs) error {
+		var err error
+		target, err = upload.OpenTarget(fs, name, checkpoint)
+		return err
+	})
+	return target, err
+}
+
+// AbortUpload implements upload.Resumer
+func (cfs *ConnectingFs) AbortUpload(name string, checkpoint upload.Checkpoint) error {
+	return cfs.withFs(func(fs afero.Fs) error {
+		return upload.AbortTarget(fs, name, checkpoint)
+	})
+}
+
+// ReadVersion implements upload.Versioner, versions are known if the connected file system supports them
+func (cfs *ConnectingFs) ReadVersion(name string) ([]byte, string, error) {
+	var data []byte
+	var version string
+	err := cfs.withFs(func(fs afero.Fs) error {
+		var err error
+		data, version, err = upload.ReadVersion(fs, name)
+		return err
+	})
+	return data, version, err
+}
+
+// WriteVersion implements upload.Versioner, errors.ErrUnsupported is returned if the connected file system does not
+// support versions
+func (cfs *ConnectingFs) WriteVersion(name string, data []byte, version string) error {
+	return cfs.withFs(func(fs afero.Fs) error {
+		return upload.WriteVersion(fs, name, data, version)
+	})
+}
 func (cfs *ConnectingFs) withFs(f func(fs afero.Fs) error) error {
 	cfs.lock.Lock()
 	defer cfs.lock.Unlock()
@@ -0,0 +1,73 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+// versionFs knows a single version of every file
+type versionFs struct {
+	afero.Fs
+}
+
+func (v versionFs) ReadVersion(name string) ([]byte, string, error) {
+	data, err := afero.ReadFile(v.Fs, name)
+	return data, "v1", err
+}
+
+func (v versionFs) WriteVersion(name string, data []byte, version string) error {
+	if version != "v1" {
+		return upload.ErrVersionChanged
+	}
+	return afero.WriteFile(v.Fs, name, data, 0666)
+}
+
+func connected(fs afero.Fs) *utils.ConnectingFs {
+	return &utils.ConnectingFs{Connect: func(func(error)) (afero.Fs, error) {
+		return fs, nil
+	}}
+}
+
+func TestConnectingFsForwardsVersions(t *testing.T) {
+	source := versionFs{afero.NewMemMapFs()}
+	afero.WriteFile(source, "m.json", []byte("a"), 0666)
+	remote := connected(source)
+
+	data, version, err := upload.ReadVersion(remote, "m.json")
+	if err != nil || string(data) != "a" || version != "v1" {
+		t.Errorf("unexpected version %q of %q: %v", version, data, err)
+	}
+	err = upload.WriteVersion(remote, "m.json", []byte("b"), "v0")
+	if !errors.Is(err, upload.ErrVersionChanged) {
+		t.Errorf("outdated version is written: %v", err)
+	}
+	err = upload.WriteVersion(remote, "m.json", []byte("b"), "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = upload.WriteVersion(connected(afero.NewMemMapFs()), "m.json", []byte("b"), "")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("versions are not reported unsupported: %v", err)
+	}
+}
+
+func TestConnectingFsUploads(t *testing.T) {
+	source := afero.NewMemMapFs()
+	target, err := upload.OpenTarget(connected(source), "a.txt", upload.Checkpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Write([]byte("a"))
+	err = target.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(source, "a.txt"); string(data) != "a" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// Move renames a file or directory. Parent of the new path is created if needed.
+// If the file system fails to rename, directories are moved entry by entry (which is a server-side copy on
+// object stores) and files are copied through the client before the original is removed.
+func Move(fs afero.Fs, oldname string, newname string) error {
+	info, err := fs.Stat(oldname)
+	if err != nil {
+		return err
+	}
+	err = fs.MkdirAll(path.Dir(newname), 0777)
+	if err != nil {
+		return err
+	}
+	if fs.Rename(oldname, newname) == nil {
+		return nil
+	}
+	if info.IsDir() {
+		return moveDir(fs, oldname, newname)
+	}
+	err = copyFile(fs, oldname, newname, info)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(oldname)
+}
+
+func moveDir(fs afero.Fs, oldname string, newname string) error {
+	err := fs.MkdirAll(newname, 0777)
+	if err != nil {
+		return err
+	}
+	names, err := readDirNames(fs, oldname)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = Move(fs, path.Join(oldname, name), path.Join(newname, name))
+		if err != nil {
+			return err
+		}
+	}
+	return fs.RemoveAll(oldname)
+}
+
+func copyFile(fs afero.Fs, oldname string, newname string, info os.FileInfo) error {
+	source, err := fs.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := fs.Create(newname)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	if err != nil {
+		target.Close()
+		return err
+	}
+	err = target.Close()
+	if err != nil {
+		return err
+	}
+	// modification time is kept where the file system supports it
+	fs.Chtimes(newname, info.ModTime(), info.ModTime())
+	return nil
+}
@@ -0,0 +1,57 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/spf13/afero"
+)
+
+// noRenameFs is a file system that can not rename
+type noRenameFs struct {
+	afero.Fs
+}
+
+func (noRenameFs) Rename(oldname string, newname string) error {
+	return errors.New("rename is not supported")
+}
+
+func testMove(t *testing.T, fs afero.Fs) {
+	fs.MkdirAll("dir/sub", 0777)
+	afero.WriteFile(fs, "dir/a.txt", []byte("a"), 0666)
+	afero.WriteFile(fs, "dir/sub/b.txt", []byte("b"), 0666)
+	afero.WriteFile(fs, "c.txt", []byte("c"), 0666)
+
+	err := utils.Move(fs, "dir", "moved/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.Move(fs, "c.txt", "moved/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range map[string]string{"moved/dir/a.txt": "a", "moved/dir/sub/b.txt": "b", "moved/c.txt": "c"} {
+		data, err := afero.ReadFile(fs, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expected '%s' in %s, got '%s'", content, path, string(data))
+		}
+	}
+	for _, path := range []string{"dir", "c.txt"} {
+		if exists, _ := afero.Exists(fs, path); exists {
+			t.Errorf("%s still exists", path)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	testMove(t, afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()))
+}
+
+func TestMoveWithoutRename(t *testing.T) {
+	testMove(t, noRenameFs{afero.NewMemMapFs()})
+}
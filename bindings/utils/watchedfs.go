@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+)
+
+// WatchedFs is a local directory that records the changes of its contents in a ChangeLog,
+// including the ones made by other processes.
+type WatchedFs struct {
+	afero.Fs
+	*ChangeLog
+	root    string
+	watcher *fsnotify.Watcher
+}
+
+// WatchDir starts watching a local directory, it has to be closed to stop watching
+func WatchDir(dir string) (*WatchedFs, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &WatchedFs{
+		Fs:        afero.NewBasePathFs(afero.NewOsFs(), dir),
+		ChangeLog: NewChangeLog(DefaultChangeLogSize),
+		root:      dir,
+		watcher:   watcher,
+	}
+	err = w.watch(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// watch adds the directory and its subdirectories to the watcher, fsnotify is not recursive
+func (w *WatchedFs) watch(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+func (w *WatchedFs) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(w.root, event.Name)
+			if err != nil {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// new directories are listed recursively by clients, but their changes have to be watched too
+				if info, err := w.Fs.Stat(rel); err == nil && info.IsDir() {
+					w.watch(event.Name)
+				}
+			}
+			w.Record(filepath.ToSlash(rel))
+		case _, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			// some changes might have been missed
+			w.Reset()
+		}
+	}
+}
+
+func (w *WatchedFs) Close() error {
+	return w.watcher.Close()
+}
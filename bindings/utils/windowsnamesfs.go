@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/names"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+var (
+	_ afero.Lstater      = (*WindowsNamesFs)(nil)
+	_ upload.Resumer     = (*WindowsNamesFs)(nil)
+	_ digest.Checksummer = (*WindowsNamesFs)(nil)
+	_ fs.ReadDirFile     = (*windowsNamesFile)(nil)
+)
+
+// changeLister is core.ChangeLister
+type changeLister interface {
+	Changes(cursor string) ([]string, string, error)
+}
+
+// WindowsNamesFs presents the names of the source encoded by names.EncodeWindows, so remote files are projected
+// locally even if their names can not be represented by Windows. Paths given to it are decoded; paths that are
+// not the encoding of any remote path are rejected with names.ErrNotCanonical.
+type WindowsNamesFs struct {
+	source afero.Fs
+}
+
+// changeListingWindowsNamesFs lists the changes of a source implementing core.ChangeLister
+type changeListingWindowsNamesFs struct {
+	*WindowsNamesFs
+}
+
+func NewWindowsNamesFs(source afero.Fs) afero.Fs {
+	w := &WindowsNamesFs{source: source}
+	if _, ok := source.(changeLister); ok {
+		return changeListingWindowsNamesFs{w}
+	}
+	return w
+}
+
+// Changes implements core.ChangeLister
+func (c changeListingWindowsNamesFs) Changes(cursor string) ([]string, string, error) {
+	changes, next, err := c.source.(changeLister).Changes(cursor)
+	for i, change := range changes {
+		changes[i] = names.EncodeWindowsPath(change)
+	}
+	return changes, next, err
+}
+
+// RemotePath returns the path of the source a local path is the encoding of
+func (w *WindowsNamesFs) RemotePath(name string) (string, error) {
+	remotepath := names.DecodeWindowsPath(name)
+	if names.EncodeWindowsPath(remotepath) != name {
+		return name, names.ErrNotCanonical
+	}
+	return remotepath, nil
+}
+
+type windowsNamesFile struct {
+	afero.File
+}
+
+// windowsNamesFileInfo reports the encoded name of the source file
+type windowsNamesFileInfo struct {
+	fs.FileInfo
+}
+
+func (i windowsNamesFileInfo) Name() string {
+	return names.EncodeWindows(i.FileInfo.Name())
+}
+
+func wrapInfo(info fs.FileInfo, err error) (fs.FileInfo, error) {
+	if info == nil {
+		return nil, err
+	}
+	return windowsNamesFileInfo{info}, err
+}
+
+func (f *windowsNamesFile) Name() string {
+	return names.EncodeWindowsPath(f.File.Name())
+}
+
+func (f *windowsNamesFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	for i, info := range infos {
+		infos[i] = windowsNamesFileInfo{info}
+	}
+	return infos, err
+}
+
+func (f *windowsNamesFile) Readdirnames(n int) ([]string, error) {
+	entries, err := f.File.Readdirnames(n)
+	for i, name := range entries {
+		entries[i] = names.EncodeWindows(name)
+	}
+	return entries, err
+}
+
+func (f *windowsNamesFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return readDirFile{f}.ReadDir(n)
+}
+
+func (f *windowsNamesFile) Stat() (os.FileInfo, error) {
+	return wrapInfo(f.File.Stat())
+}
+
+func (w *WindowsNamesFs) wrapFile(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &windowsNamesFile{File: file}, nil
+}
+
+func (w *WindowsNamesFs) Name() string {
+	return "WindowsNamesFs"
+}
+
+func (w *WindowsNamesFs) Create(name string) (afero.File, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	return w.wrapFile(w.source.Create(remotepath))
+}
+
+func (w *WindowsNamesFs) Mkdir(name string, perm os.FileMode) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return w.source.Mkdir(remotepath, perm)
+}
+
+func (w *WindowsNamesFs) MkdirAll(name string, perm os.FileMode) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return w.source.MkdirAll(remotepath, perm)
+}
+
+func (w *WindowsNamesFs) Open(name string) (afero.File, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return w.wrapFile(w.source.Open(remotepath))
+}
+
+func (w *WindowsNamesFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "openfile", Path: name, Err: err}
+	}
+	return w.wrapFile(w.source.OpenFile(remotepath, flag, perm))
+}
+
+func (w *WindowsNamesFs) Remove(name string) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return w.source.Remove(remotepath)
+}
+
+func (w *WindowsNamesFs) RemoveAll(name string) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "remove_all", Path: name, Err: err}
+	}
+	return w.source.RemoveAll(remotepath)
+}
+
+func (w *WindowsNamesFs) Rename(oldname string, newname string) error {
+	oldpath, err := w.RemotePath(oldname)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newpath, err := w.RemotePath(newname)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	return w.source.Rename(oldpath, newpath)
+}
+
+func (w *WindowsNamesFs) Stat(name string) (os.FileInfo, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return wrapInfo(w.source.Stat(remotepath))
+}
+
+func (w *WindowsNamesFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if lstater, ok := w.source.(afero.Lstater); ok {
+		info, lstat, err := lstater.LstatIfPossible(remotepath)
+		info, err = wrapInfo(info, err)
+		return info, lstat, err
+	}
+	info, err := wrapInfo(w.source.Stat(remotepath))
+	return info, false, err
+}
+
+func (w *WindowsNamesFs) Chmod(name string, mode os.FileMode) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return w.source.Chmod(remotepath, mode)
+}
+
+func (w *WindowsNamesFs) Chown(name string, uid int, gid int) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return w.source.Chown(remotepath, uid, gid)
+}
+
+func (w *WindowsNamesFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return w.source.Chtimes(remotepath, atime, mtime)
+}
+
+// ResumeUpload implements upload.Resumer, native uploads of the source are used if it supports them
+func (w *WindowsNamesFs) ResumeUpload(name string, checkpoint upload.Checkpoint) (upload.Target, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "upload", Path: name, Err: err}
+	}
+	return upload.OpenTarget(w.source, remotepath, checkpoint)
+}
+
+// AbortUpload implements upload.Resumer
+func (w *WindowsNamesFs) AbortUpload(name string, checkpoint upload.Checkpoint) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "upload", Path: name, Err: err}
+	}
+	return upload.AbortTarget(w.source, remotepath, checkpoint)
+}
+
+// ReadVersion implements upload.Versioner, versions are known if the source supports them
+func (w *WindowsNamesFs) ReadVersion(name string) ([]byte, string, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return nil, "", &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return upload.ReadVersion(w.source, remotepath)
+}
+
+// WriteVersion implements upload.Versioner, errors.ErrUnsupported is returned if the source does not support versions
+func (w *WindowsNamesFs) WriteVersion(name string, data []byte, version string) error {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	return upload.WriteVersion(w.source, remotepath, data, version)
+}
+
+// Checksum implements digest.Checksummer, checksums are calculated by the source if it supports them
+func (w *WindowsNamesFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	remotepath, err := w.RemotePath(name)
+	if err != nil {
+		return digest.Digest{}, &os.PathError{Op: "checksum", Path: name, Err: err}
+	}
+	return digest.Native(w.source, remotepath, algorithm)
+}
@@ -0,0 +1,107 @@
+package utils_test
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/names"
+	"github.com/spf13/afero"
+)
+
+func TestWindowsNamesAreEncoded(t *testing.T) {
+	source := afero.NewMemMapFs()
+	source.MkdirAll("what?", 0777)
+	afero.WriteFile(source, "what?/a:b.txt", []byte("a"), 0666)
+	afero.WriteFile(source, "CON", []byte("c"), 0666)
+	afero.WriteFile(source, "plain.txt", []byte("p"), 0666)
+	local := utils.NewWindowsNamesFs(source)
+
+	var walked []string
+	err := utils.Walk(local, "", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != "" && info.Name() != path[strings.LastIndex(path, "/")+1:] {
+			t.Errorf("%s is reported as %s", path, info.Name())
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"", "plain.txt", "what\uF03F", "what\uF03F/a\uF03Ab.txt", "\uF043ON"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected %q, got %q", expected, walked)
+	}
+
+	if data, err := afero.ReadFile(local, "what\uF03F/a\uF03Ab.txt"); err != nil || string(data) != "a" {
+		t.Errorf("encoded file is not read: %v", err)
+	}
+	info, err := local.Stat("\uF043ON")
+	if err != nil || info.Name() != "\uF043ON" {
+		t.Errorf("encoded file is not found: %v", err)
+	}
+
+	err = afero.WriteFile(local, "what\uF03F/new\uF02E", []byte("n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(source, "what?/new."); string(data) != "n" {
+		t.Error("written file does not have the decoded name")
+	}
+	err = local.Rename("what\uF03F/new\uF02E", "new\uF03Aname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(source, "new:name"); !exists {
+		t.Error("renamed file does not have the decoded name")
+	}
+}
+
+func TestNamesNotEncodedAreRejected(t *testing.T) {
+	source := afero.NewMemMapFs()
+	afero.WriteFile(source, "A.txt", []byte("a"), 0666)
+	local := utils.NewWindowsNamesFs(source)
+
+	// a lookalike of a legal character would be mapped to another local name
+	err := afero.WriteFile(local, "\uF041.txt", []byte("x"), 0666)
+	if !errors.Is(err, names.ErrNotCanonical) {
+		t.Errorf("expected rejection, got %v", err)
+	}
+	if data, _ := afero.ReadFile(source, "A.txt"); string(data) != "a" {
+		t.Error("remote file is overwritten")
+	}
+}
+
+// listingFs is a file system listing its changes
+type listingFs struct {
+	afero.Fs
+	*utils.ChangeLog
+}
+
+func TestChangesAreEncoded(t *testing.T) {
+	source := listingFs{Fs: afero.NewMemMapFs(), ChangeLog: utils.NewChangeLog(10)}
+	local := utils.NewWindowsNamesFs(source)
+	lister, ok := local.(interface {
+		Changes(cursor string) ([]string, string, error)
+	})
+	if !ok {
+		t.Fatal("changes of the source are not listed")
+	}
+	_, cursor, _ := lister.Changes("")
+	source.Record("dir/a:b.txt")
+	changes, _, err := lister.Changes(cursor)
+	if err != nil || !reflect.DeepEqual(changes, []string{"dir/a\uF03Ab.txt"}) {
+		t.Errorf("changes are listed as %q (%v)", changes, err)
+	}
+	if _, ok := utils.NewWindowsNamesFs(afero.NewMemMapFs()).(interface {
+		Changes(cursor string) ([]string, string, error)
+	}); ok {
+		t.Error("changes are listed for a source that can not list them")
+	}
+}
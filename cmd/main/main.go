@@ -36,6 +36,9 @@ func main() {
 	icon.AddAction("Show statuses", func() {
 		uicontext.MainWindow.Show()
 	})
+	icon.AddAction("&Sync now", func() {
+		mgr.SyncNow()
+	})
 
 	keys, _ := mgr.InstanceList()
 	for _, keyname := range keys {
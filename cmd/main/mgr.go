@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"io"
 
 	"github.com/balazsgrill/potatodrive/bindings"
 	"github.com/balazsgrill/potatodrive/ui"
@@ -14,11 +13,11 @@ type Manager struct {
 	ui *ui.UIContext
 
 	configProvider bindings.ConfigProvider
-	instances      map[string]io.Closer
+	instances      map[string]bindings.Instance
 }
 
-func startInstance(config bindings.Config, context bindings.InstanceContext) (io.Closer, error) {
-	fs, err := config.ToFileSystem(context.Logger)
+func startInstance(config bindings.Config, context bindings.InstanceContext) (bindings.Instance, error) {
+	fs, err := config.RemoteFileSystem(context.Logger, false)
 	if err != nil {
 		context.Logger.Error().Msgf("Create file system: %v", err)
 		return nil, err
@@ -37,7 +36,7 @@ func startInstance(config bindings.Config, context bindings.InstanceContext) (io
 
 func New(ui *ui.UIContext) (*Manager, error) {
 	m := &Manager{
-		instances: make(map[string]io.Closer),
+		instances: make(map[string]bindings.Instance),
 	}
 	m.ui = ui
 	m.Logger = ui.Logger
@@ -76,6 +75,13 @@ func (m *Manager) StartInstance(id string, context bindings.InstanceContext) err
 	return nil
 }
 
+// SyncNow requests a synchronization of all running instances
+func (m *Manager) SyncNow() {
+	for _, instance := range m.instances {
+		instance.SyncNow()
+	}
+}
+
 func (m *Manager) StopInstance(id string) error {
 	instance := m.instances[id]
 	err := instance.Close()
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/balazsgrill/potatodrive/bindings"
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+)
+
+// newLogger writes logs to stderr, so the output of commands can be piped
+func newLogger() zerolog.Logger {
+	return zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+}
+
+// openRemote reads the configuration of a binding and connects to its remote. The aliases of the binding are not
+// saved by the commands, PotatoDrive may be running it.
+func openRemote(id string, logger zerolog.Logger) (bindings.Config, afero.Fs, error) {
+	config, err := bindings.NewRegistryConfigProvider(logger, "SOFTWARE\\PotatoDrive").ReadConfig(id)
+	if err != nil {
+		return config, nil, err
+	}
+	fs, err := config.RemoteFileSystem(logger, true)
+	return config, fs, err
+}
+
+// exitOnError prints the error and exits if there is one
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
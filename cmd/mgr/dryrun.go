@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/balazsgrill/potatodrive/bindings"
+)
+
+// dryrun prints the actions the next synchronization of the binding would execute as JSON
+func dryrun(id string) {
+	exitOnError(printDryRun(id))
+}
+
+func printDryRun(id string) error {
+	logger := newLogger()
+	config, fs, err := openRemote(id, logger)
+	if err != nil {
+		return err
+	}
+	plan, err := bindings.DryRun(config.ID, &config.BaseConfig, fs, logger)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan.Report())
+}
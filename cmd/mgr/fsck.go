@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/balazsgrill/potatodrive/bindings"
+	"github.com/balazsgrill/potatodrive/core/fsck"
+)
+
+// fsckBinding prints the problems found on the remote of the binding, repairing them if requested
+func fsckBinding(id string, options fsck.Options) {
+	config, fs, err := openRemote(id, newLogger())
+	exitOnError(err)
+	problems, err := bindings.Fsck(config.ID, &config.BaseConfig, fs, options)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tPATH\tDETAIL\tREPAIRED")
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", problem.Kind, problem.Path, problem.Detail, problem.Repaired)
+	}
+	exitOnError(w.Flush())
+	exitOnError(err)
+	fmt.Printf("%d problems found\n", len(problems))
+}
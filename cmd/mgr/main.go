@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/balazsgrill/potatodrive/core/fsck"
 	"github.com/go-ole/go-ole"
 	"github.com/integrii/flaggy"
 )
@@ -14,8 +15,45 @@ func main() {
 	unregcmd := flaggy.NewSubcommand("unreg")
 	var unregid string
 	unregcmd.AddPositionalValue(&unregid, "ID", 1, true, "The id of the sync root to unregister")
+	dryruncmd := flaggy.NewSubcommand("dryrun")
+	dryruncmd.Description = "Print the actions the next synchronization would execute as JSON"
+	var dryrunid string
+	dryruncmd.AddPositionalValue(&dryrunid, "ID", 1, true, "The id of the binding")
+	trashcmd := flaggy.NewSubcommand("trash")
+	trashcmd.Description = "List or restore files deleted from the remote"
+	trashlistcmd := flaggy.NewSubcommand("list")
+	var trashid, trashitem string
+	trashlistcmd.AddPositionalValue(&trashid, "ID", 1, true, "The id of the binding")
+	trashrestorecmd := flaggy.NewSubcommand("restore")
+	trashrestorecmd.AddPositionalValue(&trashid, "ID", 1, true, "The id of the binding")
+	trashrestorecmd.AddPositionalValue(&trashitem, "ITEM", 2, true, "The item to restore as listed")
+	trashcmd.AttachSubcommand(trashlistcmd, 1)
+	trashcmd.AttachSubcommand(trashrestorecmd, 1)
+	versionscmd := flaggy.NewSubcommand("versions")
+	versionscmd.Description = "List or restore previous versions of a remote file"
+	versionslistcmd := flaggy.NewSubcommand("list")
+	var versionsid, versionspath, version string
+	versionslistcmd.AddPositionalValue(&versionsid, "ID", 1, true, "The id of the binding")
+	versionslistcmd.AddPositionalValue(&versionspath, "PATH", 2, true, "The path of the file on the remote")
+	versionsrestorecmd := flaggy.NewSubcommand("restore")
+	versionsrestorecmd.AddPositionalValue(&versionsid, "ID", 1, true, "The id of the binding")
+	versionsrestorecmd.AddPositionalValue(&versionspath, "PATH", 2, true, "The path of the file on the remote")
+	versionsrestorecmd.AddPositionalValue(&version, "VERSION", 3, true, "The version to restore as listed")
+	versionscmd.AttachSubcommand(versionslistcmd, 1)
+	versionscmd.AttachSubcommand(versionsrestorecmd, 1)
+	fsckcmd := flaggy.NewSubcommand("fsck")
+	fsckcmd.Description = "Find orphaned sidecars and records, stale temporary files and hashes not matching the content on the remote"
+	var fsckid string
+	var fsckoptions fsck.Options
+	fsckcmd.AddPositionalValue(&fsckid, "ID", 1, true, "The id of the binding")
+	fsckcmd.Bool(&fsckoptions.Repair, "", "repair", "Remove orphaned and stale files and records, and record the hash of the current content")
+	fsckcmd.Bool(&fsckoptions.Download, "", "download", "Read files the remote does not calculate checksums of to verify their hashes")
 	flaggy.AttachSubcommand(listcmd, 1)
 	flaggy.AttachSubcommand(unregcmd, 1)
+	flaggy.AttachSubcommand(dryruncmd, 1)
+	flaggy.AttachSubcommand(trashcmd, 1)
+	flaggy.AttachSubcommand(versionscmd, 1)
+	flaggy.AttachSubcommand(fsckcmd, 1)
 	flaggy.Parse()
 
 	if listcmd.Used {
@@ -24,4 +62,22 @@ func main() {
 	if unregcmd.Used {
 		unreg(unregid)
 	}
+	if dryruncmd.Used {
+		dryrun(dryrunid)
+	}
+	if trashlistcmd.Used {
+		trashList(trashid)
+	}
+	if trashrestorecmd.Used {
+		trashRestore(trashid, trashitem)
+	}
+	if versionslistcmd.Used {
+		versionsList(versionsid, versionspath)
+	}
+	if versionsrestorecmd.Used {
+		versionsRestore(versionsid, versionspath, version)
+	}
+	if fsckcmd.Used {
+		fsckBinding(fsckid, fsckoptions)
+	}
 }
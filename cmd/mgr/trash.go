@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/trash"
+)
+
+// trashList prints the items in the remote trash of the binding
+func trashList(id string) {
+	_, fs, err := openRemote(id, newLogger())
+	exitOnError(err)
+	items, err := trash.New(fs, trash.Policy{}).List()
+	exitOnError(err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DELETED\tSIZE\tORIGINAL\tITEM")
+	for _, item := range items {
+		original := item.Original
+		if item.Dir {
+			original += "/"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", item.Deleted.Format("2006-01-02"), item.Size, original, item.Path)
+	}
+	exitOnError(w.Flush())
+}
+
+// trashRestore moves an item of the remote trash back to its original path
+func trashRestore(id string, item string) {
+	config, fs, err := openRemote(id, newLogger())
+	exitOnError(err)
+	options, err := config.BaseConfig.Options(id)
+	exitOnError(err)
+	// a local state is locked while the binding is running, it is opened first so nothing is restored then
+	state, err := core.OpenRemoteStateCache(fs, options)
+	exitOnError(err)
+	defer core.CloseRemoteStateCache(state)
+	original, err := trash.New(fs, trash.Policy{}).Restore(item)
+	exitOnError(err)
+	// the deletion must not be applied again by clients that were offline
+	exitOnError(state.RemoveTombstone(original))
+	fmt.Printf("Restored %s\n", original)
+}
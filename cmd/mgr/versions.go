@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/balazsgrill/potatodrive/core/versions"
+)
+
+// openVersions connects to the remote of the binding and returns its versions with the configured policy
+func openVersions(id string) *versions.Versions {
+	config, fs, err := openRemote(id, newLogger())
+	exitOnError(err)
+	policy, err := config.BaseConfig.VersionPolicy()
+	exitOnError(err)
+	return versions.New(fs, policy)
+}
+
+// versionsList prints the previous versions of a remote file, most recent first
+func versionsList(id string, path string) {
+	list, err := openVersions(id).List(path)
+	exitOnError(err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tREPLACED\tSIZE")
+	for _, version := range list {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", version.Name, version.Replaced.Local().Format("2006-01-02 15:04:05"), version.Size)
+	}
+	exitOnError(w.Flush())
+}
+
+// versionsRestore replaces a remote file with one of its previous versions
+func versionsRestore(id string, path string, version string) {
+	exitOnError(openVersions(id).Restore(path, version))
+	fmt.Printf("Restored %s to %s\n", path, version)
+}
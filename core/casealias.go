@@ -0,0 +1,9 @@
+package core
+
+// CaseAliaser is implemented by remote file systems that present files under a disambiguated name if it differs from
+// another name in the same directory only by case or Unicode normalization, so both can be projected to a
+// case-insensitive local folder.
+type CaseAliaser interface {
+	// CaseAlias returns the remote path of the file presented at the given path if its name is a disambiguated one
+	CaseAlias(path string) (string, bool)
+}
@@ -0,0 +1,81 @@
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/cfapi"
+	"github.com/balazsgrill/potatodrive/core/quota"
+	"golang.org/x/sys/windows"
+)
+
+// fileAttributePinned is set on files the user asked to always keep on this device
+const fileAttributePinned = 0x00080000
+
+// freeCache dehydrates the least recently used in-sync files that do not fit into the cache budget of the binding
+func (instance *VirtualizationInstance) freeCache(now time.Time) error {
+	policy := instance.options.Cache
+	if !policy.Enabled() {
+		return nil
+	}
+	var files []quota.File
+	err := filepath.Walk(instance.rootPath, func(localpath string, localinfo fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if localinfo.IsDir() {
+			return nil
+		}
+		attributes, ok := localinfo.Sys().(*syscall.Win32FileAttributeData)
+		if !ok || attributes.FileAttributes&(windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS|fileAttributePinned) != 0 {
+			// content is not on disk or it is pinned
+			return nil
+		}
+		state, err := getPlaceholderState(localpath)
+		if err != nil {
+			return err
+		}
+		if state&cfapi.CF_PLACEHOLDER_STATE_PLACEHOLDER == 0 || state&cfapi.CF_PLACEHOLDER_STATE_IN_SYNC == 0 {
+			// local changes are not uploaded yet
+			return nil
+		}
+		lastaccess := time.Unix(0, attributes.LastAccessTime.Nanoseconds())
+		if localinfo.ModTime().After(lastaccess) {
+			lastaccess = localinfo.ModTime()
+		}
+		files = append(files, quota.File{Path: localpath, Size: localinfo.Size(), LastAccess: lastaccess})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range policy.Select(files, now) {
+		instance.Logger.Info().Msgf("Dehydrating unused file '%s'", file.Path)
+		err = instance.evict(file.Path)
+		if err != nil {
+			// the file might be in use, it is selected again on the next run
+			instance.Logger.Warn().Msgf("Dehydrating '%s' failed: %s", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// evict drops the local content of an in-sync placeholder, it is hydrated again on next access
+func (instance *VirtualizationInstance) evict(localpath string) error {
+	var handle syscall.Handle
+	hr := cfapi.CfOpenFileWithOplock(core.GetPointer(localpath), cfapi.CF_OPEN_FILE_FLAG_WRITE_ACCESS|cfapi.CF_OPEN_FILE_FLAG_EXCLUSIVE, &handle)
+	if hr != 0 {
+		return core.ErrorByCodeWithContext("evict:CfOpenFileWithOplock", hr)
+	}
+	defer cfapi.CfCloseHandle(handle)
+	localinfo, err := os.Stat(localpath)
+	if err != nil {
+		return err
+	}
+	placeholder := getPlaceholder(localinfo)
+	return dehydratePlaceholder(handle, &placeholder, localinfo.Size(), cfapi.CF_UPDATE_FLAG_NONE)
+}
@@ -1,7 +1,7 @@
 package filesystem
 
 import (
-	"crypto/md5"
+	"fmt"
 	"hash"
 	"io"
 	"syscall"
@@ -9,6 +9,9 @@ import (
 
 	"github.com/balazsgrill/potatodrive/core"
 	"github.com/balazsgrill/potatodrive/core/cfapi"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/tasks"
+	"github.com/balazsgrill/potatodrive/core/upload"
 )
 
 const BUFFER_SIZE int64 = 100 * 1024
@@ -52,13 +55,35 @@ func (tb *transferBuffer) send(updatehash hash.Hash) error {
 	return core.ErrorByCode(hr)
 }
 
+// fetchData runs the hydration of a placeholder on the hydration pool and waits until it is finished
 func (instance *VirtualizationInstance) fetchData(info *cfapi.CF_CALLBACK_INFO, data *cfapi.CF_CALLBACK_PARAMETERS_FetchData) uintptr {
 	instance.lock.Lock()
-	defer instance.lock.Unlock()
+	ignore := instance.ignore
+	instance.lock.Unlock()
 	localpath := instance.callback_getFilePath(info)
-	instance.FileDownloading(localpath, 0)
-
 	filename := instance.path_localToRemote(localpath)
+	if ignore.Match(filename, false) {
+		instance.Logger.Warn().Msgf("Refusing to fetch excluded file %s", filename)
+		return uintptr(syscall.EIO)
+	}
+
+	// the result stays EIO if the pool is closed before the task is run
+	result := uintptr(syscall.EIO)
+	done := make(chan bool)
+	task := tasks.WrapSizedTask(instance.hydrations, func(tasks.TaskContext) error {
+		result = instance.hydrate(info, data, localpath, filename)
+		return nil
+	}, 0, filename, data.RequiredLength)
+	task.OnDone = func(error) {
+		close(done)
+	}
+	instance.hydrations.AddTask(task)
+	<-done
+	return result
+}
+
+func (instance *VirtualizationInstance) hydrate(info *cfapi.CF_CALLBACK_INFO, data *cfapi.CF_CALLBACK_PARAMETERS_FetchData, localpath string, filename string) uintptr {
+	instance.FileDownloading(localpath, 0)
 	length := data.RequiredLength
 	byteOffset := data.RequiredFileOffset
 	remoteinfo, err := instance.fs.Stat(filename)
@@ -77,9 +102,14 @@ func (instance *VirtualizationInstance) fetchData(info *cfapi.CF_CALLBACK_INFO,
 
 	wholeFileRequested := (byteOffset == 0) && (length == remoteinfo.Size())
 	var updatehash hash.Hash
+	algorithm := instance.options.Hash
 	if wholeFileRequested {
-		// If the whole file is to be downloaded (a full hydration), it is a chance to update our cache of remote state
-		updatehash = md5.New()
+		// If the whole file is to be downloaded (a full hydration), it is a chance to update our cache of remote state.
+		// A hash recorded by another algorithm is kept, so devices configured for it do not see a change.
+		if known, err := instance.remoteCacheState.GetHash(filename); err == nil && !known.IsZero() {
+			algorithm = known.Algorithm
+		}
+		updatehash = algorithm.New()
 	}
 	instance.Logger.Debug().Msgf("Fetch data: %s %d bytes at %d", filename, length, byteOffset)
 	instance.Logger.Debug().Msgf("Optional %d at %d", data.OptionalLength, data.OptionalFileOffset)
@@ -130,7 +160,16 @@ func (instance *VirtualizationInstance) fetchData(info *cfapi.CF_CALLBACK_INFO,
 		return uintptr(syscall.EIO)
 	}
 	if updatehash != nil {
-		err := instance.remoteCacheState.UpdateHash(filename, updatehash.Sum(nil))
+		hash := digest.Of(algorithm, updatehash)
+		// the content read is verified if the remote calculates checksums
+		native, err := digest.Native(instance.fs, filename, algorithm)
+		if err == nil && !native.IsZero() && !native.Equal(hash) {
+			err = fmt.Errorf("%s: %w", filename, upload.ErrChecksumMismatch)
+			instance.Logger.Error().Msgf("Downloaded content is corrupted %s: %s", filename, err)
+			instance.FileError(localpath, err)
+			return uintptr(syscall.EIO)
+		}
+		err = instance.remoteCacheState.UpdateHash(filename, hash)
 		if err != nil {
 			instance.Logger.Warn().Msgf("Error updating state cache %s: %s", filename, err)
 		}
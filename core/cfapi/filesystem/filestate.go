@@ -34,6 +34,18 @@ func (vi *VirtualizationInstance) FileUploading(path string, progress int) {
 	}
 }
 
+func (vi *VirtualizationInstance) FileConflict(path string, conflictcopy string) {
+	if vi.callbacks != nil {
+		vi.callbacks.FileConflict(path, conflictcopy)
+	}
+}
+
+func (vi *VirtualizationInstance) FileNameCollision(path string, remotepath string) {
+	if vi.callbacks != nil {
+		vi.callbacks.FileNameCollision(path, remotepath)
+	}
+}
+
 func (vi *VirtualizationInstance) FileRemoved(path string) {
 	if vi.callbacks != nil {
 		vi.callbacks.FileRemoved(path)
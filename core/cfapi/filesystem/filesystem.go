@@ -1,21 +1,28 @@
 package filesystem
 
 import (
-	"crypto/md5"
 	"errors"
-	"io"
+	"fmt"
 	"io/fs"
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/rs/zerolog"
 
+	"github.com/balazsgrill/potatodrive/bindings/utils"
 	"github.com/balazsgrill/potatodrive/core"
 	"github.com/balazsgrill/potatodrive/core/cfapi"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/ignore"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/balazsgrill/potatodrive/core/tasks"
+	"github.com/balazsgrill/potatodrive/core/trash"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/balazsgrill/potatodrive/core/versions"
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/afero"
 	"golang.org/x/sys/windows"
@@ -28,11 +35,28 @@ type VirtualizationInstance struct {
 	longprefix       string
 	fs               afero.Fs
 	remoteCacheState core.RemoteStateCache
+	journal          journal.Journal
+	options          core.Options
+	tombstones       core.TombstoneCollector
+	// trash keeps remote files deleted by synchronization
+	trash *trash.Trash
+	// versions keeps previous contents of remote files replaced by uploads
+	versions *versions.Versions
+	// ignore are the rules of excluded files loaded by the last synchronization
+	ignore *ignore.Matcher
+	// cursor is the position of the remote change feed after the last successful synchronization
+	cursor string
+	// transfers runs uploads in parallel
+	transfers *tasks.TaskExecutor
+	// hydrations runs downloads of opened files, separately so they do not wait behind queued uploads
+	hydrations *tasks.TaskExecutor
+	uploader  *upload.Uploader
 
 	connectionKey cfapi.CF_CONNECTION_KEY
 	lock          sync.Mutex
 	watcher       *fsnotify.Watcher
 	callbacks     core.FileStateCallbacks
+	changed       func()
 }
 
 // SetStateCallbacks implements core.Virtualization.
@@ -40,18 +64,72 @@ func (instance *VirtualizationInstance) SetStateCallbacks(callbacks core.FileSta
 	instance.callbacks = callbacks
 }
 
-func StartProjecting(rootPath string, filesystem afero.Fs, logger zerolog.Logger) (core.Virtualization, error) {
+// SetChangeCallback implements core.Virtualization.
+func (instance *VirtualizationInstance) SetChangeCallback(callback func()) {
+	instance.changed = callback
+}
+
+func StartProjecting(rootPath string, filesystem afero.Fs, logger zerolog.Logger, options core.Options) (core.Virtualization, error) {
+	j, err := journal.OpenDir(options.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := core.OpenRemoteStateCache(filesystem, options)
+	if err != nil {
+		return nil, err
+	}
 	instance := &VirtualizationInstance{
 		Logger:           logger,
 		rootPath:         rootPath,
 		fs:               filesystem,
-		remoteCacheState: core.HashFilesRemotely(filesystem),
+		remoteCacheState: state,
+		journal:          j,
+		options:          options,
+		versions:         versions.New(filesystem, options.Versions),
 	}
+	instance.uploader = &upload.Uploader{Remote: filesystem, Checkpoints: upload.OpenDir(options.DataDir), Versions: instance.versions, Hash: options.Hash}
+	instance.tombstones = core.TombstoneCollector{State: instance.remoteCacheState, Retention: options.TombstoneRetention}
+	instance.trash = trash.New(filesystem, options.Trash)
+	instance.transfers = tasks.NewTaskPool(instance.transferStateChanged, options.TransferWorkers(), core.LargeTransferSize)
+	go instance.transfers.Run()
+	instance.hydrations = tasks.NewTaskPool(instance.transferStateChanged, options.TransferWorkers(), core.LargeTransferSize)
+	go instance.hydrations.Run()
 
 	instance.longprefix = core.ToLongPath(rootPath)
 	instance.shortprefix = core.ToShortPath(rootPath)
 
-	return instance, instance.start()
+	err = instance.start()
+	if err != nil {
+		instance.transfers.Close()
+		instance.hydrations.Close()
+		core.CloseRemoteStateCache(state)
+		return nil, err
+	}
+	return instance, nil
+}
+
+// DryRun plans the synchronization of the local folder without connecting it or changing either side
+func DryRun(rootPath string, filesystem afero.Fs, logger zerolog.Logger, options core.Options) (planner.Plan, error) {
+	j, err := journal.OpenDir(options.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := core.OpenRemoteStateCache(filesystem, options)
+	if err != nil {
+		return nil, err
+	}
+	defer core.CloseRemoteStateCache(state)
+	instance := &VirtualizationInstance{
+		Logger:           logger,
+		rootPath:         rootPath,
+		fs:               filesystem,
+		remoteCacheState: state,
+		journal:          j,
+		options:          options,
+	}
+	instance.longprefix = core.ToLongPath(rootPath)
+	instance.shortprefix = core.ToShortPath(rootPath)
+	return planner.New(filesystem, &localState{instance: instance}, instance.remoteCacheState, j, options).Plan()
 }
 
 func (instance *VirtualizationInstance) start() error {
@@ -149,33 +227,113 @@ func (instance *VirtualizationInstance) Close() error {
 	}
 
 	instance.watcher.Close()
+	instance.transfers.Close()
+	instance.hydrations.Close()
 	hr := cfapi.CfDisconnectSyncRoot(instance.connectionKey)
 	if hr != 0 {
 		return core.ErrorByCode(hr)
 	}
 
-	return nil
+	return core.CloseRemoteStateCache(instance.remoteCacheState)
 }
 
 func (instance *VirtualizationInstance) PerformSynchronization() error {
-	err := instance.syncRemoteToLocal()
+	p := planner.New(instance.fs, &localState{instance: instance}, instance.remoteCacheState, instance.journal, instance.options)
+	p.SetCursor(instance.cursor)
+	plan, err := p.Plan()
+	if err != nil {
+		return err
+	}
+	instance.lock.Lock()
+	instance.ignore = p.Ignore()
+	instance.lock.Unlock()
+	err = p.Execute(plan, instance)
+	if err != nil {
+		return err
+	}
+	for _, collision := range p.NameCollisions() {
+		instance.Logger.Warn().Msgf("'%s' differs from remote '%s' only by case or normalization, it is not synchronized under its own name", collision.Path, collision.RemotePath)
+		instance.FileNameCollision(instance.path_remoteToLocal(collision.Path), collision.RemotePath)
+	}
+	instance.cursor = p.Cursor()
+	err = instance.tombstones.Collect(time.Now())
+	if err != nil {
+		return err
+	}
+	err = instance.trash.Purge(time.Now())
 	if err != nil {
 		return err
 	}
-	uploads, err := instance.syncLocalToRemote()
-	for _, path := range uploads {
-		localpath := instance.path_remoteToLocal(path)
+	err = instance.versions.Purge(time.Now())
+	if err != nil {
+		return err
+	}
+	return instance.freeCache(time.Now())
+}
+
+var _ planner.TransferExecutor = (*VirtualizationInstance)(nil)
+
+// Transfers implements planner.TransferExecutor.
+func (instance *VirtualizationInstance) Transfers() tasks.TaskConsumer {
+	return instance.transfers
+}
+
+// UploadDropped implements planner.TransferExecutor, the placeholder is marked changed so it is uploaded by the
+// next synchronization
+func (instance *VirtualizationInstance) UploadDropped(action planner.Action) error {
+	return setNotInSync(instance.path_remoteToLocal(action.Path))
+}
+
+// transferStateChanged logs failed transfers, progress is reported through the file state callbacks
+func (instance *VirtualizationInstance) transferStateChanged(state tasks.TaskState) {
+	if state.Error != nil {
+		instance.Logger.Warn().Msgf("Transfer of '%s' failed: %v", state.Name, state.Error)
+	}
+}
+
+// Execute implements planner.Executor.
+func (instance *VirtualizationInstance) Execute(action planner.Action) error {
+	instance.Logger.Debug().Msgf("Executing %s", action)
+	switch action.Type {
+	case planner.CreatePlaceholder:
+		return instance.createPlaceholder(action.Path, action.RemoteInfo)
+	case planner.CreateLocalDir:
+		return instance.createLocalDir(action.Path)
+	case planner.Dehydrate:
+		return instance.dehydrate(action.Path, action.RemoteInfo)
+	case planner.SetInSync:
+		return instance.setInSync(instance.path_remoteToLocal(action.Path))
+	case planner.DeleteLocal:
+		return instance.deleteLocal(action.Path)
+	case planner.ConflictCopy:
+		return instance.conflictCopy(action.Path, action.Target)
+	case planner.MkdirRemote:
+		return instance.fs.MkdirAll(action.Path, 0777)
+	case planner.MoveRemote:
+		instance.Logger.Info().Msgf("Moving remote '%s' to '%s'", action.Path, action.Target)
+		return utils.Move(instance.fs, action.Path, action.Target)
+	case planner.DeleteRemote:
+		instance.Logger.Info().Msgf("Moving remote '%s' to trash", action.Path)
+		_, err := instance.trash.Move(action.Path, time.Now())
+		return err
+	case planner.Upload:
+		localpath := instance.path_remoteToLocal(action.Path)
 		instance.FileUploading(localpath, 0)
-		instance.Logger.Info().Msgf("Updating remote file '%s'", path)
-		err = instance.streamLocalToRemote(path)
+		instance.Logger.Info().Msgf("Updating remote file '%s'", action.Path)
+		err := instance.streamLocalToRemote(action.Path)
 		if err != nil {
 			instance.FileError(localpath, err)
+			// the file is uploaded again by the next synchronization
+			clearerr := setNotInSync(localpath)
+			if clearerr != nil {
+				instance.Logger.Warn().Msgf("Failed to clear in-sync state of '%s': %v", localpath, clearerr)
+			}
 			return err
-		} else {
-			instance.FileDone(localpath)
 		}
+		instance.FileDone(localpath)
+		return nil
 	}
-	return err
+	return fmt.Errorf("unsupported action %s", action)
 }
 
 func (instance *VirtualizationInstance) setInSync(localpath string) error {
@@ -218,27 +376,37 @@ func (instance *VirtualizationInstance) setInSync(localpath string) error {
 	return nil
 }
 
-func (instance *VirtualizationInstance) localHash(remotepath string) ([]byte, error) {
+// setNotInSync marks a placeholder as changed locally
+func setNotInSync(localpath string) error {
+	var handle syscall.Handle
+	hr := cfapi.CfOpenFileWithOplock(core.GetPointer(localpath), cfapi.CF_OPEN_FILE_FLAG_WRITE_ACCESS, &handle)
+	if hr != 0 {
+		return core.ErrorByCode(hr)
+	}
+	defer cfapi.CfCloseHandle(handle)
+	hr = cfapi.CfSetInSyncState(handle, cfapi.CF_IN_SYNC_STATE_NOT_IN_SYNC, cfapi.CF_SET_IN_SYNC_FLAG_NONE, nil)
+	if hr != 0 {
+		return core.ErrorByCode(hr)
+	}
+	return nil
+}
+
+func (instance *VirtualizationInstance) localHash(remotepath string, algorithm digest.Algorithm) (digest.Digest, error) {
 	localpath := instance.path_remoteToLocal(remotepath)
 	// only calculate hash if file is available on local disk
 	localstate, err := getPlaceholderState(localpath)
 	if err != nil {
-		return nil, err
+		return digest.Digest{}, err
 	}
 	if (localstate | (cfapi.CF_PLACEHOLDER_STATE_IN_SYNC)) == 0 {
-		return nil, nil
+		return digest.Digest{}, nil
 	}
-	hash := md5.New()
 	f, err := os.Open(localpath)
 	if err != nil {
-		return nil, err
+		return digest.Digest{}, err
 	}
 	defer f.Close()
-	_, err = io.Copy(hash, f)
-	if err != nil {
-		return nil, err
-	}
-	return hash.Sum(nil), nil
+	return digest.Sum(algorithm, f)
 }
 
 func getPlaceholderInfo(localpath string) (*cfapi.CF_PLACEHOLDER_BASIC_INFO, error) {
@@ -277,19 +445,6 @@ func getPlaceholderState(localpath string) (cfapi.CF_PLACEHOLDER_STATE, error) {
 	return cfapi.CF_PLACEHOLDER_STATE(result), nil
 }
 
-func (instance *VirtualizationInstance) handleDeletion(localpath string) {
-	instance.lock.Lock()
-	defer instance.lock.Unlock()
-	parentpath := filepath.Dir(localpath)
-	remoteparent := instance.path_localToRemote(parentpath)
-	remotepath := remoteparent + "/" + filepath.Base(localpath)
-	remotepath = strings.TrimPrefix(remotepath, "/")
-	err := instance.fs.RemoveAll(remotepath)
-	if err != nil {
-		instance.Logger.Printf("deleteCompletion: remove %s failed: %v", remotepath, err)
-	}
-}
-
 func (instance *VirtualizationInstance) deleteCompletion(info *cfapi.CF_CALLBACK_INFO, data *cfapi.CF_CALLBACK_PARAMETERS_DeleteCompletion) uintptr {
 	instance.lock.Lock()
 	defer instance.lock.Unlock()
@@ -297,7 +452,7 @@ func (instance *VirtualizationInstance) deleteCompletion(info *cfapi.CF_CALLBACK
 	instance.Logger.Printf("deleteCompletion: %s", filename)
 	//hashfilename := instance.path_hashFile(filename)
 
-	err := instance.fs.Remove(filename)
+	_, err := instance.trash.Move(filename, time.Now())
 	if err != nil {
 		instance.Logger.Printf("deleteCompletion: remove %s failed: %v", filename, err)
 	}
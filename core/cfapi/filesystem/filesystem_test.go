@@ -15,6 +15,7 @@ import (
 
 	"github.com/balazsgrill/potatodrive/core"
 	"github.com/balazsgrill/potatodrive/core/cfapi/filesystem"
+	"github.com/balazsgrill/potatodrive/core/trash"
 	"github.com/spf13/afero"
 )
 
@@ -52,7 +53,7 @@ func (i *testInstance) start() {
 	started := make(chan bool)
 	var err error
 	go func() {
-		i.closer, err = filesystem.StartProjecting(i.location, i.fs, zerolog.New(zerolog.NewConsoleWriter()))
+		i.closer, err = filesystem.StartProjecting(i.location, i.fs, zerolog.New(zerolog.NewConsoleWriter()), core.Options{})
 		started <- true
 		<-i.closechan
 		i.closer.Close()
@@ -211,14 +212,17 @@ func TestDeleteExistingFileOnBackend(t *testing.T) {
 	}
 
 	_, err = instance.fs.Stat(filename)
+	if err == nil {
+		t.Error("File exists")
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	items, err := trash.New(instance.fs, trash.Policy{}).List()
 	if err != nil {
-		if os.IsNotExist(err) {
-			//ok
-			return
-		}
 		t.Fatal(err)
-	} else {
-		t.Error("File exists")
+	}
+	if len(items) != 1 || items[0].Original != filename {
+		t.Errorf("expected %s in the trash, got %v", filename, items)
 	}
 }
 
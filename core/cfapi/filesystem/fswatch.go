@@ -13,8 +13,12 @@ func (instance *VirtualizationInstance) watch() {
 	}()
 	for event := range instance.watcher.Events {
 		instance.Logger.Debug().Msgf("Received event: %s", event)
-		if event.Op&fsnotify.Remove == fsnotify.Remove {
-			instance.handleDeletion(event.Name)
+		if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+			// deletions and moves are detected and propagated by the next synchronization
+			instance.Logger.Debug().Msgf("'%s' is removed locally", event.Name)
+		}
+		if instance.changed != nil {
+			instance.changed()
 		}
 	}
 }
@@ -1,7 +1,7 @@
 package filesystem
 
 import (
-	"strings"
+	"path"
 	"syscall"
 	"unsafe"
 
@@ -28,7 +28,7 @@ func (instance *VirtualizationInstance) fetchPlaceholders(info *cfapi.CF_CALLBAC
 	count := 0
 	placeholders := make([]cfapi.CF_PLACEHOLDER_CREATE_INFO, len(files))
 	for _, f := range files {
-		if !strings.HasPrefix(f.Name(), ".") {
+		if !instance.options.Skips(path.Join(remotepath, f.Name())) && !instance.ignore.Match(path.Join(remotepath, f.Name()), f.IsDir()) {
 			placeholders[count] = getPlaceholder(f)
 
 			count += 1
@@ -1,113 +1,95 @@
 package filesystem
 
 import (
-	"bytes"
-	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 
+	"github.com/balazsgrill/potatodrive/core"
 	"github.com/balazsgrill/potatodrive/core/cfapi"
-	"github.com/spf13/afero"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/planner"
 )
 
-// isDeletedRemotely check whether file was deleted remotely
-// if it was, it compares local hash with remote hash. Returns true only if the file has been deleted remotely and was not changed locally
-func (instance *VirtualizationInstance) isDeletedRemotely(remotepath string, localpath string) (bool, error) {
-	_, err := instance.fs.Stat(remotepath)
-	if os.IsNotExist(err) {
-		// chek if remote hash is known
-		hash, err := instance.remoteCacheState.GetHash(remotepath)
-		if err != nil {
-			return false, err
-		}
-		if len(hash) > 0 {
-			// on remote file existed before, upload only if hash is different
-			localhash, err := instance.localHash(remotepath)
-			if err != nil {
-				return false, err
-			}
-			if localhash == nil {
-				// local file does not exist, no need to upload
-				// TODO is this a tombstone?
-				return false, nil
-			}
-			if bytes.Equal(hash, localhash) {
-				// hash is the same this file has been removed remotely, delete local file
-				return true, nil
-			}
-		}
-
-	}
-	return false, nil
+// localState provides the view of the sync root for the planner
+type localState struct {
+	instance *VirtualizationInstance
 }
 
-func (instance *VirtualizationInstance) syncLocalToRemote() ([]string, error) {
-	uploads := []string{}
-	return uploads, filepath.Walk(instance.rootPath, func(localpath string, localinfo fs.FileInfo, err error) error {
-		instance.Logger.Debug().Msgf("Syncing local file '%s'", localpath)
-		if os.IsNotExist(err) {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+var _ planner.Local = (*localState)(nil)
 
-		path := instance.path_localToRemote(localpath)
-		if strings.HasPrefix(path, ".") {
-			return filepath.SkipDir
-		}
-		if localinfo.IsDir() {
-			if dir, err := afero.IsDir(instance.fs, path); dir {
-				return err
-			}
-			return instance.fs.MkdirAll(path, 0777)
-		}
+func (l *localState) toLocalFile(localpath string, localinfo fs.FileInfo) (planner.LocalFile, error) {
+	file := planner.LocalFile{FileInfo: localinfo}
+	if localinfo.IsDir() {
+		return file, nil
+	}
+	localstate, err := getPlaceholderState(localpath)
+	if err != nil {
+		return file, err
+	}
+	l.instance.Logger.Debug().Msgf("Local state of '%s' is %x", localpath, localstate)
+	file.InSync = (localstate & cfapi.CF_PLACEHOLDER_STATE_IN_SYNC) != 0
+	return file, nil
+}
 
-		localstate, err := getPlaceholderState(localpath)
-		if err != nil {
-			return err
-		}
-		instance.Logger.Debug().Msgf("Local state %x", localstate)
+// Stat implements planner.Local.
+func (l *localState) Stat(path string) (planner.LocalFile, error) {
+	localpath := l.instance.path_remoteToLocal(path)
+	localinfo, err := os.Stat(localpath)
+	if err != nil {
+		return planner.LocalFile{}, err
+	}
+	return l.toLocalFile(localpath, localinfo)
+}
 
-		deleted, err := instance.isDeletedRemotely(path, localpath)
+// Walk implements planner.Local.
+func (l *localState) Walk(walkFn func(path string, file planner.LocalFile, err error) error) error {
+	return filepath.Walk(l.instance.rootPath, func(localpath string, localinfo fs.FileInfo, err error) error {
+		l.instance.Logger.Debug().Msgf("Syncing local file '%s'", localpath)
+		path := l.instance.path_localToRemote(localpath)
 		if err != nil {
-			return err
+			return walkFn(path, planner.LocalFile{FileInfo: localinfo}, err)
 		}
+		file, err := l.toLocalFile(localpath, localinfo)
+		return walkFn(path, file, err)
+	})
+}
 
-		if ((localstate & cfapi.CF_PLACEHOLDER_STATE_IN_SYNC) == 0) && (!deleted) {
-			// local file is a hydrated placeholder, but not in sync, upload it if local is newer
+// Hash implements planner.Local.
+func (l *localState) Hash(path string, algorithm digest.Algorithm) (digest.Digest, error) {
+	return l.instance.localHash(path, algorithm)
+}
 
-			remoteinfo, err := instance.fs.Stat(path)
-			var localisnewer bool
-			if os.IsNotExist(err) {
-				localisnewer = true
-			} else if err != nil {
-				return fmt.Errorf("syncLocalToRemote.1 %w", err)
-			} else if remoteinfo == nil {
-				return fmt.Errorf("syncLocalToRemote.2 NPE")
-			} else {
-				localisnewer = (localinfo.ModTime().UTC().Unix() > remoteinfo.ModTime().UTC().Unix())
-			}
+// ID implements planner.Local. The file index is kept when the file is renamed within the volume.
+func (l *localState) ID(path string) (uint64, error) {
+	id, err := core.GetFileID(l.instance.path_remoteToLocal(path))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return id, err
+}
 
-			if localisnewer {
-				uploads = append(uploads, path)
-			}
-			// mark file as in-sync
-			return instance.setInSync(localpath)
-		}
+// conflictCopy moves the local version of a conflicting file aside, the remote version is placed to the original path afterwards
+func (instance *VirtualizationInstance) conflictCopy(path string, target string) error {
+	localpath := instance.path_remoteToLocal(path)
+	localtarget := instance.path_remoteToLocal(target)
+	instance.Logger.Warn().Msgf("Conflict on '%s', local version is saved as '%s'", path, target)
+	err := os.Rename(localpath, localtarget)
+	if err != nil {
+		instance.FileError(localpath, err)
+		return err
+	}
+	instance.FileConflict(localpath, localtarget)
+	return nil
+}
 
-		if deleted {
-			err := os.Remove(localpath)
-			if err != nil {
-				instance.FileError(localpath, err)
-				return err
-			} else {
-				instance.FileRemoved(localpath)
-			}
-			return err
-		}
-		return nil
-	})
+func (instance *VirtualizationInstance) deleteLocal(path string) error {
+	localpath := instance.path_remoteToLocal(path)
+	err := os.RemoveAll(localpath)
+	if err != nil {
+		instance.FileError(localpath, err)
+		return err
+	}
+	instance.FileRemoved(localpath)
+	return nil
 }
@@ -1,13 +1,12 @@
 package filesystem
 
 import (
-	"crypto/md5"
-	"io"
 	"os"
-)
 
-const MB int = 1024 * 1024
+	"github.com/balazsgrill/potatodrive/core"
+)
 
+// streamLocalToRemote uploads the local file, an interrupted upload of the same version is continued
 func (instance *VirtualizationInstance) streamLocalToRemote(filename string) error {
 	localpath := instance.path_remoteToLocal(filename)
 	file, err := os.Open(localpath)
@@ -15,37 +14,21 @@ func (instance *VirtualizationInstance) streamLocalToRemote(filename string) err
 		return err
 	}
 	defer file.Close()
-	data := make([]byte, MB)
-	targetfile, err := instance.fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
+	hash, err := instance.uploader.Upload(file, filename, func(percent int) {
+		instance.FileUploading(localpath, percent)
+	})
 	if err != nil {
 		return err
 	}
-	defer targetfile.Close()
-
-	hash := md5.New()
-	done := false
-	for !done {
-		instance.Logger.Debug().Msgf("reading")
-		n, err := file.Read(data)
-		if err != nil {
-			if err == io.EOF {
-				done = true
-			} else {
-				return err
-			}
-		}
-		_, err = hash.Write(data[:n])
-		if err != nil {
-			return err
-		}
-		instance.Logger.Debug().Msgf("Uploading %d bytes", n)
-		n2, err := targetfile.Write(data[:n])
-		if err != nil {
-			return err
-		}
-		instance.Logger.Debug().Msgf("uploaded chunk %d", n2)
-	}
 	instance.Logger.Debug().Msg("Done uploading")
 
-	return instance.remoteCacheState.UpdateHash(filename, hash.Sum(nil))
+	err = instance.remoteCacheState.UpdateHash(filename, hash)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return core.PreserveModTime(instance.fs, instance.remoteCacheState, filename, info.ModTime())
 }
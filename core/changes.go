@@ -0,0 +1,10 @@
+package core
+
+// ChangeLister is implemented by remote file systems that can tell which paths have changed since an earlier
+// point in time, so a synchronization does not need to walk the whole remote tree.
+type ChangeLister interface {
+	// Changes returns the paths changed since the cursor and the cursor to continue from. Directories in
+	// the result are listed recursively by the caller. An empty cursor returns the current cursor without paths.
+	// An error is returned if the changes since the cursor are not known any more.
+	Changes(cursor string) ([]string, string, error)
+}
@@ -0,0 +1,154 @@
+// Package digest calculates the content hashes local and remote files are compared by
+package digest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Algorithm identifies a hash function
+type Algorithm string
+
+const (
+	MD5    Algorithm = "md5"
+	SHA256 Algorithm = "sha256"
+	// XXHash is the 64 bit xxHash, a fast non-cryptographic hash
+	XXHash Algorithm = "xxhash"
+)
+
+var Algorithms = []Algorithm{MD5, SHA256, XXHash}
+
+// Default is the algorithm used if none is configured, hashes recorded by earlier versions are md5 as well
+const Default = MD5
+
+// Parse returns the algorithm of the given name, or Default if it is empty
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return Default, nil
+	}
+	for _, algorithm := range Algorithms {
+		if string(algorithm) == name {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm: %s", name)
+}
+
+// New returns a hash of the algorithm, or nil if the algorithm is not supported. The empty algorithm is Default.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case MD5, "":
+		return md5.New()
+	case SHA256:
+		return sha256.New()
+	case XXHash:
+		return xxhash.New()
+	}
+	return nil
+}
+
+// Digest is a content hash with the algorithm it has been calculated by, the zero value is an unknown hash
+type Digest struct {
+	Algorithm Algorithm
+	Sum       []byte
+}
+
+// Of returns the digest of the content written to the hash, which must have been created by the algorithm
+func Of(a Algorithm, h hash.Hash) Digest {
+	if a == "" {
+		a = Default
+	}
+	return Digest{Algorithm: a, Sum: h.Sum(nil)}
+}
+
+// Sum calculates the digest of the content read from r, it is zero if the algorithm is not supported
+func Sum(a Algorithm, r io.Reader) (Digest, error) {
+	h := a.New()
+	if h == nil {
+		return Digest{}, nil
+	}
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return Digest{}, err
+	}
+	return Of(a, h), nil
+}
+
+func (d Digest) IsZero() bool {
+	return len(d.Sum) == 0
+}
+
+// Comparable returns true if both digests are known and calculated by the same algorithm
+func (d Digest) Comparable(other Digest) bool {
+	return !d.IsZero() && !other.IsZero() && d.Algorithm == other.Algorithm
+}
+
+// Equal returns true if both digests are known and the same, digests of different algorithms are never equal
+func (d Digest) Equal(other Digest) bool {
+	return d.Comparable(other) && bytes.Equal(d.Sum, other.Sum)
+}
+
+// String returns the digest like "sha256:<hex>", or an empty string if it is unknown
+func (d Digest) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return string(d.Algorithm) + ":" + hex.EncodeToString(d.Sum)
+}
+
+// ParseString parses the result of String
+func ParseString(s string) (Digest, error) {
+	if s == "" {
+		return Digest{}, nil
+	}
+	name, sum, found := strings.Cut(s, ":")
+	if !found {
+		return Digest{}, fmt.Errorf("digest without algorithm: %s", s)
+	}
+	decoded, err := hex.DecodeString(sum)
+	if err != nil {
+		return Digest{}, err
+	}
+	return Digest{Algorithm: Algorithm(name), Sum: decoded}, nil
+}
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Digest) UnmarshalText(text []byte) error {
+	parsed, err := ParseString(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Checksummer is implemented by remote file systems that can calculate checksums on the server,
+// so files do not have to be downloaded to be verified
+type Checksummer interface {
+	// Checksum returns the checksum of a remote file by the algorithm, a zero digest if the algorithm or the file
+	// is not supported
+	Checksum(name string, algorithm Algorithm) (Digest, error)
+}
+
+// Native returns the checksum of a file calculated by the file system if it is a Checksummer, a zero digest otherwise
+func Native(fs any, name string, algorithm Algorithm) (Digest, error) {
+	checksummer, ok := fs.(Checksummer)
+	if !ok {
+		return Digest{}, nil
+	}
+	if algorithm == "" {
+		algorithm = Default
+	}
+	return checksummer.Checksum(name, algorithm)
+}
@@ -0,0 +1,53 @@
+package digest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+)
+
+func sum(t *testing.T, algorithm digest.Algorithm, content string) digest.Digest {
+	t.Helper()
+	d, err := digest.Sum(algorithm, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDigestIsParsedBack(t *testing.T) {
+	for _, algorithm := range digest.Algorithms {
+		d := sum(t, algorithm, "content")
+		if !strings.HasPrefix(d.String(), string(algorithm)+":") {
+			t.Errorf("%s is not self-describing", d)
+		}
+		parsed, err := digest.ParseString(d.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !parsed.Equal(d) {
+			t.Errorf("expected %v, got %v", d, parsed)
+		}
+	}
+}
+
+func TestDifferentAlgorithmsAreNeverEqual(t *testing.T) {
+	md5 := sum(t, digest.MD5, "a")
+	other := digest.Digest{Algorithm: digest.SHA256, Sum: md5.Sum}
+	if md5.Equal(other) || md5.Comparable(other) {
+		t.Error("digests of different algorithms are compared")
+	}
+	if (digest.Digest{}).Equal(digest.Digest{}) {
+		t.Error("unknown digests are equal")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	if algorithm, err := digest.Parse(""); err != nil || algorithm != digest.Default {
+		t.Errorf("empty name is parsed as %s (%v)", algorithm, err)
+	}
+	if _, err := digest.Parse("crc32"); err == nil {
+		t.Error("unknown algorithm is accepted")
+	}
+}
@@ -1,16 +1,9 @@
+//go:build windows
+
 package core
 
 import "github.com/balazsgrill/potatodrive/core/tasks"
 
-type FileStateCallbacks interface {
-	FileSynchronizing(path string)
-	FileDone(path string)
-	FileRemoved(path string)
-	FileError(path string, err error)
-	FileDownloading(path string, progress int)
-	FileUploading(path string, progress int)
-}
-
 type fileStatesAsTasks struct {
 	listener tasks.TaskStateListener
 }
@@ -87,6 +80,31 @@ func (f *fileStatesAsTasks) FileUploading(path string, progress int) {
 	}
 }
 
+// FileConflict reports the conflicted copy, the original path holds the remote version by now
+func (f *fileStatesAsTasks) FileConflict(path string, conflictcopy string) {
+	id, err := GetFileID(conflictcopy)
+	if err == nil {
+		f.listener(tasks.TaskState{
+			ID:       id,
+			Name:     conflictcopy,
+			State:    "Conflict",
+			Progress: 100,
+		})
+	}
+}
+
+func (f *fileStatesAsTasks) FileNameCollision(path string, remotepath string) {
+	id, err := GetFileID(path)
+	if err == nil {
+		f.listener(tasks.TaskState{
+			ID:       id,
+			Name:     path,
+			State:    "Name collision",
+			Progress: 100,
+		})
+	}
+}
+
 func AsCallbacks(listener tasks.TaskStateListener) FileStateCallbacks {
 	return &fileStatesAsTasks{listener}
 }
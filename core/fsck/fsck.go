@@ -0,0 +1,215 @@
+// Package fsck checks the remote of a binding and the state kept about it for metadata left behind by other tools
+// and crashed uploads, and for recorded hashes not matching the content, and repairs them
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+// Kind is the type of a problem found on the remote
+type Kind int
+
+const (
+	// OrphanedSidecar is a hidden hash or modification time file of a remote file that does not exist
+	OrphanedSidecar Kind = iota
+	// StaleTemporary is a temporary file of an upload untouched for longer than upload.TemporaryRetention
+	StaleTemporary
+	// HashMismatch is a recorded hash that differs from the hash of the current content
+	HashMismatch
+	// OrphanedRecord is a hash or modification time in the manifest or the local database of a remote file that
+	// does not exist
+	OrphanedRecord
+)
+
+var kindNames = []string{
+	OrphanedSidecar: "orphaned sidecar",
+	StaleTemporary:  "stale temporary file",
+	HashMismatch:    "hash mismatch",
+	OrphanedRecord:  "orphaned record",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Problem is an inconsistency found on the remote
+type Problem struct {
+	Kind Kind
+	Path string
+	// Detail describes the problem, like the recorded and the actual hash
+	Detail string
+	// Repaired is true if the problem has been repaired
+	Repaired bool
+}
+
+// Options of a check
+type Options struct {
+	// Repair removes orphaned sidecars, records and stale temporary files, and records the hash of the current
+	// content
+	Repair bool
+	// Download reads the files the remote does not calculate checksums of, otherwise only those are verified
+	Download bool
+}
+
+// Checker walks a remote and its state
+type Checker struct {
+	fs      afero.Fs
+	state   core.RemoteStateCache
+	options Options
+}
+
+func New(fs afero.Fs, state core.RemoteStateCache, options Options) *Checker {
+	return &Checker{fs: fs, state: state, options: options}
+}
+
+// finding is a problem with the way to repair it
+type finding struct {
+	Problem
+	repair func() error
+}
+
+// Check walks the whole remote and returns the problems found. Problems are repaired after the walk if enabled,
+// a failed repair does not stop the others.
+func (c *Checker) Check() ([]Problem, error) {
+	var findings []finding
+	err := utils.Walk(c.fs, "", func(remotepath string, info fs.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("check %s: %w", remotepath, err)
+		}
+		if remotepath == "" || info.IsDir() {
+			return nil
+		}
+		found, err := c.checkFile(remotepath, info)
+		if err != nil {
+			return fmt.Errorf("check %s: %w", remotepath, err)
+		}
+		if found != nil {
+			findings = append(findings, *found)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lister, ok := c.state.(core.RecordLister); ok {
+		found, err := c.checkRecords(lister)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, found...)
+	}
+	problems := make([]Problem, len(findings))
+	var errs []error
+	for i, found := range findings {
+		if c.options.Repair {
+			err = found.repair()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("repair %s: %w", found.Path, err))
+			}
+			found.Repaired = err == nil
+		}
+		problems[i] = found.Problem
+	}
+	return problems, errors.Join(errs...)
+}
+
+func (c *Checker) checkFile(remotepath string, info fs.FileInfo) (*finding, error) {
+	if upload.IsTemporary(remotepath) {
+		if time.Since(info.ModTime()) <= upload.TemporaryRetention {
+			// the upload may still be running
+			return nil, nil
+		}
+		return &finding{
+			Problem: Problem{Kind: StaleTemporary, Path: remotepath, Detail: "untouched since " + info.ModTime().Format(time.RFC3339)},
+			repair:  func() error { return c.fs.Remove(remotepath) },
+		}, nil
+	}
+	if metadata.InReservedDir(remotepath) {
+		return nil, nil
+	}
+	if target, ok := core.SidecarTarget(remotepath); ok {
+		exists, err := afero.Exists(c.fs, target)
+		if err != nil || exists {
+			return nil, err
+		}
+		return &finding{
+			Problem: Problem{Kind: OrphanedSidecar, Path: remotepath, Detail: target + " does not exist"},
+			repair:  func() error { return c.fs.Remove(remotepath) },
+		}, nil
+	}
+	if metadata.IsReserved(remotepath) {
+		return nil, nil
+	}
+	return c.checkHash(remotepath)
+}
+
+// checkRecords finds the records of a state kept apart from the remote files that belong to no remote file
+func (c *Checker) checkRecords(lister core.RecordLister) ([]finding, error) {
+	recorded, err := lister.Recorded()
+	if err != nil {
+		return nil, fmt.Errorf("check records: %w", err)
+	}
+	var findings []finding
+	for _, remotepath := range recorded {
+		exists, err := afero.Exists(c.fs, remotepath)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", remotepath, err)
+		}
+		if !exists {
+			findings = append(findings, finding{
+				Problem: Problem{Kind: OrphanedRecord, Path: remotepath, Detail: remotepath + " does not exist"},
+				repair:  func() error { return lister.Forget(remotepath) },
+			})
+		}
+	}
+	return findings, nil
+}
+
+// checkHash compares the recorded hash of a file to the checksum calculated by the remote, or to the hash of the
+// content if downloading is enabled
+func (c *Checker) checkHash(remotepath string) (*finding, error) {
+	recorded, err := c.state.GetHash(remotepath)
+	if err != nil || recorded.IsZero() {
+		return nil, err
+	}
+	actual, err := digest.Native(c.fs, remotepath, recorded.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if actual.IsZero() && c.options.Download {
+		actual, err = c.sum(remotepath, recorded.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if actual.IsZero() || actual.Equal(recorded) {
+		return nil, nil
+	}
+	return &finding{
+		Problem: Problem{Kind: HashMismatch, Path: remotepath, Detail: fmt.Sprintf("recorded %s, actual %s", recorded, actual)},
+		repair:  func() error { return c.state.UpdateHash(remotepath, actual) },
+	}, nil
+}
+
+func (c *Checker) sum(remotepath string, algorithm digest.Algorithm) (digest.Digest, error) {
+	file, err := c.fs.Open(remotepath)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	defer file.Close()
+	return digest.Sum(algorithm, file)
+}
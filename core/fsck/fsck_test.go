@@ -0,0 +1,170 @@
+package fsck_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/fsck"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+func hashOf(content string) digest.Digest {
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader(content))
+	return hash
+}
+
+type found struct {
+	Kind fsck.Kind
+	Path string
+}
+
+// newRemote creates a remote with a problem of each kind next to consistent files
+func newRemote(t *testing.T) (afero.Fs, core.RemoteStateCache) {
+	fs := afero.NewMemMapFs()
+	state := core.HashFilesRemotely(fs)
+	fs.MkdirAll("docs", 0777)
+	afero.WriteFile(fs, "docs/a.txt", []byte("a"), 0666)
+	state.UpdateHash("docs/a.txt", hashOf("a"))
+	state.UpdateModTime("docs/a.txt", core.ModTime{})
+	afero.WriteFile(fs, "docs/b.txt", []byte("changed by another tool"), 0666)
+	state.UpdateHash("docs/b.txt", hashOf("b"))
+	// the file has been deleted by another tool
+	afero.WriteFile(fs, "docs/.md5_c.txt", hashOf("c").Sum, 0666)
+	state.UpdateModTime("docs/c.txt", core.ModTime{})
+	state.PutTombstone("docs/d.txt", core.Tombstone{Deleted: time.Now()})
+
+	stale := time.Now().Add(-2 * upload.TemporaryRetention)
+	afero.WriteFile(fs, "docs/"+upload.TemporaryPrefix+"stale", []byte("x"), 0666)
+	fs.Chtimes("docs/"+upload.TemporaryPrefix+"stale", stale, stale)
+	afero.WriteFile(fs, "docs/"+upload.TemporaryPrefix+"running", []byte("x"), 0666)
+	return fs, state
+}
+
+func check(t *testing.T, checker *fsck.Checker, expected ...found) []fsck.Problem {
+	t.Helper()
+	problems, err := checker.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := make([]found, len(problems))
+	for i, problem := range problems {
+		actual[i] = found{Kind: problem.Kind, Path: problem.Path}
+	}
+	if len(expected) == 0 {
+		expected = []found{}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, problems)
+	}
+	return problems
+}
+
+func TestProblemsAreReported(t *testing.T) {
+	fs, state := newRemote(t)
+	problems := check(t, fsck.New(fs, state, fsck.Options{}),
+		found{fsck.OrphanedSidecar, "docs/.md5_c.txt"},
+		found{fsck.OrphanedSidecar, "docs/.mtime_c.txt"},
+		found{fsck.StaleTemporary, "docs/" + upload.TemporaryPrefix + "stale"},
+	)
+	for _, problem := range problems {
+		if problem.Repaired {
+			t.Errorf("%s is repaired", problem.Path)
+		}
+	}
+	if exists, _ := afero.Exists(fs, "docs/.md5_c.txt"); !exists {
+		t.Error("orphaned sidecar is removed without repair")
+	}
+
+	// hashes are verified only if the content is read
+	check(t, fsck.New(fs, state, fsck.Options{Download: true}),
+		found{fsck.OrphanedSidecar, "docs/.md5_c.txt"},
+		found{fsck.OrphanedSidecar, "docs/.mtime_c.txt"},
+		found{fsck.StaleTemporary, "docs/" + upload.TemporaryPrefix + "stale"},
+		found{fsck.HashMismatch, "docs/b.txt"},
+	)
+}
+
+func TestProblemsAreRepaired(t *testing.T) {
+	fs, state := newRemote(t)
+	problems, err := fsck.New(fs, state, fsck.Options{Repair: true, Download: true}).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 4 {
+		t.Errorf("expected 4 problems, got %v", problems)
+	}
+	for _, problem := range problems {
+		if !problem.Repaired {
+			t.Errorf("%s is not repaired", problem.Path)
+		}
+	}
+	check(t, fsck.New(fs, state, fsck.Options{Download: true}))
+
+	if hash, _ := state.GetHash("docs/b.txt"); !hash.Equal(hashOf("changed by another tool")) {
+		t.Errorf("hash of the current content is not recorded: %v", hash)
+	}
+	for _, kept := range []string{"docs/.hash_a.txt", "docs/.mtime_a.txt", "docs/.tomb_d.txt", "docs/" + upload.TemporaryPrefix + "running"} {
+		if exists, _ := afero.Exists(fs, kept); !exists {
+			t.Errorf("%s is removed", kept)
+		}
+	}
+}
+
+func TestOrphanedRecordsAreRepaired(t *testing.T) {
+	states := map[string]func(t *testing.T, fs afero.Fs) core.RemoteStateCache{
+		"manifest": func(t *testing.T, fs afero.Fs) core.RemoteStateCache {
+			return core.NewManifestCache(fs)
+		},
+		"local": func(t *testing.T, fs afero.Fs) core.RemoteStateCache {
+			db, err := core.OpenStateDB(filepath.Join(t.TempDir(), core.StateDBFile), "test", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return db
+		},
+	}
+	for name, open := range states {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			state := open(t, fs)
+			fs.MkdirAll("docs", 0777)
+			afero.WriteFile(fs, "docs/a.txt", []byte("a"), 0666)
+			state.UpdateHash("docs/a.txt", hashOf("a"))
+			state.UpdateModTime("docs/a.txt", core.ModTime{})
+			// the files have been deleted by another tool
+			state.UpdateHash("docs/c.txt", hashOf("c"))
+			state.UpdateModTime("docs/c.txt", core.ModTime{})
+			state.UpdateModTime("e.txt", core.ModTime{})
+			state.PutTombstone("docs/d.txt", core.Tombstone{Deleted: time.Now()})
+
+			check(t, fsck.New(fs, state, fsck.Options{}),
+				found{fsck.OrphanedRecord, "docs/c.txt"},
+				found{fsck.OrphanedRecord, "e.txt"},
+			)
+			problems, err := fsck.New(fs, state, fsck.Options{Repair: true}).Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range problems {
+				if !problem.Repaired {
+					t.Errorf("%s is not repaired", problem.Path)
+				}
+			}
+			check(t, fsck.New(fs, state, fsck.Options{}))
+
+			if hash, _ := state.GetHash("docs/a.txt"); !hash.Equal(hashOf("a")) {
+				t.Error("record of an existing file is removed")
+			}
+			if tombstone, _ := state.GetTombstone("docs/d.txt"); tombstone == nil {
+				t.Error("tombstone is removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package ignore implements gitignore-style rules that select the files excluded from synchronization
+package ignore
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/spf13/afero"
+)
+
+// FileName is the name of the optional rules file at the remote root
+const FileName = metadata.IgnoreFile
+
+type rule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides whether a path is excluded. Rules are evaluated in order, the last matching rule wins.
+// A nil Matcher does not exclude anything.
+type Matcher struct {
+	rules []rule
+}
+
+// Parse creates a matcher from patterns separated by new lines or semicolons. Empty lines and lines
+// starting with '#' are ignored.
+func Parse(patterns string) (*Matcher, error) {
+	m := &Matcher{}
+	err := m.add(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load creates a matcher from the given patterns followed by the rules of the FileName file at the root of fs, if it exists
+func Load(fs afero.Fs, patterns string) (*Matcher, error) {
+	m, err := Parse(patterns)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(fs, FileName)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = m.add(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Matcher) add(patterns string) error {
+	lines := strings.FieldsFunc(patterns, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ';'
+	})
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := rule{}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := globToRegexp(line)
+		if !anchored {
+			// patterns without a slash match a name at any depth
+			expr = "(.*/)?" + expr
+		}
+		pattern, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return err
+		}
+		r.pattern = pattern
+		m.rules = append(m.rules, r)
+	}
+	return nil
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Match returns true if the path ("/" separated, relative to the root) is excluded.
+// A path is also excluded if any of its parent directories is.
+func (m *Matcher) Match(p string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+	p = strings.Trim(p, "/")
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if m.match(dir, true) {
+			return true
+		}
+	}
+	return m.match(p, isDir)
+}
+
+func (m *Matcher) match(p string, isDir bool) bool {
+	excluded := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.pattern.MatchString(p) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
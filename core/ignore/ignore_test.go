@@ -0,0 +1,85 @@
+package ignore_test
+
+import (
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/ignore"
+	"github.com/spf13/afero"
+)
+
+type testcase struct {
+	path     string
+	isDir    bool
+	excluded bool
+}
+
+func check(t *testing.T, m *ignore.Matcher, cases ...testcase) {
+	for _, c := range cases {
+		if m.Match(c.path, c.isDir) != c.excluded {
+			t.Errorf("%s: expected excluded=%t", c.path, c.excluded)
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	m, err := ignore.Parse("node_modules/\n*.tmp;.*.sw?\n# comment\n/build\ndocs/**/draft-*.md\n!keep.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, m,
+		testcase{"node_modules", true, true},
+		testcase{"src/node_modules/a/b.js", false, true},
+		testcase{"node_modules", false, false},
+		testcase{"a.tmp", false, true},
+		testcase{"dir/a.tmp", false, true},
+		testcase{"dir/keep.tmp", false, false},
+		testcase{"dir/.a.txt.swp", false, true},
+		testcase{"build", true, true},
+		testcase{"build/out.exe", false, true},
+		testcase{"src/build", true, false},
+		testcase{"docs/draft-1.md", false, true},
+		testcase{"docs/a/b/draft-2.md", false, true},
+		testcase{"docs/final.md", false, false},
+		testcase{"a.txt", false, false},
+	)
+}
+
+func TestOnlySomeFolders(t *testing.T) {
+	m, err := ignore.Parse("/*;!/photos/;!/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, m,
+		testcase{"photos", true, false},
+		testcase{"photos/2024/a.jpg", false, false},
+		testcase{"docs/a.md", false, false},
+		testcase{"videos", true, true},
+		testcase{"videos/a.mp4", false, true},
+		testcase{"readme.txt", false, true},
+	)
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *ignore.Matcher
+	check(t, m, testcase{"a.txt", false, false})
+}
+
+func TestLoad(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	m, err := ignore.Load(fs, "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, m, testcase{"a.tmp", false, true}, testcase{"a.bak", false, false})
+
+	afero.WriteFile(fs, ignore.FileName, []byte("*.bak\n!important.tmp\n"), 0666)
+	m, err = ignore.Load(fs, "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, m,
+		testcase{"a.tmp", false, true},
+		testcase{"a.bak", false, true},
+		testcase{"important.tmp", false, false},
+	)
+}
@@ -0,0 +1,204 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/spf13/afero"
+)
+
+// Version describes the state of a file on one side at the time of the last synchronization
+type Version struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+	// Hash is the content hash with its algorithm
+	Hash digest.Digest `json:"hash"`
+	// Reported is the modification time reported by a remote that can not set it, ModTime is the one of the local
+	// source then. It is 0 if the remote reports ModTime itself.
+	Reported int64 `json:"reported,omitempty"`
+}
+
+// sourceTimeInfo is implemented by file infos of remote files carrying the modification time of their local source
+// instead of the one reported by the remote, see core.WithSourceModTime
+type sourceTimeInfo interface {
+	ReportedModTime() time.Time
+}
+
+func VersionOf(info fs.FileInfo, hash digest.Digest) Version {
+	version := Version{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC().Unix(),
+		Hash:    hash,
+	}
+	if source, ok := info.(sourceTimeInfo); ok {
+		version.Reported = source.ReportedModTime().UTC().Unix()
+	}
+	return version
+}
+
+// Matches returns true if size and modification time of the file are the same as recorded
+func (v Version) Matches(info fs.FileInfo) bool {
+	return v.Size == info.Size() && v.ModTime == info.ModTime().UTC().Unix()
+}
+
+// MatchesReported returns true if a remote still reports the recorded version of a file, before the modification
+// time of its source is applied
+func (v Version) MatchesReported(info fs.FileInfo) bool {
+	if v.Reported == 0 {
+		return v.Matches(info)
+	}
+	return v.Size == info.Size() && v.Reported == info.ModTime().UTC().Unix()
+}
+
+// Entry is the last synchronized version of a path on both sides
+type Entry struct {
+	Local  Version `json:"local"`
+	Remote Version `json:"remote"`
+	// Dir is true if the path is a directory, versions are not recorded for directories
+	Dir bool `json:"dir,omitempty"`
+	// LocalID is the stable identity of the local file, 0 if unknown
+	LocalID uint64 `json:"id,omitempty"`
+}
+
+// Journal keeps track of the last synchronized version of every path of a binding
+type Journal interface {
+	Get(path string) (Entry, bool)
+	Put(path string, entry Entry)
+	// Remove forgets the path and everything below it
+	Remove(path string)
+	// Move changes the path of an entry and everything below it
+	Move(oldpath string, newpath string)
+	// Range calls fn for every entry in no particular order, fn must not modify the journal
+	Range(fn func(path string, entry Entry))
+	Save() error
+}
+
+type memoryJournal struct {
+	lock    sync.Mutex
+	entries map[string]Entry
+}
+
+// New creates a journal that is kept in memory only
+func New() Journal {
+	return &memoryJournal{
+		entries: make(map[string]Entry),
+	}
+}
+
+func (j *memoryJournal) Get(path string) (Entry, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	entry, ok := j.entries[path]
+	return entry, ok
+}
+
+func (j *memoryJournal) Put(path string, entry Entry) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.entries[path] = entry
+}
+
+func (j *memoryJournal) Remove(path string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for p := range j.entries {
+		if isBelow(p, path) {
+			delete(j.entries, p)
+		}
+	}
+}
+
+func (j *memoryJournal) Move(oldpath string, newpath string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	moved := make(map[string]Entry)
+	for p, entry := range j.entries {
+		if isBelow(p, oldpath) {
+			moved[newpath+strings.TrimPrefix(p, oldpath)] = entry
+			delete(j.entries, p)
+		}
+	}
+	for p, entry := range moved {
+		j.entries[p] = entry
+	}
+}
+
+func (j *memoryJournal) Range(fn func(path string, entry Entry)) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for p, entry := range j.entries {
+		fn(p, entry)
+	}
+}
+
+// isBelow returns true if p is the same as parent or is inside it
+func isBelow(p string, parent string) bool {
+	return p == parent || strings.HasPrefix(p, parent+"/")
+}
+
+func (j *memoryJournal) Save() error {
+	return nil
+}
+
+type fileJournal struct {
+	memoryJournal
+	fs       afero.Fs
+	filename string
+}
+
+// Open loads the journal stored in the given file, an empty journal is returned if the file does not exist yet
+func Open(fs afero.Fs, filename string) (Journal, error) {
+	j := &fileJournal{
+		memoryJournal: memoryJournal{
+			entries: make(map[string]Entry),
+		},
+		fs:       fs,
+		filename: filename,
+	}
+	data, err := afero.ReadFile(fs, filename)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &j.entries)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Save writes the journal to a temporary file first, then replaces the previous one
+func (j *fileJournal) Save() error {
+	j.lock.Lock()
+	data, err := json.Marshal(j.entries)
+	j.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = j.fs.MkdirAll(filepath.Dir(j.filename), 0777)
+	if err != nil {
+		return err
+	}
+	tmpfile := j.filename + ".tmp"
+	err = afero.WriteFile(j.fs, tmpfile, data, 0666)
+	if err != nil {
+		return err
+	}
+	return j.fs.Rename(tmpfile, j.filename)
+}
+
+// OpenDir loads the journal kept in the given data folder, or creates an in-memory one if no folder is given
+func OpenDir(datadir string) (Journal, error) {
+	if datadir == "" {
+		return New(), nil
+	}
+	return Open(afero.NewOsFs(), filepath.Join(datadir, "journal.json"))
+}
@@ -0,0 +1,80 @@
+package journal_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/spf13/afero"
+)
+
+func TestJournalIsPersisted(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	j, err := journal.Open(fs, "data/journal.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j.Get("a.txt"); ok {
+		t.Error("new journal should be empty")
+	}
+	entry := journal.Entry{
+		Local:  journal.Version{Size: 1, ModTime: 100},
+		Remote: journal.Version{Size: 1, ModTime: 200, Hash: digest.Digest{Algorithm: digest.SHA256, Sum: []byte{1, 2, 3}}},
+	}
+	j.Put("a.txt", entry)
+	j.Put("b.txt", entry)
+	j.Remove("b.txt")
+	err = j.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(fs, "data/journal.json.tmp"); exists {
+		t.Error("temporary file is left behind")
+	}
+
+	j, err = journal.Open(fs, "data/journal.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := j.Get("a.txt")
+	if !ok {
+		t.Fatal("entry is not persisted")
+	}
+	if !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("expected %v, got %v", entry, loaded)
+	}
+	if _, ok := j.Get("b.txt"); ok {
+		t.Error("removed entry is persisted")
+	}
+}
+
+func TestMoveAndRemoveSubtree(t *testing.T) {
+	j := journal.New()
+	for _, path := range []string{"dir", "dir/a.txt", "dir/sub/b.txt", "dir2", "dirx.txt"} {
+		j.Put(path, journal.Entry{LocalID: uint64(len(path))})
+	}
+
+	j.Move("dir", "moved")
+	for _, path := range []string{"moved", "moved/a.txt", "moved/sub/b.txt", "dir2", "dirx.txt"} {
+		if _, ok := j.Get(path); !ok {
+			t.Errorf("%s is missing after move", path)
+		}
+	}
+	if _, ok := j.Get("dir/a.txt"); ok {
+		t.Error("old path is kept after move")
+	}
+	if entry, _ := j.Get("moved/sub/b.txt"); entry.LocalID != uint64(len("dir/sub/b.txt")) {
+		t.Errorf("entry is changed by move: %v", entry)
+	}
+
+	j.Remove("moved")
+	for _, path := range []string{"moved", "moved/a.txt", "moved/sub/b.txt"} {
+		if _, ok := j.Get(path); ok {
+			t.Errorf("%s is kept after remove", path)
+		}
+	}
+	if _, ok := j.Get("dir2"); !ok {
+		t.Error("sibling is removed")
+	}
+}
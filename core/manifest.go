@@ -0,0 +1,340 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+// ManifestFile is the manifest of a remote directory relative to the directory, it holds the state of all its entries
+const ManifestFile = metadata.Dir + "/manifest.json"
+
+// ManifestRetries is the number of times a change of a manifest is applied again after it has been
+// overwritten by another device
+const ManifestRetries = 5
+
+// ErrManifestConflict is returned if a manifest keeps being overwritten by other devices
+var ErrManifestConflict = errors.New("manifest is changed concurrently")
+
+// Refresher is implemented by state caches that keep remote state in memory. Refresh drops it,
+// so changes of other devices are read again.
+type Refresher interface {
+	Refresh()
+}
+
+// ManifestEntry is the state of a file or directory in the manifest of its parent directory
+type ManifestEntry struct {
+	Hash      digest.Digest `json:"hash"`
+	ModTime   *ModTime      `json:"mtime,omitempty"`
+	Tombstone *Tombstone    `json:"tombstone,omitempty"`
+}
+
+func (e ManifestEntry) isZero() bool {
+	return e.Hash.IsZero() && e.ModTime == nil && e.Tombstone == nil
+}
+
+// Manifest is the state of the entries of a remote directory by name
+type Manifest struct {
+	// Generation is increased by every write, Writer identifies the write. Together they tell if the
+	// manifest has been replaced by another device since it was written.
+	Generation int64                    `json:"generation"`
+	Writer     string                   `json:"writer"`
+	Entries    map[string]ManifestEntry `json:"entries"`
+}
+
+// ManifestCache is a RemoteStateCache keeping a manifest file in each remote directory, so the state of a
+// whole directory is read at once and shared by all devices. Manifests are cached until refreshed.
+type ManifestCache struct {
+	fs    afero.Fs
+	lock  sync.Mutex
+	cache map[string]*Manifest
+}
+
+var _ RemoteStateCache = (*ManifestCache)(nil)
+var _ Refresher = (*ManifestCache)(nil)
+var _ RecordLister = (*ManifestCache)(nil)
+
+func NewManifestCache(fs afero.Fs) *ManifestCache {
+	return &ManifestCache{fs: fs, cache: make(map[string]*Manifest)}
+}
+
+// Refresh implements Refresher.
+func (m *ManifestCache) Refresh() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	clear(m.cache)
+}
+
+// read loads the manifest of a remote directory, it is empty if there is none
+func (m *ManifestCache) read(dir string) (*Manifest, error) {
+	manifest, _, err := m.readVersion(dir)
+	return manifest, err
+}
+
+// readVersion loads the manifest of a remote directory with its version, see upload.Versioner
+func (m *ManifestCache) readVersion(dir string) (*Manifest, string, error) {
+	data, version, err := upload.ReadVersion(m.fs, path.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return &Manifest{Entries: make(map[string]ManifestEntry)}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	manifest := &Manifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("manifest of %s: %w", dir, err)
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = make(map[string]ManifestEntry)
+	}
+	return manifest, version, nil
+}
+
+// write replaces the manifest of a remote directory at once, so it is never seen partially written. It fails with
+// upload.ErrVersionChanged if the manifest has been replaced since base was read. The remote compares the version
+// if it supports versions, the result is true then. Otherwise the manifest is read again right before it is
+// replaced, a device replacing it in between is not noticed.
+func (m *ManifestCache) write(dir string, base *Manifest, version string, manifest *Manifest) (bool, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return false, err
+	}
+	name := path.Join(dir, ManifestFile)
+	err = m.fs.MkdirAll(path.Dir(name), 0777)
+	if err != nil {
+		return false, err
+	}
+	err = upload.WriteVersion(m.fs, name, data, version)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return true, err
+	}
+	current, err := m.read(dir)
+	if err != nil {
+		return false, err
+	}
+	if current.Generation != base.Generation || current.Writer != base.Writer {
+		return false, upload.ErrVersionChanged
+	}
+	target, err := upload.OpenTarget(m.fs, name, upload.Checkpoint{})
+	if err != nil {
+		return false, err
+	}
+	_, err = target.Write(data)
+	if err != nil {
+		target.Close()
+		upload.AbortTarget(m.fs, name, upload.Checkpoint{Session: target.Session()})
+		return false, err
+	}
+	return false, target.Commit()
+}
+
+// entry returns the state of a remote path from the cached manifest of its directory
+func (m *ManifestCache) entry(remotepath string) (ManifestEntry, error) {
+	dir, name := path.Split(remotepath)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	manifest, ok := m.cache[dir]
+	if !ok {
+		var err error
+		manifest, err = m.read(dir)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		m.cache[dir] = manifest
+	}
+	return manifest.Entries[name], nil
+}
+
+// update changes the entry of a remote path in the manifest of its directory. The change is applied again on top
+// of the manifest of another device if it has been replaced since it was read. Without versions of the remote the
+// manifest is read back after it is written as well, to notice devices replacing it right before.
+func (m *ManifestCache) update(remotepath string, change func(entry *ManifestEntry)) error {
+	dir, name := path.Split(remotepath)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	apply := func(manifest *Manifest) {
+		entry := manifest.Entries[name]
+		change(&entry)
+		if entry.isZero() {
+			delete(manifest.Entries, name)
+		} else {
+			manifest.Entries[name] = entry
+		}
+	}
+	for i := 0; i < ManifestRetries; i++ {
+		base, version, err := m.readVersion(dir)
+		if err != nil {
+			return err
+		}
+		manifest := &Manifest{Generation: base.Generation + 1, Writer: uuid.NewString(), Entries: maps.Clone(base.Entries)}
+		apply(manifest)
+		conditional, err := m.write(dir, base, version, manifest)
+		if errors.Is(err, upload.ErrVersionChanged) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if conditional {
+			m.cache[dir] = manifest
+			return nil
+		}
+		written, err := m.read(dir)
+		if err != nil {
+			return err
+		}
+		if written.Generation == manifest.Generation && written.Writer == manifest.Writer {
+			m.cache[dir] = written
+			return nil
+		}
+		// the other device may have merged the change already
+		merged := &Manifest{Entries: maps.Clone(written.Entries)}
+		apply(merged)
+		if reflect.DeepEqual(merged.Entries, written.Entries) {
+			m.cache[dir] = written
+			return nil
+		}
+	}
+	delete(m.cache, dir)
+	return fmt.Errorf("%s: %w", remotepath, ErrManifestConflict)
+}
+
+// GetHash implements RemoteStateCache.
+func (m *ManifestCache) GetHash(remotepath string) (digest.Digest, error) {
+	entry, err := m.entry(remotepath)
+	return entry.Hash, err
+}
+
+// UpdateHash implements RemoteStateCache.
+func (m *ManifestCache) UpdateHash(remotepath string, hash digest.Digest) error {
+	return m.update(remotepath, func(entry *ManifestEntry) {
+		entry.Hash = hash
+	})
+}
+
+// GetModTime implements RemoteStateCache.
+func (m *ManifestCache) GetModTime(remotepath string) (*ModTime, error) {
+	entry, err := m.entry(remotepath)
+	return entry.ModTime, err
+}
+
+// UpdateModTime implements RemoteStateCache.
+func (m *ManifestCache) UpdateModTime(remotepath string, modtime ModTime) error {
+	return m.update(remotepath, func(entry *ManifestEntry) {
+		entry.ModTime = &modtime
+	})
+}
+
+// GetTombstone implements RemoteStateCache.
+func (m *ManifestCache) GetTombstone(remotepath string) (*Tombstone, error) {
+	entry, err := m.entry(remotepath)
+	return entry.Tombstone, err
+}
+
+// PutTombstone implements RemoteStateCache.
+func (m *ManifestCache) PutTombstone(remotepath string, tombstone Tombstone) error {
+	return m.update(remotepath, func(entry *ManifestEntry) {
+		entry.Tombstone = &tombstone
+	})
+}
+
+// RemoveTombstone implements RemoteStateCache.
+func (m *ManifestCache) RemoveTombstone(remotepath string) error {
+	entry, err := m.entry(remotepath)
+	if err != nil || entry.Tombstone == nil {
+		return err
+	}
+	return m.update(remotepath, func(entry *ManifestEntry) {
+		entry.Tombstone = nil
+	})
+}
+
+// manifests reads the manifests of all remote directories
+func (m *ManifestCache) manifests(visit func(dir string, manifest *Manifest)) error {
+	return utils.Walk(m.fs, "", func(remotepath string, info fs.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || remotepath == "" {
+			return err
+		}
+		if !metadata.IsReserved(remotepath) {
+			return nil
+		}
+		if info.Name() == metadata.Dir {
+			dir := path.Dir(remotepath)
+			if dir == "." {
+				dir = ""
+			}
+			manifest, err := m.read(dir)
+			if err != nil {
+				return err
+			}
+			visit(dir, manifest)
+		}
+		// the trash and previous versions in the folder of PotatoDrive are not part of the tree
+		return fs.SkipDir
+	})
+}
+
+// ExpireTombstones implements RemoteStateCache.
+func (m *ManifestCache) ExpireTombstones(before time.Time) error {
+	var expired []string
+	err := m.manifests(func(dir string, manifest *Manifest) {
+		for name, entry := range manifest.Entries {
+			if entry.Tombstone != nil && entry.Tombstone.Deleted.Before(before) {
+				expired = append(expired, path.Join(dir, name))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, remotepath := range expired {
+		err = m.update(remotepath, func(entry *ManifestEntry) {
+			if entry.Tombstone != nil && entry.Tombstone.Deleted.Before(before) {
+				entry.Tombstone = nil
+			}
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Recorded implements RecordLister.
+func (m *ManifestCache) Recorded() ([]string, error) {
+	var recorded []string
+	err := m.manifests(func(dir string, manifest *Manifest) {
+		for name, entry := range manifest.Entries {
+			if !entry.Hash.IsZero() || entry.ModTime != nil {
+				recorded = append(recorded, path.Join(dir, name))
+			}
+		}
+	})
+	slices.Sort(recorded)
+	return recorded, err
+}
+
+// Forget implements RecordLister.
+func (m *ManifestCache) Forget(remotepath string) error {
+	return m.update(remotepath, func(entry *ManifestEntry) {
+		entry.Hash = digest.Digest{}
+		entry.ModTime = nil
+	})
+}
@@ -0,0 +1,229 @@
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+func hashOf(content string) digest.Digest {
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader(content))
+	return hash
+}
+
+func TestManifestIsSharedByDevices(t *testing.T) {
+	remote := afero.NewMemMapFs()
+	remote.MkdirAll("dir", 0777)
+	first := core.NewManifestCache(remote)
+	deleted := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	first.UpdateHash("dir/a.txt", hashOf("a"))
+	first.UpdateHash("dir/b.txt", hashOf("b"))
+	first.PutTombstone("dir/c.txt", core.Tombstone{Deleted: deleted, Device: "first"})
+	first.UpdateHash("root.txt", hashOf("root"))
+
+	infos, _ := afero.ReadDir(remote, "dir")
+	if len(infos) != 1 || infos[0].Name() != ".potatodrive" {
+		t.Errorf("expected only the manifest, got %v", infos)
+	}
+
+	second := core.NewManifestCache(remote)
+	for path, content := range map[string]string{"dir/a.txt": "a", "dir/b.txt": "b", "root.txt": "root"} {
+		if hash, err := second.GetHash(path); err != nil || !hash.Equal(hashOf(content)) {
+			t.Errorf("%s has hash %v (%v)", path, hash, err)
+		}
+	}
+	if tombstone, _ := second.GetTombstone("dir/c.txt"); tombstone == nil || tombstone.Device != "first" {
+		t.Errorf("tombstone is read as %v", tombstone)
+	}
+
+	// cached manifests are read again only when refreshed
+	first.UpdateHash("dir/a.txt", hashOf("changed"))
+	if hash, _ := second.GetHash("dir/a.txt"); !hash.Equal(hashOf("a")) {
+		t.Error("manifest is read again before refresh")
+	}
+	second.Refresh()
+	if hash, _ := second.GetHash("dir/a.txt"); !hash.Equal(hashOf("changed")) {
+		t.Error("change of the other device is not seen after refresh")
+	}
+
+	err := second.ExpireTombstones(deleted.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Refresh()
+	if tombstone, _ := first.GetTombstone("dir/c.txt"); tombstone != nil {
+		t.Error("tombstone is not expired")
+	}
+}
+
+// racingFs lets another device replace a manifest right after it has been written
+type racingFs struct {
+	afero.Fs
+	race func()
+}
+
+func (r *racingFs) Rename(oldname string, newname string) error {
+	err := r.Fs.Rename(oldname, newname)
+	if err == nil && strings.HasSuffix(newname, core.ManifestFile) && r.race != nil {
+		race := r.race
+		r.race = nil
+		race()
+	}
+	return err
+}
+
+func TestOverwrittenManifestIsMerged(t *testing.T) {
+	remote := &racingFs{Fs: afero.NewMemMapFs()}
+	otherfs := afero.NewMemMapFs()
+	core.NewManifestCache(otherfs).UpdateHash("b.txt", hashOf("b"))
+	stale, _ := afero.ReadFile(otherfs, core.ManifestFile)
+	remote.race = func() {
+		// the other device has read the manifest before this write
+		afero.WriteFile(remote.Fs, core.ManifestFile, stale, 0666)
+	}
+
+	cache := core.NewManifestCache(remote)
+	err := cache.UpdateHash("a.txt", hashOf("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := core.NewManifestCache(remote)
+	for path, content := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+		if hash, _ := check.GetHash(path); !hash.Equal(hashOf(content)) {
+			t.Errorf("%s is lost from the merged manifest", path)
+		}
+	}
+}
+
+func TestManifestConflictIsReported(t *testing.T) {
+	remote := &racingFs{Fs: afero.NewMemMapFs()}
+	var race func()
+	race = func() {
+		afero.WriteFile(remote.Fs, core.ManifestFile, []byte(`{"generation":100,"writer":"other","entries":{}}`), 0666)
+		remote.race = race
+	}
+	remote.race = race
+	err := core.NewManifestCache(remote).UpdateHash("a.txt", hashOf("a"))
+	if !errors.Is(err, core.ErrManifestConflict) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+}
+
+// readingFs lets another device replace a manifest right after it has been read
+type readingFs struct {
+	afero.Fs
+	race func()
+}
+
+func (r *readingFs) Open(name string) (afero.File, error) {
+	file, err := r.Fs.Open(name)
+	if err == nil && strings.HasSuffix(name, core.ManifestFile) && r.race != nil {
+		race := r.race
+		r.race = nil
+		race()
+	}
+	return file, err
+}
+
+func TestManifestReplacedBeforeWriteIsMerged(t *testing.T) {
+	remote := &readingFs{Fs: afero.NewMemMapFs()}
+	first := core.NewManifestCache(remote)
+	second := core.NewManifestCache(remote)
+	err := first.UpdateHash("a.txt", hashOf("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.race = func() {
+		// both devices have read the same manifest, the other one writes first
+		err := second.UpdateHash("b.txt", hashOf("b"))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = first.UpdateHash("c.txt", hashOf("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := core.NewManifestCache(remote.Fs)
+	for path, content := range map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"} {
+		if hash, _ := check.GetHash(path); !hash.Equal(hashOf(content)) {
+			t.Errorf("%s is lost from the manifest", path)
+		}
+	}
+}
+
+// versionedFs replaces files conditionally like S3 does, see upload.Versioner
+type versionedFs struct {
+	afero.Fs
+	lock     sync.Mutex
+	versions map[string]int
+}
+
+func (v *versionedFs) ReadVersion(name string) ([]byte, string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	data, err := afero.ReadFile(v.Fs, name)
+	return data, strconv.Itoa(v.versions[name]), err
+}
+
+func (v *versionedFs) WriteVersion(name string, data []byte, version string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	_, err := v.Fs.Stat(name)
+	if (version == "" && !os.IsNotExist(err)) || (version != "" && version != strconv.Itoa(v.versions[name])) {
+		return upload.ErrVersionChanged
+	}
+	v.versions[name]++
+	return afero.WriteFile(v.Fs, name, data, 0666)
+}
+
+func TestConcurrentManifestUpdatesAreKept(t *testing.T) {
+	remote := &versionedFs{Fs: afero.NewMemMapFs(), versions: make(map[string]int)}
+	var wg sync.WaitGroup
+	for _, device := range []string{"a", "b"} {
+		cache := core.NewManifestCache(remote)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("%s%d.txt", device, i)
+				// every update of the other device may be a conflict
+				err := retry(func() error { return cache.UpdateHash(name, hashOf(name)) })
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	check := core.NewManifestCache(remote)
+	for _, device := range []string{"a", "b"} {
+		for i := 0; i < 20; i++ {
+			name := fmt.Sprintf("%s%d.txt", device, i)
+			if hash, _ := check.GetHash(name); !hash.Equal(hashOf(name)) {
+				t.Errorf("%s is lost from the manifest", name)
+			}
+		}
+	}
+}
+
+func retry(f func() error) error {
+	for {
+		err := f()
+		if !errors.Is(err, core.ErrManifestConflict) {
+			return err
+		}
+	}
+}
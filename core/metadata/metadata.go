@@ -0,0 +1,87 @@
+// Package metadata defines the names PotatoDrive reserves on the remote for its own data. Files and folders of
+// these names are never synchronized, other hidden files are synchronized like any other.
+package metadata
+
+import (
+	"path"
+	"strings"
+)
+
+// Dir is the folder of PotatoDrive in the remote root, like the trash and previous versions, and in each
+// directory for its manifest
+const Dir = ".potatodrive"
+
+// IgnoreFile holds the ignore rules of a binding at the remote root
+const IgnoreFile = ".potatoignore"
+
+// Prefixes of the hidden files kept next to the remote file they belong to
+const (
+	// HashPrefix starts the names of files holding the content hash of a remote file
+	HashPrefix = ".hash_"
+	// LegacyHashPrefix starts the names of files holding raw md5 sums, written by earlier versions
+	LegacyHashPrefix = ".md5_"
+	// ModTimePrefix starts the names of files holding the source modification time of a remote file
+	ModTimePrefix = ".mtime_"
+	// TombstonePrefix starts the names of files recording the deletion of a remote path
+	TombstonePrefix = ".tomb_"
+	// TemporaryPrefix starts the names of files uploads are written to before they are renamed to the target
+	TemporaryPrefix = ".potato-tmp-"
+)
+
+// sidecarPrefixes start the names of files holding the state of the remote file they are named after
+var sidecarPrefixes = []string{HashPrefix, LegacyHashPrefix, ModTimePrefix}
+
+var prefixes = append(sidecarPrefixes[:len(sidecarPrefixes):len(sidecarPrefixes)], TombstonePrefix, TemporaryPrefix)
+
+// IsReserved returns true if the last element of the path is a name of PotatoDrive
+func IsReserved(remotepath string) bool {
+	name := path.Base(remotepath)
+	if name == Dir || name == IgnoreFile {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPrefix splits the name of a hidden file kept next to a remote file to its prefix and the name of that file,
+// false if the name does not start with such a prefix
+func SplitPrefix(name string) (string, string, bool) {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return prefix, name[len(prefix):], true
+		}
+	}
+	return "", name, false
+}
+
+// IsSidecar returns true if a prefix returned by SplitPrefix starts the name of a file holding the state of the
+// remote file it is named after. Tombstones and temporary files are not such files.
+func IsSidecar(prefix string) bool {
+	for _, sidecar := range sidecarPrefixes {
+		if prefix == sidecar {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHidden returns true if the last element of the path starts with a dot
+func IsHidden(remotepath string) bool {
+	name := path.Base(remotepath)
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// InReservedDir returns true if any parent directory of the path is reserved
+func InReservedDir(remotepath string) bool {
+	dirs := strings.Split(remotepath, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if IsReserved(dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/metadata"
+)
+
+func TestReservedNames(t *testing.T) {
+	for remotepath, expected := range map[string]bool{
+		".potatodrive":               true,
+		"docs/.potatodrive":          true,
+		".potatoignore":              true,
+		"docs/.hash_a.txt":           true,
+		"docs/.md5_a.txt":            true,
+		"docs/.mtime_a.txt":          true,
+		"docs/.tomb_a.txt":           true,
+		"docs/.potato-tmp-1234":      true,
+		"docs/.gitignore":            false,
+		".vscode":                    false,
+		"docs/hash_a.txt":            false,
+		".potatodrive-notes/a.txt":   false,
+		".vscode/.potatodrive/x.txt": false,
+	} {
+		if metadata.IsReserved(remotepath) != expected {
+			t.Errorf("%s is reserved: %t", remotepath, !expected)
+		}
+	}
+}
+
+func TestInReservedDir(t *testing.T) {
+	for remotepath, expected := range map[string]bool{
+		".potatodrive/trash/a.txt":        true,
+		"docs/.potatodrive/manifest.json": true,
+		".potatodrive":                    false,
+		".vscode/settings.json":           false,
+	} {
+		if metadata.InReservedDir(remotepath) != expected {
+			t.Errorf("%s is in a reserved directory: %t", remotepath, !expected)
+		}
+	}
+}
+
+func TestSplitPrefix(t *testing.T) {
+	prefix, target, ok := metadata.SplitPrefix(".hash_report.pdf")
+	if !ok || prefix != metadata.HashPrefix || target != "report.pdf" {
+		t.Errorf("unexpected split %q %q %t", prefix, target, ok)
+	}
+	for _, name := range []string{"report.pdf", ".hash_", ".gitignore"} {
+		if _, _, ok := metadata.SplitPrefix(name); ok {
+			t.Errorf("%s is split", name)
+		}
+	}
+}
+
+func TestSidecarPrefixes(t *testing.T) {
+	for _, name := range []string{".hash_a", ".md5_a", ".mtime_a"} {
+		prefix, _, _ := metadata.SplitPrefix(name)
+		if !metadata.IsSidecar(prefix) {
+			t.Errorf("%s is not a sidecar", name)
+		}
+	}
+	for _, name := range []string{".tomb_a", ".potato-tmp-a", "a"} {
+		prefix, _, _ := metadata.SplitPrefix(name)
+		if metadata.IsSidecar(prefix) {
+			t.Errorf("%s is a sidecar", name)
+		}
+	}
+}
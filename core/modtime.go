@@ -0,0 +1,69 @@
+package core
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ModTime is the modification time of the local file a remote file has been uploaded from. It is kept for
+// remotes that can not set modification times (like S3), those report the time of the upload instead.
+type ModTime struct {
+	Source time.Time `json:"source"`
+	// Remote and Size identify the uploaded version, the source time does not apply once the remote file is replaced
+	Remote time.Time `json:"remote"`
+	Size   int64     `json:"size"`
+}
+
+// Matches returns true if the remote file is still the uploaded version
+func (m *ModTime) Matches(info fs.FileInfo) bool {
+	return m.Size == info.Size() && m.Remote.Unix() == info.ModTime().Unix()
+}
+
+// PreserveModTime sets the modification time of an uploaded remote file to the one of its local source.
+// If the remote can not set it, the source time is remembered in the state cache instead.
+func PreserveModTime(remote afero.Fs, state RemoteStateCache, remotepath string, modtime time.Time) error {
+	err := remote.Chtimes(remotepath, modtime, modtime)
+	info, staterr := remote.Stat(remotepath)
+	if staterr != nil {
+		return staterr
+	}
+	// some remotes accept the call without changing anything
+	if err == nil && info.ModTime().Unix() == modtime.Unix() {
+		return nil
+	}
+	return state.UpdateModTime(remotepath, ModTime{Source: modtime.UTC(), Remote: info.ModTime().UTC(), Size: info.Size()})
+}
+
+// WithSourceModTime returns the remote file info with the modification time of its local source,
+// if it is remembered for the current version of the remote file
+func WithSourceModTime(state RemoteStateCache, remotepath string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info.IsDir() {
+		return info, nil
+	}
+	modtime, err := state.GetModTime(remotepath)
+	if err != nil || modtime == nil || !modtime.Matches(info) {
+		return info, err
+	}
+	return SourceTimeInfo(info, modtime.Source), nil
+}
+
+// SourceTimeInfo returns the remote file info with the given modification time of its local source
+func SourceTimeInfo(info fs.FileInfo, modtime time.Time) fs.FileInfo {
+	return &sourceTimeInfo{FileInfo: info, modtime: modtime}
+}
+
+type sourceTimeInfo struct {
+	fs.FileInfo
+	modtime time.Time
+}
+
+func (i *sourceTimeInfo) ModTime() time.Time {
+	return i.modtime
+}
+
+// ReportedModTime is the modification time reported by the remote
+func (i *sourceTimeInfo) ReportedModTime() time.Time {
+	return i.FileInfo.ModTime()
+}
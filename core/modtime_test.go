@@ -0,0 +1,72 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	s3 "github.com/fclairamb/afero-s3"
+	"github.com/spf13/afero"
+)
+
+// fakeS3 keeps the time of the upload as modification time, like S3 does
+type fakeS3 struct {
+	afero.Fs
+}
+
+func (fakeS3) Chtimes(string, time.Time, time.Time) error {
+	return s3.ErrNotSupported
+}
+
+var source = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestModTimeIsSetOnRemote(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	state := core.HashFilesRemotely(fs)
+	afero.WriteFile(fs, "a.txt", []byte("a"), 0666)
+
+	err := core.PreserveModTime(fs, state, "a.txt", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := fs.Stat("a.txt")
+	if !info.ModTime().Equal(source) {
+		t.Errorf("expected %v, got %v", source, info.ModTime())
+	}
+	if modtime, _ := state.GetModTime("a.txt"); modtime != nil {
+		t.Error("modification time is stored although the remote has it")
+	}
+}
+
+func TestModTimeIsStoredForS3(t *testing.T) {
+	fs := fakeS3{afero.NewMemMapFs()}
+	state := core.HashFilesRemotely(fs)
+	afero.WriteFile(fs, "a.txt", []byte("a"), 0666)
+
+	err := core.PreserveModTime(fs, state, "a.txt", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := fs.Stat("a.txt")
+	if info.ModTime().Equal(source) {
+		t.Fatal("fake S3 has changed the modification time")
+	}
+	withsource, err := core.WithSourceModTime(state, "a.txt", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !withsource.ModTime().Equal(source) {
+		t.Errorf("expected %v, got %v", source, withsource.ModTime())
+	}
+
+	// another version uploaded by a different client has its own time
+	afero.WriteFile(fs, "a.txt", []byte("bb"), 0666)
+	info, _ = fs.Stat("a.txt")
+	withsource, err = core.WithSourceModTime(state, "a.txt", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !withsource.ModTime().Equal(info.ModTime()) {
+		t.Errorf("source time is applied to a replaced file")
+	}
+}
@@ -0,0 +1,112 @@
+package names
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize returns the composed (NFC) form of a name, the form Windows creates names in. macOS creates decomposed
+// (NFD) names, so the same name may reach a remote in both forms.
+func Normalize(name string) string {
+	return norm.NFC.String(name)
+}
+
+// FoldCase returns the key names are compared by on a case-insensitive file system
+func FoldCase(name string) string {
+	return strings.ToUpper(name)
+}
+
+// CaseAlias returns the n-th disambiguated name of a file, e.g. "report (case 2).pdf"
+func CaseAlias(name string, n int) string {
+	return alias(name, "case", n)
+}
+
+// NormalizationAlias returns the n-th disambiguated name of a file differing from another one only by the
+// normalization of its characters, e.g. "café (normalization 2).txt"
+func NormalizationAlias(name string, n int) string {
+	return alias(name, "normalization", n)
+}
+
+func alias(name string, reason string, n int) string {
+	ext := path.Ext(name)
+	if ext == name {
+		// hidden files are handled as a name without extension
+		ext = ""
+	}
+	return fmt.Sprintf("%s (%s %d)%s", strings.TrimSuffix(name, ext), reason, n, ext)
+}
+
+// key identifies the names a local folder can not tell apart once they are normalized
+func key(name string) string {
+	return FoldCase(Normalize(name))
+}
+
+// CaseAliases decides the local names of the files of a remote directory. Names are presented in normalized form,
+// and names differing from another one only by case or normalization are disambiguated. Normalized names keep their
+// own name over the others, then the first one in byte order; the others are given the first free CaseAlias, or
+// NormalizationAlias if they differ from a name kept only by normalization. Remembered aliases of earlier listings
+// are kept as long as they do not collide with a remote name, so a file is not renamed locally when the file it
+// collided with is deleted. The result maps the remote names that are presented differently to their local name.
+func CaseAliases(remotenames []string, remembered map[string]string) map[string]string {
+	sorted := slices.Clone(remotenames)
+	slices.SortFunc(sorted, func(a, b string) int {
+		if an, bn := norm.NFC.IsNormalString(a), norm.NFC.IsNormalString(b); an != bn {
+			if an {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	existing := make(map[string]bool, len(sorted))
+	for _, name := range sorted {
+		existing[key(name)] = true
+	}
+	taken := make(map[string]bool, len(sorted))
+	kept := make(map[string]bool, len(sorted))
+	aliases := make(map[string]string)
+	for _, name := range sorted {
+		alias, ok := remembered[name]
+		if ok && !existing[key(alias)] && !taken[key(alias)] {
+			aliases[name] = alias
+			taken[key(alias)] = true
+		}
+	}
+	for _, name := range sorted {
+		if _, ok := aliases[name]; ok {
+			continue
+		}
+		normalized := Normalize(name)
+		if k := key(name); !taken[k] {
+			taken[k] = true
+			kept[normalized] = true
+			if normalized != name {
+				aliases[name] = normalized
+			}
+			continue
+		}
+		disambiguate := CaseAlias
+		if kept[normalized] {
+			disambiguate = NormalizationAlias
+		}
+		for n := 2; ; n++ {
+			alias := disambiguate(normalized, n)
+			if k := key(alias); !existing[k] && !taken[k] {
+				aliases[name] = alias
+				taken[k] = true
+				break
+			}
+		}
+	}
+	return aliases
+}
+
+// IsCollisionAlias returns true if a local name given by CaseAliases disambiguates the remote name from another one,
+// not only presents it in normalized form
+func IsCollisionAlias(remotename string, alias string) bool {
+	return alias != Normalize(remotename)
+}
@@ -0,0 +1,112 @@
+package names_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/names"
+)
+
+func TestCaseAlias(t *testing.T) {
+	for name, expected := range map[string]string{
+		"report.pdf":     "report (case 2).pdf",
+		"archive.tar.gz": "archive.tar (case 2).gz",
+		"README":         "README (case 2)",
+		".gitignore":     ".gitignore (case 2)",
+	} {
+		if alias := names.CaseAlias(name, 2); alias != expected {
+			t.Errorf("alias of %q is %q instead of %q", name, alias, expected)
+		}
+	}
+}
+
+func TestNamesWithoutCollisionsKeepTheirNames(t *testing.T) {
+	aliases := names.CaseAliases([]string{"a.txt", "b.txt", "Report.pdf"}, nil)
+	if len(aliases) != 0 {
+		t.Errorf("unexpected aliases %q", aliases)
+	}
+}
+
+func TestCollidingNamesAreDisambiguated(t *testing.T) {
+	aliases := names.CaseAliases([]string{"report.pdf", "REPORT.pdf", "Report.pdf", "other.txt"}, nil)
+	expected := map[string]string{
+		"Report.pdf": "Report (case 2).pdf",
+		"report.pdf": "report (case 3).pdf",
+	}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected %q, got %q", expected, aliases)
+	}
+}
+
+func TestAliasesDoNotCollideWithRemoteNames(t *testing.T) {
+	aliases := names.CaseAliases([]string{"a.txt", "A.txt", "a (case 2).txt"}, nil)
+	expected := map[string]string{"a.txt": "a (case 3).txt"}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected %q, got %q", expected, aliases)
+	}
+}
+
+func TestRememberedAliasesAreKept(t *testing.T) {
+	remembered := map[string]string{"report.pdf": "report (case 2).pdf"}
+	// the file it collided with has been deleted
+	aliases := names.CaseAliases([]string{"report.pdf"}, remembered)
+	if !reflect.DeepEqual(aliases, remembered) {
+		t.Errorf("expected %q, got %q", remembered, aliases)
+	}
+	// a new file taking the name of the alias
+	aliases = names.CaseAliases([]string{"report.pdf", "Report (Case 2).pdf"}, remembered)
+	if len(aliases) != 0 {
+		t.Errorf("alias colliding with a remote name is kept: %q", aliases)
+	}
+	// the remembered alias wins over the order of names
+	aliases = names.CaseAliases([]string{"report.pdf", "Report.pdf"}, remembered)
+	if !reflect.DeepEqual(aliases, remembered) {
+		t.Errorf("expected %q, got %q", remembered, aliases)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if normalized := names.Normalize("cafe\u0301.txt"); normalized != "caf\u00e9.txt" {
+		t.Errorf("decomposed name is normalized to %q", normalized)
+	}
+	if normalized := names.Normalize("caf\u00e9.txt"); normalized != "caf\u00e9.txt" {
+		t.Errorf("composed name is changed to %q", normalized)
+	}
+}
+
+func TestDecomposedNamesArePresentedNormalized(t *testing.T) {
+	aliases := names.CaseAliases([]string{"cafe\u0301.txt", "other.txt"}, nil)
+	expected := map[string]string{"cafe\u0301.txt": "caf\u00e9.txt"}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected %q, got %q", expected, aliases)
+	}
+	if names.IsCollisionAlias("cafe\u0301.txt", aliases["cafe\u0301.txt"]) {
+		t.Error("normalized name is reported as a collision")
+	}
+}
+
+func TestNormalizationDuplicatesAreDisambiguated(t *testing.T) {
+	// the composed name is kept even though the decomposed one is first in byte order
+	aliases := names.CaseAliases([]string{"cafe\u0301.txt", "caf\u00e9.txt"}, nil)
+	expected := map[string]string{"cafe\u0301.txt": "caf\u00e9 (normalization 2).txt"}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected %q, got %q", expected, aliases)
+	}
+	if !names.IsCollisionAlias("cafe\u0301.txt", aliases["cafe\u0301.txt"]) {
+		t.Error("duplicate is not reported as a collision")
+	}
+
+	// names differing by case and normalization
+	aliases = names.CaseAliases([]string{"Cafe\u0301.txt", "caf\u00e9.txt"}, nil)
+	expected = map[string]string{"Cafe\u0301.txt": "Caf\u00e9 (case 2).txt"}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected %q, got %q", expected, aliases)
+	}
+
+	// the alias is kept after the composed name is deleted
+	remembered := map[string]string{"cafe\u0301.txt": "caf\u00e9 (normalization 2).txt"}
+	aliases = names.CaseAliases([]string{"cafe\u0301.txt"}, remembered)
+	if !reflect.DeepEqual(aliases, remembered) {
+		t.Errorf("expected %q, got %q", remembered, aliases)
+	}
+}
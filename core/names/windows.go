@@ -0,0 +1,117 @@
+// Package names maps the names of remote files to names the local file system can represent
+package names
+
+import (
+	"errors"
+	"strings"
+)
+
+// Characters Windows can not represent are replaced by lookalikes of the Unicode private use area at
+// privateBase plus their code, like Cygwin does. privateBase itself escapes the characters of the range
+// that are present in the remote name, so the mapping can always be reversed.
+const (
+	privateBase = '\uF000'
+	privateLast = '\uF07F'
+)
+
+// ErrNotCanonical is returned for local names that are not the encoding of any remote name, like names
+// with characters of the private use range typed by the user
+var ErrNotCanonical = errors.New("name can not be mapped to the remote")
+
+// illegalCharacters can not appear in Windows file names, together with the control characters
+const illegalCharacters = `"*:<>?\|`
+
+// reservedNames are device names in Windows, with or without extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func isIllegal(r rune) bool {
+	return (r > 0 && r < ' ') || strings.ContainsRune(illegalCharacters, r)
+}
+
+func isPrivate(r rune) bool {
+	return r >= privateBase && r <= privateLast
+}
+
+// IsReservedWindowsName returns true if the name is a device name in Windows. The part before the first dot
+// decides, regardless of case.
+func IsReservedWindowsName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	return reservedNames[strings.ToUpper(stem)]
+}
+
+// EncodeWindows returns a name Windows can represent for a remote file name. Illegal characters, a trailing
+// dot or space and the first character of reserved names are replaced by lookalikes. Names valid in Windows
+// are returned unchanged unless they contain characters of the private use range the lookalikes are taken from.
+func EncodeWindows(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return name
+	}
+	reserved := IsReservedWindowsName(name)
+	if !reserved && !strings.ContainsFunc(name, func(r rune) bool { return isIllegal(r) || isPrivate(r) }) &&
+		!strings.HasSuffix(name, ".") && !strings.HasSuffix(name, " ") {
+		return name
+	}
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		switch {
+		case isPrivate(r):
+			b.WriteRune(privateBase)
+			b.WriteRune(r)
+		case isIllegal(r), i == 0 && reserved, i == len(runes)-1 && (r == '.' || r == ' '):
+			b.WriteRune(privateBase + r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// DecodeWindows returns the remote file name of a name encoded by EncodeWindows
+func DecodeWindows(name string) string {
+	if !strings.ContainsFunc(name, isPrivate) {
+		return name
+	}
+	runes := []rune(name)
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == privateBase && i+1 < len(runes):
+			i++
+			b.WriteRune(runes[i])
+		case isPrivate(r) && r != privateBase:
+			b.WriteRune(r - privateBase)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// IsCanonicalWindows returns true if the name is the encoding of the remote name it decodes to
+func IsCanonicalWindows(name string) bool {
+	return EncodeWindows(DecodeWindows(name)) == name
+}
+
+// EncodeWindowsPath encodes each element of a slash separated remote path
+func EncodeWindowsPath(remotepath string) string {
+	return mapPath(remotepath, EncodeWindows)
+}
+
+// DecodeWindowsPath decodes each element of a slash separated path encoded by EncodeWindowsPath
+func DecodeWindowsPath(localpath string) string {
+	return mapPath(localpath, DecodeWindows)
+}
+
+func mapPath(p string, mapping func(string) string) string {
+	elements := strings.Split(p, "/")
+	for i, element := range elements {
+		elements[i] = mapping(element)
+	}
+	return strings.Join(elements, "/")
+}
@@ -0,0 +1,150 @@
+package names_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/balazsgrill/potatodrive/core/names"
+)
+
+// validWindows checks the rules of Windows file names the encoding has to satisfy
+func validWindows(t *testing.T, name string) {
+	t.Helper()
+	if strings.ContainsFunc(name, func(r rune) bool { return r < ' ' || strings.ContainsRune(`"*:<>?\|/`, r) }) {
+		t.Errorf("%q contains illegal characters", name)
+	}
+	if name != "." && name != ".." && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) {
+		t.Errorf("%q ends with a dot or space", name)
+	}
+	if names.IsReservedWindowsName(name) {
+		t.Errorf("%q is reserved", name)
+	}
+}
+
+func roundtrip(t *testing.T, name string) string {
+	t.Helper()
+	encoded := names.EncodeWindows(name)
+	validWindows(t, encoded)
+	if decoded := names.DecodeWindows(encoded); decoded != name {
+		t.Errorf("%q is encoded to %q, decoded to %q", name, encoded, decoded)
+	}
+	if !names.IsCanonicalWindows(encoded) {
+		t.Errorf("encoding %q of %q is not canonical", encoded, name)
+	}
+	return encoded
+}
+
+func TestValidNamesAreUnchanged(t *testing.T) {
+	for _, name := range []string{"a.txt", ".gitignore", "Report 2024.pdf", "árvíztűrő tükörfúrógép", "日本語", "CONFIG.SYS", "console", "COM10", "LPT", "a..b", " a", "", ".", ".."} {
+		if encoded := roundtrip(t, name); encoded != name {
+			t.Errorf("%q is changed to %q", name, encoded)
+		}
+	}
+}
+
+func TestEveryASCIICharacter(t *testing.T) {
+	for r := rune(1); r < utf8.RuneSelf; r++ {
+		if r == '/' {
+			continue
+		}
+		c := string(r)
+		for _, name := range []string{c, "a" + c, c + "a", "a" + c + "b", c + c} {
+			encoded := roundtrip(t, name)
+			legal := r >= ' ' && !strings.ContainsRune(`"*:<>?\|`, r)
+			if legal && name != "." && name != ".." && !strings.HasSuffix(name, ".") && !strings.HasSuffix(name, " ") && encoded != name {
+				t.Errorf("%q is changed to %q", name, encoded)
+			}
+		}
+	}
+}
+
+func TestIllegalCharactersAreReplaced(t *testing.T) {
+	for name, expected := range map[string]string{
+		"a:b.txt":   "a\uF03Ab.txt",
+		"what?.log": "what\uF03F.log",
+		"a<b>c":     "a\uF03Cb\uF03Ec",
+		`"q"`:       "\uF022q\uF022",
+		"x*|y":      "x\uF02A\uF07Cy",
+		`back\n`:    "back\uF05Cn",
+		"tab\tname": "tab\uF009name",
+		"dot.":      "dot\uF02E",
+		"space ":    "space\uF020",
+		"dots..":    "dots.\uF02E",
+	} {
+		if encoded := roundtrip(t, name); encoded != expected {
+			t.Errorf("%q is encoded to %q instead of %q", name, encoded, expected)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	reserved := []string{"CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$"}
+	for i := '1'; i <= '9'; i++ {
+		reserved = append(reserved, "COM"+string(i), "LPT"+string(i))
+	}
+	for _, stem := range reserved {
+		for _, name := range []string{stem, strings.ToLower(stem), stem + ".txt", strings.ToLower(stem) + ".tar.gz"} {
+			if !names.IsReservedWindowsName(name) {
+				t.Errorf("%q is not reserved", name)
+			}
+			if encoded := roundtrip(t, name); encoded == name {
+				t.Errorf("%q is not changed", name)
+			}
+		}
+	}
+	if encoded := names.EncodeWindows("nul.txt"); encoded != "\uF06Eul.txt" {
+		t.Errorf("first character is not replaced: %q", encoded)
+	}
+}
+
+func TestPrivateCharactersAreEscaped(t *testing.T) {
+	for name, expected := range map[string]string{
+		"\uF03A":       "\uF000\uF03A",
+		"a\uF000b":     "a\uF000\uF000b",
+		"\uF043ON":     "\uF000\uF043ON",
+		"a\uF02E":      "a\uF000\uF02E",
+		"\uF000":       "\uF000\uF000",
+		"a:\uF03A":     "a\uF03A\uF000\uF03A",
+		"\uF080 above": "\uF080 above",
+	} {
+		if encoded := roundtrip(t, name); encoded != expected {
+			t.Errorf("%q is encoded to %q instead of %q", name, encoded, expected)
+		}
+	}
+}
+
+func TestNamesNotEncodedAreNotCanonical(t *testing.T) {
+	for _, name := range []string{"\uF041.txt", "a\uF000", "\uF000x", "CO\uF04E"} {
+		if names.IsCanonicalWindows(name) {
+			t.Errorf("%q is canonical", name)
+		}
+	}
+}
+
+func TestRandomNamesRoundtrip(t *testing.T) {
+	alphabet := []rune("aB.: ?*\\\x01\x1f\uF000\uF001\uF03A\uF07F\uF080éN")
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		runes := make([]rune, 1+random.Intn(6))
+		for j := range runes {
+			runes[j] = alphabet[random.Intn(len(alphabet))]
+		}
+		roundtrip(t, string(runes))
+	}
+}
+
+func TestPaths(t *testing.T) {
+	remotepath := "a:b/CON/c?.txt"
+	encoded := names.EncodeWindowsPath(remotepath)
+	if encoded != "a\uF03Ab/\uF043ON/c\uF03F.txt" {
+		t.Errorf("path is encoded to %q", encoded)
+	}
+	if decoded := names.DecodeWindowsPath(encoded); decoded != remotepath {
+		t.Errorf("path is decoded to %q", decoded)
+	}
+	if names.EncodeWindowsPath("") != "" {
+		t.Error("root is changed")
+	}
+}
@@ -1,3 +1,5 @@
+//go:build windows
+
 package core
 
 import "golang.org/x/sys/windows"
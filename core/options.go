@@ -0,0 +1,129 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/quota"
+	"github.com/balazsgrill/potatodrive/core/trash"
+	"github.com/balazsgrill/potatodrive/core/versions"
+)
+
+// ConflictPolicy decides what happens to a file that has been changed both locally and remotely
+type ConflictPolicy string
+
+const (
+	// ConflictKeepBoth keeps the remote version at the original path and saves the local one as a conflicted copy
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+	// ConflictPreferLocal overwrites the remote version with the local one
+	ConflictPreferLocal ConflictPolicy = "prefer-local"
+	// ConflictPreferRemote drops the local version
+	ConflictPreferRemote ConflictPolicy = "prefer-remote"
+)
+
+var ConflictPolicies = []ConflictPolicy{ConflictKeepBoth, ConflictPreferLocal, ConflictPreferRemote}
+
+// StateLocation decides where the RemoteStateCache of a binding is kept
+type StateLocation string
+
+const (
+	// StateRemote keeps hashes, modification times and tombstones in hidden files next to the remote files, shared by all devices
+	StateRemote StateLocation = "remote"
+	// StateLocal keeps them in a database in the data directory of the binding, seen only by this device
+	StateLocal StateLocation = "local"
+	// StateManifest keeps them in a manifest file in each remote directory, shared by all devices
+	StateManifest StateLocation = "manifest"
+)
+
+var StateLocations = []StateLocation{StateRemote, StateLocal, StateManifest}
+
+// DefaultTransfers is the number of files transferred in parallel if not configured otherwise
+const DefaultTransfers = 4
+
+// LargeTransferSize is the size above which a transfer can not take the last free worker, so small
+// files do not wait behind large ones
+const LargeTransferSize = 16 << 20
+
+// Options are the per-binding settings of a virtualization instance
+type Options struct {
+	// ID identifies the binding, its local state is kept under it in the state database
+	ID string
+	// DataDir is a local folder where the instance keeps its own persistent state, state is not persisted if empty
+	DataDir string
+	// StateDB is the path of the database the local states of all bindings are kept in, see StateDBPath
+	StateDB string
+	// ReadOnly is set by commands inspecting a binding, the state is opened without changing it
+	ReadOnly bool
+	// Conflict is the conflict resolution policy, defaults to ConflictKeepBoth
+	Conflict ConflictPolicy
+	// DeviceName identifies this client in conflicted copies and tombstones, defaults to the host name
+	DeviceName string
+	// TombstoneRetention is the period tombstones are kept for, tombstones are not collected if 0
+	TombstoneRetention time.Duration
+	// Ignore are gitignore-style patterns of files excluded from synchronization separated by new lines or ';'.
+	// Rules of the .potatoignore file at the remote root are applied after these.
+	Ignore string
+	// Cache is the budget of hydrated file contents kept locally, only supported by cfapi
+	Cache quota.Policy
+	// Transfers is the number of files uploaded or downloaded in parallel, defaults to DefaultTransfers
+	Transfers int
+	// Trash limits the remote files kept in the trash after being deleted, the zero value keeps everything
+	Trash trash.Policy
+	// Versions decides which previous contents of overwritten remote files are kept, versioning is disabled by the zero value
+	Versions versions.Policy
+	// Hash is the algorithm of the content hashes recorded for remote files, defaults to digest.Default.
+	// Hashes recorded by other algorithms are still compared by their own algorithm.
+	Hash digest.Algorithm
+	// State is where the state of remote files is kept, defaults to StateRemote
+	State StateLocation
+	// SkipHidden excludes all files and folders whose name starts with a dot, otherwise only the names
+	// reserved by PotatoDrive are excluded
+	SkipHidden bool
+}
+
+// Skips returns true if the path is never synchronized, because its name is reserved by PotatoDrive
+// or it is hidden and SkipHidden is set
+func (o Options) Skips(remotepath string) bool {
+	return metadata.IsReserved(remotepath) || (o.SkipHidden && metadata.IsHidden(remotepath))
+}
+
+// Device returns the configured device name or the host name
+func (o Options) Device() string {
+	if o.DeviceName != "" {
+		return o.DeviceName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown"
+	}
+	return hostname
+}
+
+// TransferWorkers returns the configured number of parallel transfers or the default
+func (o Options) TransferWorkers() int {
+	if o.Transfers > 0 {
+		return o.Transfers
+	}
+	return DefaultTransfers
+}
+
+// BindingDataDir returns the folder where persistent state of the given binding is stored
+func BindingDataDir(id string) (string, error) {
+	cachedir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cachedir, "PotatoDrive", id), nil
+}
+
+// StateDBPath returns the path of the database local states of all bindings are kept in
+func StateDBPath() (string, error) {
+	cachedir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cachedir, "PotatoDrive", StateDBFile), nil
+}
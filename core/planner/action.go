@@ -0,0 +1,107 @@
+package planner
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/balazsgrill/potatodrive/core/tasks"
+)
+
+// ActionType identifies a single step of a synchronization plan
+type ActionType int
+
+const (
+	// CreatePlaceholder creates a local placeholder for a file that only exists remotely
+	CreatePlaceholder ActionType = iota
+	// CreateLocalDir creates a local directory for a directory that only exists remotely
+	CreateLocalDir
+	// Dehydrate drops local content of a file that has been changed remotely
+	Dehydrate
+	// SetInSync marks a local file as synchronized with its remote counterpart
+	SetInSync
+	// Upload copies local content of a file to the remote
+	Upload
+	// DeleteLocal removes a local file that has been deleted remotely
+	DeleteLocal
+	// MkdirRemote creates a remote directory for a directory that only exists locally
+	MkdirRemote
+	// MoveRemote moves a remote file or directory to Target after it has been moved locally
+	MoveRemote
+	// DeleteRemote removes a remote file or directory that has been deleted locally
+	DeleteRemote
+	// ConflictCopy moves the local version of a file that has been changed on both sides to Target
+	ConflictCopy
+	// RecordBase remembers the current state of an unchanged file in the journal, it is not passed to executors
+	RecordBase
+)
+
+var actionTypeNames = []string{
+	CreatePlaceholder: "create-placeholder",
+	CreateLocalDir:    "create-local-dir",
+	Dehydrate:         "dehydrate",
+	SetInSync:         "set-in-sync",
+	Upload:            "upload",
+	DeleteLocal:       "delete-local",
+	MkdirRemote:       "mkdir-remote",
+	MoveRemote:        "move-remote",
+	DeleteRemote:      "delete-remote",
+	ConflictCopy:      "conflict-copy",
+	RecordBase:        "record-base",
+}
+
+func (t ActionType) String() string {
+	if t >= 0 && int(t) < len(actionTypeNames) {
+		return actionTypeNames[t]
+	}
+	return fmt.Sprintf("action(%d)", int(t))
+}
+
+// Action is a single step of a synchronization plan. Path is always the remote path of the file.
+type Action struct {
+	Type ActionType
+	Path string
+	// Target is the new remote path of the file for actions that move it
+	Target     string
+	RemoteInfo fs.FileInfo
+	LocalInfo  fs.FileInfo
+	Reason     string
+}
+
+func (a Action) String() string {
+	if a.Target != "" {
+		return fmt.Sprintf("%s '%s' -> '%s': %s", a.Type, a.Path, a.Target, a.Reason)
+	}
+	return fmt.Sprintf("%s '%s': %s", a.Type, a.Path, a.Reason)
+}
+
+// Executor carries out actions of a plan on a specific platform
+type Executor interface {
+	Execute(action Action) error
+}
+
+// TransferExecutor is an Executor that runs uploads in parallel
+type TransferExecutor interface {
+	Executor
+	// Transfers returns the pool upload actions are submitted to
+	Transfers() tasks.TaskConsumer
+	// UploadDropped is called for uploads dropped by the closed pool, the file has to be uploaded by the next
+	// synchronization
+	UploadDropped(action Action) error
+}
+
+// Plan is the ordered list of actions that bring local and remote side in sync
+type Plan []Action
+
+// Execute runs each action of the plan in order, stops at the first error
+func (p Plan) Execute(executor Executor) error {
+	for _, action := range p {
+		if action.Type == RecordBase {
+			continue
+		}
+		err := executor.Execute(action)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
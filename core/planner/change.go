@@ -0,0 +1,171 @@
+package planner
+
+import (
+	"io/fs"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/journal"
+)
+
+// Change classifies a file that exists on both sides by comparing it to the last synchronized version
+type Change int
+
+const (
+	Unchanged Change = iota
+	ChangedLocally
+	ChangedRemotely
+	ChangedOnBoth
+)
+
+var changeNames = []string{
+	Unchanged:       "unchanged",
+	ChangedLocally:  "changed locally",
+	ChangedRemotely: "changed remotely",
+	ChangedOnBoth:   "changed on both sides",
+}
+
+func (c Change) String() string {
+	return changeNames[c]
+}
+
+// classify compares local and remote state to the journal (base). Without a known base
+// it falls back to comparing checksums calculated by the remote, then modification times. A file changed on both
+// sides to the same content is unchanged.
+func (p *Planner) classify(remotepath string, remoteinfo fs.FileInfo, localfile LocalFile) (Change, error) {
+	base, ok := p.journal.Get(remotepath)
+	if !ok {
+		change := Unchanged
+		if isNewer(remoteinfo, localfile) {
+			change = ChangedRemotely
+		} else if !localfile.InSync && isNewer(localfile, remoteinfo) {
+			change = ChangedLocally
+		}
+		if change != Unchanged && remoteinfo.Size() == localfile.Size() {
+			same, err := p.sameContent(remotepath)
+			if err != nil || same {
+				return Unchanged, err
+			}
+		}
+		return change, nil
+	}
+
+	local, err := p.changedLocally(remotepath, base, localfile)
+	if err != nil {
+		return Unchanged, err
+	}
+	remote, err := p.changedRemotely(remotepath, base, remoteinfo)
+	if err != nil {
+		return Unchanged, err
+	}
+	switch {
+	case local && remote:
+		if remoteinfo.Size() == localfile.Size() {
+			// both sides may have been changed the same way, e.g. by copying the same file
+			same, err := p.sameVersion(remotepath, base)
+			if err != nil || same {
+				return Unchanged, err
+			}
+		}
+		return ChangedOnBoth, nil
+	case local:
+		return ChangedLocally, nil
+	case remote:
+		return ChangedRemotely, nil
+	}
+	return Unchanged, nil
+}
+
+// changedLocally returns false if the local file is in-sync or has the same size and time or content as the base
+func (p *Planner) changedLocally(remotepath string, base journal.Entry, localfile LocalFile) (bool, error) {
+	if localfile.InSync || base.Local.Matches(localfile) {
+		return false, nil
+	}
+	if base.Local.Size != localfile.Size() || base.Local.Hash.IsZero() {
+		return true, nil
+	}
+	// only the time is different, it might have been touched
+	hash, err := p.local.Hash(remotepath, base.Local.Hash.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	return !hash.Equal(base.Local.Hash), nil
+}
+
+// changedRemotely returns false if the remote file has the same size and time or content hash as the base
+func (p *Planner) changedRemotely(remotepath string, base journal.Entry, remoteinfo fs.FileInfo) (bool, error) {
+	if base.Remote.Matches(remoteinfo) {
+		return false, nil
+	}
+	if base.Remote.Size != remoteinfo.Size() || base.Remote.Hash.IsZero() {
+		return true, nil
+	}
+	// only the time is different, it might have been touched. A hash recorded by another algorithm since
+	// means the content has been written again, so it is treated like an unknown one.
+	hash, err := p.remoteHash(remotepath, base.Remote.Hash.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	return !hash.Equal(base.Remote.Hash), nil
+}
+
+// remoteHash returns the hash of the remote content by the algorithm, preferring the checksum calculated by the
+// remote over the recorded one. It is zero if neither is known by the algorithm.
+func (p *Planner) remoteHash(remotepath string, algorithm digest.Algorithm) (digest.Digest, error) {
+	known, err := p.state.GetHash(remotepath)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	native, err := digest.Native(p.remote, remotepath, algorithm)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if !native.IsZero() {
+		return native, nil
+	}
+	if known.Algorithm != algorithm {
+		return digest.Digest{}, nil
+	}
+	return known, nil
+}
+
+// sameVersion returns true if the local and remote content of a file changed on both sides are the same. The
+// recorded remote hash is only used if it has been updated since the base, otherwise the remote file is read.
+func (p *Planner) sameVersion(remotepath string, base journal.Entry) (bool, error) {
+	same, err := p.sameContent(remotepath)
+	if err != nil || same {
+		return same, err
+	}
+	remote, err := p.state.GetHash(remotepath)
+	if err != nil {
+		return false, err
+	}
+	if remote.IsZero() || remote.Equal(base.Remote.Hash) {
+		file, err := p.remote.Open(remotepath)
+		if err != nil {
+			return false, err
+		}
+		defer file.Close()
+		remote, err = digest.Sum(p.options.Hash, file)
+		if err != nil {
+			return false, err
+		}
+	}
+	local, err := p.local.Hash(remotepath, remote.Algorithm)
+	if err != nil || local.IsZero() {
+		return false, err
+	}
+	return local.Equal(remote), nil
+}
+
+// sameContent returns true if the remote calculates the checksum of the file and it matches the local content
+func (p *Planner) sameContent(remotepath string) (bool, error) {
+	native, err := digest.Native(p.remote, remotepath, p.options.Hash)
+	if err != nil || native.IsZero() {
+		return false, err
+	}
+	local, err := p.local.Hash(remotepath, native.Algorithm)
+	if err != nil || local.IsZero() {
+		return false, err
+	}
+	return native.Equal(local), nil
+}
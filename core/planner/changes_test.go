@@ -0,0 +1,160 @@
+package planner_test
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/spf13/afero"
+)
+
+// listingFs is a remote that reports the changes recorded in its log, opened directories are counted to detect walks
+type listingFs struct {
+	afero.Fs
+	*utils.ChangeLog
+	dirsOpened int
+}
+
+func (fs *listingFs) Open(name string) (afero.File, error) {
+	f, err := fs.Fs.Open(name)
+	if err == nil {
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			fs.dirsOpened++
+		}
+	}
+	return f, err
+}
+
+func TestRemoteChangesAreListed(t *testing.T) {
+	env := newTestEnv(t)
+	remote := &listingFs{Fs: env.remote, ChangeLog: utils.NewChangeLog(100)}
+	env.remote = remote
+	env.writeFile(remote.Fs, "dir/a.txt", "a", t0)
+	env.writeFile(remote.Fs, "b.txt", "b", t0)
+
+	p := env.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Execute(plan, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := p.Cursor()
+	if cursor == "" {
+		t.Fatal("no cursor after a full walk")
+	}
+
+	// changes are not seen until reported
+	env.writeFile(remote.Fs, "b.txt", "bb", t0.Add(time.Hour))
+	env.writeFile(remote.Fs, "new/c.txt", "c", t0)
+	remote.dirsOpened = 0
+	p = env.planner()
+	p.SetCursor(cursor)
+	env.expectFrom(p)
+	if remote.dirsOpened != 0 {
+		t.Errorf("remote tree has been walked")
+	}
+
+	remote.Record("b.txt")
+	remote.Record("new")
+	p = env.planner()
+	p.SetCursor(cursor)
+	changes := []step{
+		{planner.Dehydrate, "b.txt"},
+		{planner.SetInSync, "b.txt"},
+		{planner.CreateLocalDir, "new"},
+		{planner.CreatePlaceholder, "new/c.txt"},
+	}
+	env.expectFrom(p, changes...)
+	if remote.dirsOpened != 1 {
+		t.Errorf("expected only the new directory to be listed, %d directories opened", remote.dirsOpened)
+	}
+	if p.Cursor() == cursor {
+		t.Errorf("cursor is not advanced")
+	}
+
+	// changes since an expired cursor are found by walking the whole tree
+	remote.Reset()
+	p = env.planner()
+	p.SetCursor(cursor)
+	env.expectFrom(p, changes...)
+	if p.Cursor() == "" || p.Cursor() == cursor {
+		t.Errorf("cursor is not renewed")
+	}
+}
+
+func TestRemoteDeletionsAreListed(t *testing.T) {
+	env := newTestEnv(t)
+	remote := &listingFs{Fs: env.remote, ChangeLog: utils.NewChangeLog(100)}
+	env.remote = remote
+	env.writeFile(remote.Fs, "dir/a.txt", "a", t0)
+	env.writeFile(remote.Fs, "b.txt", "b", t0)
+
+	p := env.planner()
+	plan, _ := p.Plan()
+	err := p.Execute(plan, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.Fs.Remove("dir/a.txt")
+	remote.Record("dir/a.txt")
+	next := env.planner()
+	next.SetCursor(p.Cursor())
+	env.expectFrom(next,
+		step{planner.DeleteLocal, "dir/a.txt"},
+	)
+}
+
+// modTimeFs can not set modification times, the files holding them are counted when opened
+type modTimeFs struct {
+	timelessFs
+	modTimesRead int
+}
+
+func (fs *modTimeFs) Open(name string) (afero.File, error) {
+	if strings.HasPrefix(path.Base(name), metadata.ModTimePrefix) {
+		fs.modTimesRead++
+	}
+	return fs.timelessFs.Open(name)
+}
+
+func TestSourceModTimesOfUnchangedFilesAreNotRead(t *testing.T) {
+	env := newTestEnv(t)
+	timeless := &modTimeFs{timelessFs: timelessFs{env.remote}}
+	remote := &listingFs{Fs: timeless, ChangeLog: utils.NewChangeLog(100)}
+	env.remote = remote
+	env.state = core.HashFilesRemotely(remote)
+	env.writeFile(env.local.fs, "dir/a.txt", "a", t0)
+	env.writeFile(env.local.fs, "dir/b.txt", "b", t0)
+
+	p := env.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Execute(plan, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := p.Cursor()
+
+	timeless.modTimesRead = 0
+	env.expect()
+	if timeless.modTimesRead != 0 {
+		t.Errorf("%d modification times are read by a full walk", timeless.modTimesRead)
+	}
+	next := env.planner()
+	next.SetCursor(cursor)
+	env.expectFrom(next)
+	if timeless.modTimesRead != 0 {
+		t.Errorf("%d modification times are read without remote changes", timeless.modTimesRead)
+	}
+}
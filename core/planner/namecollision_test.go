@@ -0,0 +1,138 @@
+package planner_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/spf13/afero"
+)
+
+// aliasedEnv is a test environment with a remote presented by utils.CaseAliasFs, it returns the source of the remote
+func aliasedEnv(t *testing.T) (*testEnv, afero.Fs) {
+	env := newTestEnv(t)
+	source := env.remote
+	remote, err := utils.OpenCaseAliasFs(source, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.remote = remote
+	env.state = core.HashFilesRemotely(remote)
+	return env, source
+}
+
+func TestCaseCollisionsAreProjectedUnderAliases(t *testing.T) {
+	env, source := aliasedEnv(t)
+	env.writeFile(source, "dir/Report.pdf", "upper", t0)
+	env.writeFile(source, "dir/report.pdf", "lower", t0)
+
+	p := env.planner()
+	env.expectFrom(p,
+		step{planner.CreateLocalDir, "dir"},
+		step{planner.CreatePlaceholder, "dir/Report.pdf"},
+		step{planner.CreatePlaceholder, "dir/report (case 2).pdf"},
+	)
+	expected := []planner.NameCollision{{Path: "dir/report (case 2).pdf", RemotePath: "dir/report.pdf"}}
+	if !reflect.DeepEqual(p.NameCollisions(), expected) {
+		t.Errorf("expected %v, got %v", expected, p.NameCollisions())
+	}
+	env.synced()
+	if data, _ := afero.ReadFile(env.local.fs, "dir/report (case 2).pdf"); string(data) != "lower" {
+		t.Errorf("alias is projected with %q", data)
+	}
+
+	// local changes of the alias are uploaded to the remote file it stands for
+	env.writeFile(env.local.fs, "dir/report (case 2).pdf", "edited", t0.Add(time.Hour))
+	env.local.insync["dir/report (case 2).pdf"] = false
+	p = env.planner()
+	env.expectFrom(p,
+		step{planner.SetInSync, "dir/report (case 2).pdf"},
+		step{planner.Upload, "dir/report (case 2).pdf"},
+	)
+	if len(p.NameCollisions()) != 0 {
+		t.Errorf("collision is reported again: %v", p.NameCollisions())
+	}
+	env.synced()
+	if data, _ := afero.ReadFile(source, "dir/report.pdf"); string(data) != "edited" {
+		t.Errorf("remote file is %q", data)
+	}
+	if data, _ := afero.ReadFile(source, "dir/Report.pdf"); string(data) != "upper" {
+		t.Errorf("colliding remote file is %q", data)
+	}
+	if exists, _ := afero.Exists(source, "dir/.hash_report.pdf"); !exists {
+		t.Error("hash of the uploaded file is not kept next to it")
+	}
+}
+
+func TestDecomposedRemoteNamesMatchComposedLocalNames(t *testing.T) {
+	env, source := aliasedEnv(t)
+	// uploaded from macOS, created again on Windows
+	env.writeFile(source, "dir/cafe\u0301.txt", "a", t0)
+	env.writeFile(env.local.fs, "dir/caf\u00e9.txt", "a", t0)
+
+	p := env.planner()
+	env.expectFrom(p,
+		step{planner.RecordBase, "dir"},
+		step{planner.SetInSync, "dir/caf\u00e9.txt"},
+		step{planner.RecordBase, "dir/caf\u00e9.txt"},
+	)
+	if len(p.NameCollisions()) != 0 {
+		t.Errorf("normalized name is reported: %v", p.NameCollisions())
+	}
+	env.synced()
+
+	env.writeFile(env.local.fs, "dir/caf\u00e9.txt", "edited", t0.Add(time.Hour))
+	env.local.insync["dir/caf\u00e9.txt"] = false
+	env.synced()
+	if data, _ := afero.ReadFile(source, "dir/cafe\u0301.txt"); string(data) != "edited" {
+		t.Errorf("remote file is %q", data)
+	}
+	if exists, _ := afero.Exists(source, "dir/caf\u00e9.txt"); exists {
+		t.Error("local changes are uploaded as a duplicate")
+	}
+}
+
+func TestNormalizationDuplicatesAreReported(t *testing.T) {
+	env, source := aliasedEnv(t)
+	env.writeFile(source, "cafe\u0301.txt", "decomposed", t0)
+	env.writeFile(source, "caf\u00e9.txt", "composed", t0)
+
+	p := env.planner()
+	env.expectFrom(p,
+		step{planner.CreatePlaceholder, "caf\u00e9 (normalization 2).txt"},
+		step{planner.CreatePlaceholder, "caf\u00e9.txt"},
+	)
+	expected := []planner.NameCollision{{Path: "caf\u00e9 (normalization 2).txt", RemotePath: "cafe\u0301.txt"}}
+	if !reflect.DeepEqual(p.NameCollisions(), expected) {
+		t.Errorf("expected %v, got %v", expected, p.NameCollisions())
+	}
+	env.synced()
+	if data, _ := afero.ReadFile(env.local.fs, "caf\u00e9.txt"); string(data) != "composed" {
+		t.Errorf("composed name is projected with %q", data)
+	}
+}
+
+func TestDenormalizedLocalNamesAreNotUploaded(t *testing.T) {
+	env, source := aliasedEnv(t)
+	env.writeFile(source, "cafe\u0301.txt", "a", t0)
+	env.writeFile(source, "re\u0301sume\u0301.txt", "b", t0)
+	// projected by an earlier version, unchanged
+	env.writeFile(env.local.fs, "cafe\u0301.txt", "a", t0)
+	env.local.insync["cafe\u0301.txt"] = true
+	// created locally
+	env.writeFile(env.local.fs, "re\u0301sume\u0301.txt", "local", t0.Add(time.Hour))
+
+	p := env.planner()
+	env.expectFrom(p,
+		step{planner.CreatePlaceholder, "caf\u00e9.txt"},
+		step{planner.CreatePlaceholder, "r\u00e9sum\u00e9.txt"},
+		step{planner.DeleteLocal, "cafe\u0301.txt"},
+	)
+	expected := []planner.NameCollision{{Path: "re\u0301sume\u0301.txt", RemotePath: "r\u00e9sum\u00e9.txt"}}
+	if !reflect.DeepEqual(p.NameCollisions(), expected) {
+		t.Errorf("expected %v, got %v", expected, p.NameCollisions())
+	}
+}
@@ -0,0 +1,625 @@
+package planner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/ignore"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/balazsgrill/potatodrive/core/names"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+// LocalFile is the state of a local file or directory as seen by the planner
+type LocalFile struct {
+	fs.FileInfo
+	// InSync is true if the local file is known to be identical to the remote one
+	InSync bool
+}
+
+// Local is the platform-specific view of the local side. All paths are remote paths.
+type Local interface {
+	// Stat returns the state of a local file, or an error satisfying os.IsNotExist
+	Stat(path string) (LocalFile, error)
+	// Walk visits all local files and directories including the root ("")
+	Walk(walkFn func(path string, file LocalFile, err error) error) error
+	// Hash calculates the hash of the local content by the given algorithm, returns a zero digest if the content
+	// is not available locally
+	Hash(path string, algorithm digest.Algorithm) (digest.Digest, error)
+	// ID returns the identity of a local file or directory that is kept when it is renamed, 0 if not supported
+	ID(path string) (uint64, error)
+}
+
+// Planner compares the local and remote side and computes the actions needed to synchronize them
+type Planner struct {
+	remote  afero.Fs
+	local   Local
+	state   core.RemoteStateCache
+	journal journal.Journal
+	options core.Options
+	ignore  *ignore.Matcher
+	// cursor is the position of the remote change feed, snapshot is the remote tree built from the changes since it
+	cursor   string
+	snapshot *snapshot
+	// abandoned are temporary files left on the remote by crashed uploads
+	abandoned []string
+	// collisions are the files of the last Plan that can not be synchronized under their own name
+	collisions []NameCollision
+}
+
+// NameCollision is a file that can not be synchronized under its own name, because it differs from another one only
+// by case or Unicode normalization. Such remote files are projected under a disambiguated name, such local files are
+// not uploaded.
+type NameCollision struct {
+	// Path is the local path of the file, the disambiguated one of remote files
+	Path string
+	// RemotePath is the path of the remote file, or the remote file a local file differs from only by normalization
+	RemotePath string
+}
+
+func New(remote afero.Fs, local Local, state core.RemoteStateCache, journal journal.Journal, options core.Options) *Planner {
+	return &Planner{
+		remote:  remote,
+		local:   local,
+		state:   state,
+		journal: journal,
+		options: options,
+	}
+}
+
+// Ignore returns the rules of excluded files loaded by the last Plan
+func (p *Planner) Ignore() *ignore.Matcher {
+	return p.ignore
+}
+
+// NameCollisions returns the remote files the last Plan projects locally under a disambiguated name, if the remote
+// implements core.CaseAliaser, and the local files it does not upload because their names are not normalized and
+// the normalized name exists remotely
+func (p *Planner) NameCollisions() []NameCollision {
+	return p.collisions
+}
+
+// SetCursor makes Plan list only the remote changes since the cursor instead of walking the whole remote tree,
+// if the remote implements core.ChangeLister
+func (p *Planner) SetCursor(cursor string) {
+	p.cursor = cursor
+}
+
+// Cursor returns the position of the remote change feed the last Plan is based on, it is empty if the remote
+// can not list changes. It should be passed to the planner of the next synchronization if the plan has been executed.
+func (p *Planner) Cursor() string {
+	return p.cursor
+}
+
+// walkRemote visits the whole remote tree, or the tree recorded in the journal updated with the changes since the cursor
+func (p *Planner) walkRemote(walkFn filepath.WalkFunc) error {
+	p.snapshot = nil
+	lister, ok := p.remote.(core.ChangeLister)
+	if !ok {
+		p.cursor = ""
+		return utils.Walk(p.remote, "", walkFn)
+	}
+	if p.cursor != "" {
+		changes, cursor, err := lister.Changes(p.cursor)
+		if err == nil {
+			p.snapshot, err = p.listChanges(changes)
+			if err != nil {
+				return err
+			}
+			p.cursor = cursor
+			return p.snapshot.Walk(walkFn)
+		}
+		// changes since the cursor are not known, the whole tree is compared
+	}
+	// the cursor is taken before the walk, so changes made during the walk are listed again
+	_, cursor, err := lister.Changes("")
+	if err != nil {
+		cursor = ""
+	}
+	p.cursor = cursor
+	return utils.Walk(p.remote, "", walkFn)
+}
+
+// statRemote returns the remote state of a path as seen by the current plan
+func (p *Planner) statRemote(remotepath string) (fs.FileInfo, error) {
+	if p.snapshot != nil {
+		return p.snapshot.Stat(remotepath)
+	}
+	return p.remote.Stat(remotepath)
+}
+
+// withSourceModTime applies the modification time of the local source to a remote file, for remotes that report the
+// time of the upload instead. It is taken from the journal while the remote reports the synchronized version, the
+// state is only read for changed files.
+func (p *Planner) withSourceModTime(remotepath string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info.IsDir() {
+		return info, nil
+	}
+	if base, ok := p.journal.Get(remotepath); ok && !base.Dir && base.Remote.MatchesReported(info) {
+		if base.Remote.Reported == 0 {
+			return info, nil
+		}
+		return core.SourceTimeInfo(info, time.Unix(base.Remote.ModTime, 0)), nil
+	}
+	return core.WithSourceModTime(p.state, remotepath, info)
+}
+
+// skipped returns true if the path is not synchronized, because it is skipped by the options or excluded by the ignore rules
+func (p *Planner) skipped(remotepath string, isDir bool) bool {
+	return p.options.Skips(remotepath) || p.ignore.Match(remotepath, isDir)
+}
+
+func isNewer(a fs.FileInfo, b fs.FileInfo) bool {
+	return a.ModTime().UTC().Unix() > b.ModTime().UTC().Unix()
+}
+
+type builder struct {
+	plan    Plan
+	uploads Plan
+	// deletions are the paths deleted on either side in walk order. Those are applied after all other changes
+	// unless a path is moved, or a parent directory is restored because of changes below it.
+	deletions []Action
+	pending   map[string]Action
+}
+
+func (b *builder) add(action Action) {
+	if action.Type == Upload {
+		b.uploads = append(b.uploads, action)
+	} else {
+		b.plan = append(b.plan, action)
+	}
+}
+
+func (b *builder) deleted(action Action) {
+	b.deletions = append(b.deletions, action)
+	b.pending[action.Path] = action
+}
+
+// restore cancels the deletion of the parent directories of a path that is kept
+func (b *builder) restore(remotepath string) {
+	segments := strings.Split(remotepath, "/")
+	for i := 1; i < len(segments); i++ {
+		dir := strings.Join(segments[:i], "/")
+		if action, ok := b.pending[dir]; ok {
+			delete(b.pending, dir)
+			if action.Type == DeleteRemote {
+				b.add(action.as(CreateLocalDir, "directory contains remote changes"))
+			} else {
+				b.add(action.as(MkdirRemote, "directory contains local changes"))
+			}
+		}
+	}
+}
+
+// moved cancels the deletion of a path and everything below it
+func (b *builder) moved(remotepath string) {
+	for p := range b.pending {
+		if p == remotepath || strings.HasPrefix(p, remotepath+"/") {
+			delete(b.pending, p)
+		}
+	}
+}
+
+func (b *builder) hasPendingParent(remotepath string) bool {
+	for dir := path.Dir(remotepath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := b.pending[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// result returns the actions in order: remote changes and moves first, then deletions, uploads are the last
+func (b *builder) result() Plan {
+	plan := b.plan
+	for _, action := range b.deletions {
+		if _, ok := b.pending[action.Path]; ok && !b.hasPendingParent(action.Path) {
+			plan = append(plan, action)
+		}
+	}
+	return append(plan, b.uploads...)
+}
+
+// Plan walks both sides and returns the actions needed to synchronize them.
+// Remote changes are applied first, uploads are always the last actions of the plan.
+func (p *Planner) Plan() (Plan, error) {
+	b := &builder{
+		pending: make(map[string]Action),
+	}
+	visited := make(map[string]bool)
+	p.abandoned = nil
+	p.collisions = nil
+	if refresher, ok := p.state.(core.Refresher); ok {
+		// changes of other devices since the last plan
+		refresher.Refresh()
+	}
+
+	var err error
+	p.ignore, err = ignore.Load(p.remote, p.options.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("load ignore rules: %w", err)
+	}
+
+	err = p.walkRemote(func(remotepath string, remoteinfo fs.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("plan remote %s: %w", remotepath, err)
+		}
+		if remotepath == "" {
+			return nil
+		}
+		if upload.IsTemporary(remotepath) && !remoteinfo.IsDir() && time.Since(remoteinfo.ModTime()) > upload.TemporaryRetention {
+			p.abandoned = append(p.abandoned, remotepath)
+		}
+		if p.skipped(remotepath, remoteinfo.IsDir()) {
+			if remoteinfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// remotes that can not set modification times report the time of the upload
+		remoteinfo, err = p.withSourceModTime(remotepath, remoteinfo)
+		if err != nil {
+			return fmt.Errorf("plan remote %s: %w", remotepath, err)
+		}
+
+		localfile, err := p.local.Stat(remotepath)
+		if os.IsNotExist(err) {
+			return p.planRemoteOnly(b, remotepath, remoteinfo)
+		}
+		if err != nil {
+			return fmt.Errorf("plan local %s: %w", remotepath, err)
+		}
+
+		if !remoteinfo.IsDir() && !localfile.IsDir() {
+			visited[remotepath] = true
+			return p.planFile(b, remotepath, remoteinfo, localfile)
+		}
+		if remoteinfo.IsDir() && localfile.IsDir() {
+			if base, ok := p.journal.Get(remotepath); !ok || !base.Dir {
+				b.add(Action{Type: RecordBase, Path: remotepath, RemoteInfo: remoteinfo, LocalInfo: localfile, Reason: "directory exists on both sides"})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.local.Walk(func(remotepath string, localfile LocalFile, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if remotepath == "" || visited[remotepath] {
+			return nil
+		}
+		if p.skipped(remotepath, localfile.IsDir()) {
+			if localfile.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if normalized := names.Normalize(remotepath); normalized != remotepath {
+			if _, err := p.statRemote(normalized); err == nil {
+				return p.planDenormalized(b, remotepath, localfile, normalized)
+			}
+		}
+
+		if localfile.IsDir() {
+			if remoteinfo, err := p.statRemote(remotepath); err == nil && remoteinfo.IsDir() {
+				return nil
+			}
+			moved, err := p.planMove(b, remotepath, localfile)
+			if err != nil {
+				return err
+			}
+			if moved {
+				// content of the moved directory is compared on the next run
+				return filepath.SkipDir
+			}
+			tombstone, err := p.tombstone(remotepath)
+			if err != nil {
+				return err
+			}
+			action := Action{Path: remotepath, LocalInfo: localfile}
+			if tombstone != nil {
+				// contents decide whether the directory is kept
+				b.deleted(action.as(DeleteLocal, fmt.Sprintf("deleted remotely by %s", tombstone.Device)))
+				return nil
+			}
+			b.restore(remotepath)
+			b.add(action.as(MkdirRemote, "directory exists only locally"))
+			return nil
+		}
+		return p.planLocalFile(b, remotepath, localfile)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.result(), nil
+}
+
+// planDenormalized decides about a local file whose name is not normalized, while the normalized name exists remotely.
+// The remote file is projected under the normalized name, the local one is a copy projected by earlier versions
+// that is removed if unchanged, or a duplicate created locally that is not uploaded.
+func (p *Planner) planDenormalized(b *builder, remotepath string, localfile LocalFile, normalized string) error {
+	if !localfile.IsDir() && localfile.InSync {
+		b.deleted(Action{Path: remotepath, LocalInfo: localfile}.as(DeleteLocal, "projected under its normalized name"))
+		return nil
+	}
+	p.collisions = append(p.collisions, NameCollision{Path: remotepath, RemotePath: normalized})
+	if localfile.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// planRemoteOnly decides about a remote file or directory that does not exist locally
+func (p *Planner) planRemoteOnly(b *builder, remotepath string, remoteinfo fs.FileInfo) error {
+	action := Action{Path: remotepath, RemoteInfo: remoteinfo}
+	deleted, err := p.isDeletedLocally(remotepath, remoteinfo)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		b.deleted(action.as(DeleteRemote, "deleted locally and unchanged remotely"))
+		return nil
+	}
+	b.restore(remotepath)
+	if aliaser, ok := p.remote.(core.CaseAliaser); ok {
+		if original, ok := aliaser.CaseAlias(remotepath); ok {
+			p.collisions = append(p.collisions, NameCollision{Path: remotepath, RemotePath: original})
+		}
+	}
+	if remoteinfo.IsDir() {
+		b.add(action.as(CreateLocalDir, "directory exists only remotely"))
+	} else {
+		b.add(action.as(CreatePlaceholder, "file exists only remotely"))
+	}
+	return nil
+}
+
+// isDeletedLocally returns true if the path has been synchronized before and it was not changed remotely since
+func (p *Planner) isDeletedLocally(remotepath string, remoteinfo fs.FileInfo) (bool, error) {
+	base, ok := p.journal.Get(remotepath)
+	if !ok || base.Dir != remoteinfo.IsDir() {
+		return false, nil
+	}
+	if base.Dir {
+		return true, nil
+	}
+	changed, err := p.changedRemotely(remotepath, base, remoteinfo)
+	return !changed, err
+}
+
+// planMove looks for a locally deleted path that has been moved to the given local-only path
+func (p *Planner) planMove(b *builder, remotepath string, localfile LocalFile) (bool, error) {
+	if len(b.pending) == 0 {
+		return false, nil
+	}
+	source, found, err := p.findMoveSource(b, remotepath, localfile)
+	if err != nil || !found {
+		return false, err
+	}
+	b.moved(source.Path)
+	move := source.as(MoveRemote, "moved locally")
+	move.Target = remotepath
+	move.LocalInfo = localfile
+	b.add(move)
+
+	if localfile.IsDir() {
+		return true, nil
+	}
+	base, _ := p.journal.Get(source.Path)
+	changed, err := p.changedLocally(remotepath, base, localfile)
+	if err != nil {
+		return false, err
+	}
+	action := Action{Path: remotepath, LocalInfo: localfile}
+	if changed {
+		b.add(action.as(SetInSync, "local changes are processed"))
+		b.add(action.as(Upload, "moved file has been changed locally"))
+	} else if !localfile.InSync {
+		b.add(action.as(SetInSync, "moved file is unchanged"))
+	}
+	return true, nil
+}
+
+// findMoveSource matches a locally deleted path by the identity of the local file, or by its content
+func (p *Planner) findMoveSource(b *builder, remotepath string, localfile LocalFile) (Action, bool, error) {
+	id, err := p.local.ID(remotepath)
+	if err != nil {
+		return Action{}, false, err
+	}
+	// the local content is hashed by the algorithm of each candidate, which may differ if the configuration has changed
+	hashes := make(map[digest.Algorithm]digest.Digest)
+	for _, action := range b.deletions {
+		if _, ok := b.pending[action.Path]; !ok || action.Type != DeleteRemote {
+			continue
+		}
+		base, _ := p.journal.Get(action.Path)
+		if base.Dir != localfile.IsDir() {
+			continue
+		}
+		if id != 0 && base.LocalID == id {
+			return action, true, nil
+		}
+		if base.Dir || base.Local.Size != localfile.Size() || base.Local.Hash.IsZero() {
+			continue
+		}
+		hash, ok := hashes[base.Local.Hash.Algorithm]
+		if !ok {
+			hash, err = p.local.Hash(remotepath, base.Local.Hash.Algorithm)
+			if err != nil || hash.IsZero() {
+				return Action{}, false, err
+			}
+			hashes[base.Local.Hash.Algorithm] = hash
+		}
+		if hash.Equal(base.Local.Hash) {
+			return action, true, nil
+		}
+	}
+	return Action{}, false, nil
+}
+
+// planFile decides about a file that exists on both sides
+func (p *Planner) planFile(b *builder, remotepath string, remoteinfo fs.FileInfo, localfile LocalFile) error {
+	change, err := p.classify(remotepath, remoteinfo, localfile)
+	if err != nil {
+		return err
+	}
+	action := Action{Path: remotepath, RemoteInfo: remoteinfo, LocalInfo: localfile}
+	switch change {
+	case Unchanged:
+		if !localfile.InSync {
+			b.add(action.as(SetInSync, "unchanged"))
+		}
+		if base, ok := p.journal.Get(remotepath); !ok || !base.Local.Matches(localfile) || !base.Remote.Matches(remoteinfo) {
+			b.add(action.as(RecordBase, "unchanged"))
+		}
+	case ChangedRemotely:
+		b.add(action.as(Dehydrate, change.String()))
+		// placeholder metadata is updated from the remote
+		b.add(action.as(SetInSync, "placeholder was dehydrated"))
+	case ChangedLocally:
+		b.add(action.as(SetInSync, "local changes are processed"))
+		b.add(action.as(Upload, change.String()))
+	case ChangedOnBoth:
+		p.planConflict(b, action)
+	}
+	return nil
+}
+
+// planConflict resolves a file that has been changed on both sides according to the conflict policy
+func (p *Planner) planConflict(b *builder, action Action) {
+	switch p.options.Conflict {
+	case core.ConflictPreferLocal:
+		b.add(action.as(SetInSync, "local changes are processed"))
+		b.add(action.as(Upload, ChangedOnBoth.String()+", local version is preferred"))
+	case core.ConflictPreferRemote:
+		b.add(action.as(Dehydrate, ChangedOnBoth.String()+", remote version is preferred"))
+		b.add(action.as(SetInSync, "placeholder was dehydrated"))
+	default:
+		// keep both: the remote version stays at the original path, the local one is uploaded as a new file
+		copypath := ConflictName(action.Path, p.options.Device(), action.LocalInfo.ModTime())
+		conflict := action.as(ConflictCopy, ChangedOnBoth.String())
+		conflict.Target = copypath
+		b.add(conflict)
+		b.add(Action{Type: CreatePlaceholder, Path: action.Path, RemoteInfo: action.RemoteInfo, Reason: "local version was moved to conflicted copy"})
+		copyaction := Action{Path: copypath, LocalInfo: action.LocalInfo}
+		b.add(copyaction.as(SetInSync, "local changes are processed"))
+		b.add(copyaction.as(Upload, "conflicted copy"))
+	}
+}
+
+// ConflictName returns the path of the conflicted copy of a file, e.g. "dir/name (conflict host 2024-01-02 150405).ext"
+func ConflictName(p string, device string, t time.Time) string {
+	dir, name := path.Split(p)
+	ext := path.Ext(name)
+	if ext == name {
+		// hidden files are handled as a name without extension
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	return fmt.Sprintf("%s%s (conflict %s %s)%s", dir, stem, device, t.UTC().Format("2006-01-02 150405"), ext)
+}
+
+// planLocalFile decides about a file that does not exist remotely
+func (p *Planner) planLocalFile(b *builder, remotepath string, localfile LocalFile) error {
+	action := Action{Path: remotepath, LocalInfo: localfile}
+	if _, ok := p.journal.Get(remotepath); !ok {
+		moved, err := p.planMove(b, remotepath, localfile)
+		if err != nil || moved {
+			return err
+		}
+	}
+	deleted, err := p.isDeletedRemotely(remotepath, localfile)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		b.deleted(action.as(DeleteLocal, "deleted remotely and unchanged locally"))
+		return nil
+	}
+	b.restore(remotepath)
+	if !localfile.InSync {
+		b.add(action.as(SetInSync, "local changes are processed"))
+		b.add(action.as(Upload, "file exists only locally"))
+	}
+	return nil
+}
+
+func (a Action) as(actiontype ActionType, reason string) Action {
+	a.Type = actiontype
+	a.Reason = reason
+	return a
+}
+
+// isDeletedRemotely check whether file was deleted remotely
+// if it was, it compares local state with the last synchronized state. Returns true only if the file has been deleted remotely and was not changed locally
+func (p *Planner) isDeletedRemotely(remotepath string, localfile LocalFile) (bool, error) {
+	_, err := p.statRemote(remotepath)
+	if !os.IsNotExist(err) {
+		return false, nil
+	}
+	tombstone, err := p.tombstone(remotepath)
+	if err != nil {
+		return false, err
+	}
+	if tombstone != nil {
+		// local copy is kept only if it has been edited after the deletion
+		return !localfile.ModTime().After(tombstone.Deleted), nil
+	}
+	if base, ok := p.journal.Get(remotepath); ok {
+		changed, err := p.changedLocally(remotepath, base, localfile)
+		return !changed, err
+	}
+	// chek if remote hash is known
+	hash, err := p.state.GetHash(remotepath)
+	if err != nil {
+		return false, err
+	}
+	if hash.IsZero() {
+		return false, nil
+	}
+	// on remote file existed before, upload only if hash is different
+	localhash, err := p.local.Hash(remotepath, hash.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	if localhash.IsZero() {
+		// local file is not available, no need to upload
+		return false, nil
+	}
+	// hash is the same this file has been removed remotely
+	return hash.Equal(localhash), nil
+}
+
+// tombstone returns the tombstone of the path or its closest deleted parent directory, nil if there is none or it has expired
+func (p *Planner) tombstone(remotepath string) (*core.Tombstone, error) {
+	for current := remotepath; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		tombstone, err := p.state.GetTombstone(current)
+		if err != nil {
+			return nil, err
+		}
+		if tombstone != nil && !tombstone.Expired(p.options.TombstoneRetention, time.Now()) {
+			return tombstone, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,917 @@
+package planner_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/ignore"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+// memLocal is a local view backed by an afero file system
+type memLocal struct {
+	fs     afero.Fs
+	insync map[string]bool
+	ids    map[string]uint64
+}
+
+func (l *memLocal) Stat(path string) (planner.LocalFile, error) {
+	info, err := l.fs.Stat(path)
+	if err != nil {
+		return planner.LocalFile{}, err
+	}
+	return planner.LocalFile{FileInfo: info, InSync: l.insync[path]}, nil
+}
+
+func (l *memLocal) Walk(walkFn func(path string, file planner.LocalFile, err error) error) error {
+	return utils.Walk(l.fs, "", func(path string, info fs.FileInfo, err error) error {
+		return walkFn(path, planner.LocalFile{FileInfo: info, InSync: l.insync[path]}, err)
+	})
+}
+
+func (l *memLocal) Hash(path string, algorithm digest.Algorithm) (digest.Digest, error) {
+	file, err := l.fs.Open(path)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	defer file.Close()
+	return digest.Sum(algorithm, file)
+}
+
+func hashOf(algorithm digest.Algorithm, content string) digest.Digest {
+	hash, _ := digest.Sum(algorithm, strings.NewReader(content))
+	return hash
+}
+
+func (l *memLocal) ID(path string) (uint64, error) {
+	return l.ids[path], nil
+}
+
+// move renames a local file or directory, its identity and state are kept
+func (l *memLocal) move(oldpath string, newpath string) error {
+	for p, id := range l.ids {
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			delete(l.ids, p)
+			l.ids[newpath+strings.TrimPrefix(p, oldpath)] = id
+		}
+	}
+	for p, insync := range l.insync {
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			delete(l.insync, p)
+			l.insync[newpath+strings.TrimPrefix(p, oldpath)] = insync
+		}
+	}
+	return l.fs.Rename(oldpath, newpath)
+}
+
+type testEnv struct {
+	t       *testing.T
+	remote  afero.Fs
+	local   *memLocal
+	state   core.RemoteStateCache
+	journal journal.Journal
+	options core.Options
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	return newTestEnvOn(t, afero.NewMemMapFs(), afero.NewMemMapFs())
+}
+
+// newRenameTestEnv is a test environment for renaming directories,
+// MemMapFs does not rename directory contents, temporary folders are used instead
+func newRenameTestEnv(t *testing.T) *testEnv {
+	return newTestEnvOn(t, afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()), afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()))
+}
+
+func newTestEnvOn(t *testing.T, remote afero.Fs, local afero.Fs) *testEnv {
+	return &testEnv{
+		t:      t,
+		remote: remote,
+		local: &memLocal{
+			fs:     local,
+			insync: make(map[string]bool),
+			ids:    make(map[string]uint64),
+		},
+		state:   core.HashFilesRemotely(remote),
+		journal: journal.New(),
+		options: core.Options{DeviceName: "test"},
+	}
+}
+
+func (e *testEnv) writeFile(fs afero.Fs, path string, content string, modtime time.Time) {
+	err := fs.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	err = afero.WriteFile(fs, path, []byte(content), 0666)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	err = fs.Chtimes(path, modtime, modtime)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+type step struct {
+	Type planner.ActionType
+	Path string
+}
+
+func (e *testEnv) planner() *planner.Planner {
+	return planner.New(e.remote, e.local, e.state, e.journal, e.options)
+}
+
+func (e *testEnv) expect(expected ...step) planner.Plan {
+	return e.expectFrom(e.planner(), expected...)
+}
+
+func (e *testEnv) expectFrom(p *planner.Planner, expected ...step) planner.Plan {
+	e.t.Helper()
+	plan, err := p.Plan()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	actual := make([]step, len(plan))
+	for i, action := range plan {
+		actual[i] = step{Type: action.Type, Path: action.Path}
+	}
+	if len(expected) == 0 {
+		expected = []step{}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		e.t.Errorf("expected %v, got %v", expected, plan)
+	}
+	return plan
+}
+
+var t0 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRemoteOnlyEntries(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote.MkdirAll("dir", 0777)
+	env.writeFile(env.remote, "dir/a.txt", "a", t0)
+	env.writeFile(env.remote, "b.txt", "b", t0)
+
+	env.expect(
+		step{planner.CreatePlaceholder, "b.txt"},
+		step{planner.CreateLocalDir, "dir"},
+		step{planner.CreatePlaceholder, "dir/a.txt"},
+	)
+}
+
+func TestReservedEntriesAreSkipped(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote.MkdirAll(metadata.Dir, 0777)
+	env.writeFile(env.remote, metadata.Dir+"/manifest.json", "{}", t0)
+	env.writeFile(env.remote, ".hash_b.txt", "b", t0)
+	env.writeFile(env.remote, metadata.TemporaryPrefix+"b.txt", "b", time.Now())
+	env.writeFile(env.remote, metadata.IgnoreFile, "", t0)
+	env.writeFile(env.local.fs, ".mtime_c.txt", "c", t0)
+
+	env.expect()
+}
+
+func TestHiddenEntriesAreSynchronized(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote.MkdirAll(".vscode", 0777)
+	env.writeFile(env.remote, ".vscode/settings.json", "a", t0)
+	env.writeFile(env.local.fs, ".gitignore", "c", t0)
+
+	env.expect(
+		step{planner.CreateLocalDir, ".vscode"},
+		step{planner.CreatePlaceholder, ".vscode/settings.json"},
+		step{planner.SetInSync, ".gitignore"},
+		step{planner.Upload, ".gitignore"},
+	)
+}
+
+func TestHiddenEntriesAreSkippedIfConfigured(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.SkipHidden = true
+	env.remote.MkdirAll(".vscode", 0777)
+	env.writeFile(env.remote, ".vscode/settings.json", "a", t0)
+	env.writeFile(env.local.fs, ".gitignore", "c", t0)
+
+	env.expect()
+}
+
+func TestIgnoredEntriesAreSkipped(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.Ignore = "*.tmp;node_modules/"
+	env.writeFile(env.remote, ignore.FileName, "/archive\n!keep.tmp\n", t0)
+	env.writeFile(env.remote, "archive/a.txt", "a", t0)
+	env.writeFile(env.remote, "b.tmp", "b", t0)
+	env.writeFile(env.remote, "keep.tmp", "k", t0)
+	env.writeFile(env.local.fs, "src/node_modules/c.js", "c", t0)
+	env.writeFile(env.local.fs, "src/d.tmp", "d", t0)
+
+	env.expect(
+		step{planner.CreatePlaceholder, "keep.tmp"},
+		step{planner.MkdirRemote, "src"},
+	)
+}
+
+func TestRemoteIsNewer(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "new", t0.Add(time.Hour))
+	env.writeFile(env.local.fs, "a.txt", "old", t0)
+	env.local.insync["a.txt"] = true
+
+	env.expect(
+		step{planner.Dehydrate, "a.txt"},
+		step{planner.SetInSync, "a.txt"},
+	)
+}
+
+func TestInSyncFilesAreLeftAlone(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.local.insync["a.txt"] = true
+
+	env.expect(
+		step{planner.RecordBase, "a.txt"},
+	)
+}
+
+func TestLocalOnlyEntries(t *testing.T) {
+	env := newTestEnv(t)
+	env.local.fs.MkdirAll("dir", 0777)
+	env.writeFile(env.local.fs, "dir/a.txt", "a", t0)
+
+	env.expect(
+		step{planner.MkdirRemote, "dir"},
+		step{planner.SetInSync, "dir/a.txt"},
+		step{planner.Upload, "dir/a.txt"},
+	)
+}
+
+func TestLocalIsNewer(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "old", t0)
+	env.writeFile(env.remote, "b.txt", "b", t0)
+	env.writeFile(env.local.fs, "a.txt", "new", t0.Add(time.Hour))
+	env.writeFile(env.local.fs, "b.txt", "b", t0)
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.SetInSync, "b.txt"},
+		step{planner.RecordBase, "b.txt"},
+		step{planner.Upload, "a.txt"},
+	)
+}
+
+func TestDeletedRemotely(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.local.insync["a.txt"] = true
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+
+	env.expect(
+		step{planner.DeleteLocal, "a.txt"},
+	)
+}
+
+func TestDeletedRemotelyButChangedLocally(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "a.txt", "changed", t0)
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.Upload, "a.txt"},
+	)
+}
+
+type recorder []planner.Action
+
+func (r *recorder) Execute(action planner.Action) error {
+	*r = append(*r, action)
+	return nil
+}
+
+func TestPlanExecution(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.writeFile(env.local.fs, "b.txt", "b", t0)
+
+	plan := env.expect(
+		step{planner.CreatePlaceholder, "a.txt"},
+		step{planner.SetInSync, "b.txt"},
+		step{planner.Upload, "b.txt"},
+	)
+
+	var executed recorder
+	err := plan.Execute(&executed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(planner.Plan(executed), plan) {
+		t.Errorf("expected %v, got %v", plan, executed)
+	}
+}
+
+// synced executes the plan and checks that nothing is left to do afterwards
+func (e *testEnv) synced() {
+	p := e.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	err = p.Execute(plan, e)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.expect()
+}
+
+// Execute implements planner.Executor by mirroring the action on the in-memory file systems
+func (e *testEnv) Execute(action planner.Action) error {
+	switch action.Type {
+	case planner.CreatePlaceholder, planner.Dehydrate:
+		data, err := afero.ReadFile(e.remote, action.Path)
+		if err != nil {
+			return err
+		}
+		e.writeFile(e.local.fs, action.Path, string(data), action.RemoteInfo.ModTime())
+		e.local.insync[action.Path] = true
+	case planner.CreateLocalDir:
+		return e.local.fs.MkdirAll(action.Path, 0777)
+	case planner.SetInSync:
+		e.local.insync[action.Path] = true
+	case planner.Upload:
+		data, err := afero.ReadFile(e.local.fs, action.Path)
+		if err != nil {
+			return err
+		}
+		err = e.remote.MkdirAll(filepath.Dir(action.Path), 0777)
+		if err != nil {
+			return err
+		}
+		err = afero.WriteFile(e.remote, action.Path, data, 0666)
+		if err != nil {
+			return err
+		}
+		hash, err := digest.Sum(e.options.Hash, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		err = e.state.UpdateHash(action.Path, hash)
+		if err != nil {
+			return err
+		}
+		return core.PreserveModTime(e.remote, e.state, action.Path, action.LocalInfo.ModTime())
+	case planner.DeleteLocal:
+		return e.local.fs.RemoveAll(action.Path)
+	case planner.ConflictCopy:
+		return e.local.fs.Rename(action.Path, action.Target)
+	case planner.MkdirRemote:
+		return e.remote.MkdirAll(action.Path, 0777)
+	case planner.MoveRemote:
+		return utils.Move(e.remote, action.Path, action.Target)
+	case planner.DeleteRemote:
+		return e.remote.RemoveAll(action.Path)
+	}
+	return nil
+}
+
+func TestExecutionRecordsJournal(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.writeFile(env.local.fs, "b.txt", "b", t0)
+	env.synced()
+
+	for _, path := range []string{"a.txt", "b.txt"} {
+		entry, ok := env.journal.Get(path)
+		if !ok {
+			t.Fatalf("%s is not recorded", path)
+		}
+		if entry.Local.Size != 1 || entry.Remote.ModTime != t0.Unix() {
+			t.Errorf("%s recorded as %v", path, entry)
+		}
+	}
+}
+
+func TestTouchedFileIsUnchanged(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.synced()
+
+	// content is the same, only the local time has changed
+	env.writeFile(env.local.fs, "a.txt", "a", t0.Add(time.Hour))
+	env.local.insync["a.txt"] = false
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.RecordBase, "a.txt"},
+	)
+}
+
+func TestTouchedFileIsComparedByRecordedAlgorithm(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.Hash = digest.SHA256
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.synced()
+
+	// the configured algorithm has changed since the last synchronization
+	env.options.Hash = digest.XXHash
+	env.writeFile(env.local.fs, "a.txt", "a", t0.Add(time.Hour))
+	env.local.insync["a.txt"] = false
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.RecordBase, "a.txt"},
+	)
+}
+
+func TestDeletedRemotelyByHashOfOtherAlgorithm(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.local.insync["a.txt"] = true
+	// recorded by a device configured for another algorithm
+	env.state.UpdateHash("a.txt", hashOf(digest.SHA256, "a"))
+
+	env.expect(
+		step{planner.DeleteLocal, "a.txt"},
+	)
+}
+
+func TestRemoteHashOfOtherAlgorithmIsChanged(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+	env.synced()
+
+	// another device has uploaded it again with another algorithm, hashes can not tell the content is the same
+	env.writeFile(env.remote, "a.txt", "a", t0.Add(time.Hour))
+	env.state.UpdateHash("a.txt", hashOf(digest.SHA256, "a"))
+
+	env.expect(
+		step{planner.Dehydrate, "a.txt"},
+		step{planner.SetInSync, "a.txt"},
+	)
+}
+
+// checksummingFs calculates checksums of the remote like a backend supporting them
+type checksummingFs struct {
+	afero.Fs
+}
+
+func (c checksummingFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	data, err := afero.ReadFile(c.Fs, name)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	return hashOf(algorithm, string(data)), nil
+}
+
+// checksumCountingFs counts the checksums calculated by the remote
+type checksumCountingFs struct {
+	checksummingFs
+	calls int
+}
+
+func (c *checksumCountingFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	c.calls++
+	return c.checksummingFs.Checksum(name, algorithm)
+}
+
+func TestRecordingDoesNotCalculateChecksums(t *testing.T) {
+	env := newTestEnv(t)
+	remote := &checksumCountingFs{checksummingFs: checksummingFs{env.remote}}
+	env.remote = remote
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.writeFile(env.remote, "dir/b.txt", "b", t0)
+	env.writeFile(env.local.fs, "c.txt", "c", t0)
+	env.synced()
+	if remote.calls != 0 {
+		t.Errorf("%d checksums are calculated by the remote", remote.calls)
+	}
+}
+
+func TestTouchedRemoteIsComparedByNativeChecksum(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote = checksummingFs{env.remote}
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	// recorded by the device uploading the file
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+	env.synced()
+	if entry, _ := env.journal.Get("a.txt"); !entry.Remote.Hash.Equal(hashOf(digest.MD5, "a")) {
+		t.Errorf("recorded hash is not kept: %v", entry.Remote.Hash)
+	}
+
+	env.writeFile(env.remote, "a.txt", "a", t0.Add(time.Hour))
+	env.expect(
+		step{planner.RecordBase, "a.txt"},
+	)
+	env.writeFile(env.remote, "a.txt", "b", t0.Add(2*time.Hour))
+	env.expect(
+		step{planner.Dehydrate, "a.txt"},
+		step{planner.SetInSync, "a.txt"},
+	)
+}
+
+func TestSameContentWithoutBaseIsUnchanged(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote = checksummingFs{env.remote}
+	env.writeFile(env.remote, "a.txt", "a", t0.Add(time.Hour))
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.RecordBase, "a.txt"},
+	)
+}
+
+func TestOlderRemoteChangeIsDownloaded(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.synced()
+
+	// remote content is replaced by a file with an older timestamp
+	env.writeFile(env.remote, "a.txt", "old", t0.Add(-time.Hour))
+
+	env.expect(
+		step{planner.Dehydrate, "a.txt"},
+		step{planner.SetInSync, "a.txt"},
+	)
+}
+
+// conflicting creates a file that is changed on both sides after it has been synchronized
+func (e *testEnv) conflicting(path string) {
+	e.writeFile(e.remote, path, "base", t0)
+	e.synced()
+
+	e.writeFile(e.remote, path, "remote", t0.Add(2*time.Hour))
+	e.writeFile(e.local.fs, path, "local", t0.Add(time.Hour))
+	e.local.insync[path] = false
+}
+
+func TestConflictKeepsBoth(t *testing.T) {
+	env := newTestEnv(t)
+	env.conflicting("dir/a.txt")
+	copypath := "dir/a (conflict test 2024-01-01 130000).txt"
+
+	env.expect(
+		step{planner.ConflictCopy, "dir/a.txt"},
+		step{planner.CreatePlaceholder, "dir/a.txt"},
+		step{planner.SetInSync, copypath},
+		step{planner.Upload, copypath},
+	)
+	env.synced()
+
+	for path, content := range map[string]string{"dir/a.txt": "remote", copypath: "local"} {
+		for _, fs := range []afero.Fs{env.remote, env.local.fs} {
+			data, err := afero.ReadFile(fs, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Errorf("expected '%s' in %s, got '%s'", content, path, string(data))
+			}
+		}
+	}
+}
+
+func TestSameChangeOnBothSidesIsNotAConflict(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "base", t0)
+	env.synced()
+
+	env.writeFile(env.remote, "a.txt", "same", t0.Add(time.Hour))
+	env.writeFile(env.local.fs, "a.txt", "same", t0.Add(time.Hour))
+	env.local.insync["a.txt"] = false
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.RecordBase, "a.txt"},
+	)
+	env.synced()
+}
+
+func TestDifferentChangesOfSameSizeAreAConflict(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "base", t0)
+	env.synced()
+
+	env.writeFile(env.remote, "a.txt", "left", t0.Add(time.Hour))
+	env.writeFile(env.local.fs, "a.txt", "righ", t0.Add(time.Hour))
+	env.local.insync["a.txt"] = false
+
+	env.expect(
+		step{planner.ConflictCopy, "a.txt"},
+		step{planner.CreatePlaceholder, "a.txt"},
+		step{planner.SetInSync, "a (conflict test 2024-01-01 130000).txt"},
+		step{planner.Upload, "a (conflict test 2024-01-01 130000).txt"},
+	)
+}
+
+func TestConflictPreferLocal(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.Conflict = core.ConflictPreferLocal
+	env.conflicting("a.txt")
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.Upload, "a.txt"},
+	)
+}
+
+func TestConflictPreferRemote(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.Conflict = core.ConflictPreferRemote
+	env.conflicting("a.txt")
+
+	env.expect(
+		step{planner.Dehydrate, "a.txt"},
+		step{planner.SetInSync, "a.txt"},
+	)
+}
+
+func TestConflictName(t *testing.T) {
+	for path, expected := range map[string]string{
+		"a.txt":          "a (conflict host 2024-01-01 120000).txt",
+		"dir/a.tar.gz":   "dir/a.tar (conflict host 2024-01-01 120000).gz",
+		"dir/README":     "dir/README (conflict host 2024-01-01 120000)",
+		"dir/.gitignore": "dir/.gitignore (conflict host 2024-01-01 120000)",
+	} {
+		actual := planner.ConflictName(path, "host", t0)
+		if actual != expected {
+			t.Errorf("expected '%s', got '%s'", expected, actual)
+		}
+	}
+}
+
+func TestDeletedRemotelyAfterSync(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.writeFile(env.remote, "b.txt", "b", t0)
+	env.synced()
+
+	env.remote.Remove("a.txt")
+	env.remote.Remove("b.txt")
+	env.writeFile(env.local.fs, "b.txt", "changed", t0.Add(time.Hour))
+	env.local.insync["b.txt"] = false
+
+	env.expect(
+		step{planner.SetInSync, "b.txt"},
+		step{planner.DeleteLocal, "a.txt"},
+		step{planner.Upload, "b.txt"},
+	)
+}
+
+func TestDeletedLocally(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "dir/a.txt", "a", t0)
+	env.writeFile(env.remote, "dir/b.txt", "b", t0)
+	env.writeFile(env.remote, "c.txt", "c", t0)
+	env.synced()
+
+	env.local.fs.RemoveAll("dir")
+	env.local.fs.Remove("c.txt")
+
+	env.expect(
+		step{planner.DeleteRemote, "c.txt"},
+		step{planner.DeleteRemote, "dir"},
+	)
+	env.synced()
+	if exists, _ := afero.Exists(env.remote, "dir"); exists {
+		t.Error("remote directory is not deleted")
+	}
+}
+
+func TestDeletedLocallyButChangedRemotely(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "dir/a.txt", "a", t0)
+	env.writeFile(env.remote, "dir/b.txt", "b", t0)
+	env.synced()
+
+	env.local.fs.RemoveAll("dir")
+	env.writeFile(env.remote, "dir/b.txt", "changed", t0.Add(time.Hour))
+
+	env.expect(
+		step{planner.CreateLocalDir, "dir"},
+		step{planner.CreatePlaceholder, "dir/b.txt"},
+		step{planner.DeleteRemote, "dir/a.txt"},
+	)
+}
+
+func TestMovedFileIsRenamedRemotely(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+	env.synced()
+
+	// identity of the file is not known, it is matched by content hash
+	env.local.fs.MkdirAll("dir", 0777)
+	env.local.move("a.txt", "dir/b.txt")
+
+	env.expect(
+		step{planner.MkdirRemote, "dir"},
+		step{planner.MoveRemote, "a.txt"},
+	)
+	env.synced()
+	data, err := afero.ReadFile(env.remote, "dir/b.txt")
+	if err != nil || string(data) != "a" {
+		t.Errorf("file is not moved: %v", err)
+	}
+}
+
+func TestStateInManifests(t *testing.T) {
+	env := newTestEnv(t)
+	env.state = core.NewManifestCache(env.remote)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.state.UpdateHash("a.txt", hashOf(digest.MD5, "a"))
+	env.writeFile(env.local.fs, "dir/b.txt", "b", t0)
+	env.synced()
+
+	env.local.move("a.txt", "dir/c.txt")
+	env.expect(
+		step{planner.MoveRemote, "a.txt"},
+	)
+	env.synced()
+	if hash, _ := env.state.GetHash("dir/c.txt"); !hash.Equal(hashOf(digest.MD5, "a")) {
+		t.Errorf("hash is not moved, got %v", hash)
+	}
+	if tombstone, _ := env.state.GetTombstone("a.txt"); tombstone == nil {
+		t.Error("tombstone of the moved file is missing")
+	}
+}
+
+func TestMovedAndChangedFileIsMatchedByIdentity(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.local.ids["a.txt"] = 42
+	env.synced()
+
+	env.local.move("a.txt", "b.txt")
+	env.writeFile(env.local.fs, "b.txt", "changed", t0.Add(time.Hour))
+	env.local.insync["b.txt"] = false
+
+	env.expect(
+		step{planner.MoveRemote, "a.txt"},
+		step{planner.SetInSync, "b.txt"},
+		step{planner.Upload, "b.txt"},
+	)
+}
+
+func TestMovedDirectoryIsRenamedRemotely(t *testing.T) {
+	env := newRenameTestEnv(t)
+	env.writeFile(env.remote, "dir/a.txt", "a", t0)
+	env.writeFile(env.remote, "dir/sub/b.txt", "b", t0)
+	env.local.ids["dir"] = 7
+	env.synced()
+
+	env.local.move("dir", "renamed")
+
+	env.expect(
+		step{planner.MoveRemote, "dir"},
+	)
+	env.synced()
+	for _, path := range []string{"renamed/a.txt", "renamed/sub/b.txt"} {
+		if _, ok := env.journal.Get(path); !ok {
+			t.Errorf("%s is not moved in journal", path)
+		}
+	}
+}
+
+func TestDeletedRemotelyLeavesTombstone(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "a", t0)
+	env.synced()
+
+	env.local.fs.Remove("a.txt")
+	env.synced()
+
+	tombstone, err := env.state.GetTombstone("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone == nil || tombstone.Device != "test" {
+		t.Errorf("unexpected tombstone %v", tombstone)
+	}
+}
+
+func TestTombstoneDeletesLocalCopy(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.writeFile(env.local.fs, "b.txt", "b", t0.Add(2*time.Hour))
+	env.state.PutTombstone("a.txt", core.Tombstone{Deleted: t0.Add(time.Hour), Device: "other"})
+	env.state.PutTombstone("b.txt", core.Tombstone{Deleted: t0.Add(time.Hour), Device: "other"})
+
+	// b.txt has been edited after the deletion
+	env.expect(
+		step{planner.SetInSync, "b.txt"},
+		step{planner.DeleteLocal, "a.txt"},
+		step{planner.Upload, "b.txt"},
+	)
+	env.synced()
+	if tombstone, _ := env.state.GetTombstone("b.txt"); tombstone != nil {
+		t.Error("tombstone is kept after upload")
+	}
+}
+
+func TestTombstoneOfDirectory(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.local.fs, "dir/a.txt", "a", t0)
+	env.writeFile(env.local.fs, "dir/b.txt", "b", t0.Add(2*time.Hour))
+	env.writeFile(env.local.fs, "old/c.txt", "c", t0)
+	env.state.PutTombstone("dir", core.Tombstone{Deleted: t0.Add(time.Hour), Device: "other"})
+	env.state.PutTombstone("old", core.Tombstone{Deleted: t0.Add(time.Hour), Device: "other"})
+
+	env.expect(
+		step{planner.MkdirRemote, "dir"},
+		step{planner.SetInSync, "dir/b.txt"},
+		step{planner.DeleteLocal, "dir/a.txt"},
+		step{planner.DeleteLocal, "old"},
+		step{planner.Upload, "dir/b.txt"},
+	)
+}
+
+func TestExpiredTombstoneIsIgnored(t *testing.T) {
+	env := newTestEnv(t)
+	env.options.TombstoneRetention = time.Hour
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.state.PutTombstone("a.txt", core.Tombstone{Deleted: t0.Add(time.Hour), Device: "other"})
+
+	env.expect(
+		step{planner.SetInSync, "a.txt"},
+		step{planner.Upload, "a.txt"},
+	)
+}
+
+func TestAbandonedUploadsAreRemoved(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "d/"+upload.TemporaryPrefix+"old", "x", time.Now().Add(-2*upload.TemporaryRetention))
+	env.writeFile(env.remote, "d/"+upload.TemporaryPrefix+"running", "x", time.Now())
+	env.synced()
+
+	if exists, _ := afero.Exists(env.remote, "d/"+upload.TemporaryPrefix+"old"); exists {
+		t.Error("abandoned upload is kept")
+	}
+	if exists, _ := afero.Exists(env.remote, "d/"+upload.TemporaryPrefix+"running"); !exists {
+		t.Error("running upload is removed")
+	}
+	if exists, _ := afero.Exists(env.local.fs, "d/"+upload.TemporaryPrefix+"running"); exists {
+		t.Error("temporary file is synchronized")
+	}
+}
+
+func TestReport(t *testing.T) {
+	env := newTestEnv(t)
+	env.writeFile(env.remote, "a.txt", "remote", t0)
+	env.writeFile(env.local.fs, "b.txt", "local", t0)
+
+	data, err := json.Marshal(env.expect(
+		step{planner.CreatePlaceholder, "a.txt"},
+		step{planner.SetInSync, "b.txt"},
+		step{planner.Upload, "b.txt"},
+	).Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"actions":[` +
+		`{"action":"create-placeholder","path":"a.txt","reason":"file exists only remotely","size":6},` +
+		`{"action":"set-in-sync","path":"b.txt","reason":"local changes are processed"},` +
+		`{"action":"upload","path":"b.txt","reason":"file exists only locally","size":5}],` +
+		`"counts":{"create-placeholder":1,"set-in-sync":1,"upload":1}}`
+	if string(data) != expected {
+		t.Errorf("unexpected report %s", data)
+	}
+}
+
+// timelessFs can not set modification times, like S3
+type timelessFs struct {
+	afero.Fs
+}
+
+func (timelessFs) Chtimes(string, time.Time, time.Time) error {
+	return errors.New("not supported")
+}
+
+func TestUploadedFileIsUnchangedWithoutModTimes(t *testing.T) {
+	env := newTestEnv(t)
+	env.remote = timelessFs{env.remote}
+	env.state = core.HashFilesRemotely(env.remote)
+	env.writeFile(env.local.fs, "a.txt", "a", t0)
+	env.synced()
+
+	// without a journal the modification times decide
+	env.journal = journal.New()
+	env.expect(step{planner.RecordBase, "a.txt"})
+}
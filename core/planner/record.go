@@ -0,0 +1,194 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/balazsgrill/potatodrive/core/tasks"
+)
+
+// Execute runs each action of the plan in order and records the synchronized versions in the journal.
+// Uploads are run in parallel if the executor supports it, a failed upload does not stop the others.
+// It stops at the first error of any other action, the journal is saved in any case.
+func (p *Planner) Execute(plan Plan, executor Executor) error {
+	p.removeAbandoned()
+	err := p.execute(plan, executor)
+	saveerr := p.journal.Save()
+	if err != nil {
+		return err
+	}
+	return saveerr
+}
+
+func (p *Planner) execute(plan Plan, executor Executor) error {
+	var uploads sync.WaitGroup
+	var lock sync.Mutex
+	var errs []error
+	failed := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs = append(errs, err)
+	}
+	for _, action := range plan {
+		if action.Type == Upload {
+			transfers, ok := executor.(TransferExecutor)
+			if !ok {
+				err := p.upload(action, executor)
+				if err != nil {
+					failed(err)
+				}
+				continue
+			}
+			pool := transfers.Transfers()
+			uploads.Add(1)
+			task := tasks.WrapSizedTask(pool, func(tasks.TaskContext) error {
+				return p.upload(action, executor)
+			}, 0, action.Path, action.LocalInfo.Size())
+			task.OnDone = func(err error) {
+				defer uploads.Done()
+				if errors.Is(err, tasks.ErrDropped) {
+					// the file is already marked in-sync
+					err = errors.Join(fmt.Errorf("upload %s: %w", action.Path, err), transfers.UploadDropped(action))
+				}
+				if err != nil {
+					failed(err)
+				}
+			}
+			pool.AddTask(task)
+			continue
+		}
+		if action.Type != RecordBase {
+			err := executor.Execute(action)
+			if err != nil {
+				failed(err)
+				break
+			}
+		}
+		err := p.record(action)
+		if err != nil {
+			failed(err)
+			break
+		}
+	}
+	uploads.Wait()
+	return errors.Join(errs...)
+}
+
+// upload runs an upload action and records the new version if it succeeded
+func (p *Planner) upload(action Action, executor Executor) error {
+	err := executor.Execute(action)
+	if err == nil {
+		err = p.record(action)
+	}
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", action.Path, err)
+	}
+	return nil
+}
+
+// removeAbandoned deletes temporary files of crashed uploads found by the last Plan. Errors are ignored,
+// the files may have been removed by another device in the meantime.
+func (p *Planner) removeAbandoned() {
+	for _, name := range p.abandoned {
+		p.remote.Remove(name)
+	}
+	p.abandoned = nil
+}
+
+// record updates the journal after an action has been executed successfully
+func (p *Planner) record(action Action) error {
+	switch action.Type {
+	case CreateLocalDir:
+		return p.recordDir(action.Path)
+	case MkdirRemote:
+		err := p.state.RemoveTombstone(action.Path)
+		if err != nil {
+			return err
+		}
+		return p.recordDir(action.Path)
+	case CreatePlaceholder, Dehydrate:
+		// the placeholder takes the metadata of the remote file
+		return p.recordFile(action.Path, action.RemoteInfo, action.RemoteInfo)
+	case RecordBase:
+		if action.LocalInfo.IsDir() {
+			return p.recordDir(action.Path)
+		}
+		return p.recordFile(action.Path, action.LocalInfo, action.RemoteInfo)
+	case Upload:
+		err := p.state.RemoveTombstone(action.Path)
+		if err != nil {
+			return err
+		}
+		remoteinfo, err := p.remote.Stat(action.Path)
+		if err != nil {
+			return err
+		}
+		remoteinfo, err = core.WithSourceModTime(p.state, action.Path, remoteinfo)
+		if err != nil {
+			return err
+		}
+		return p.recordFile(action.Path, action.LocalInfo, remoteinfo)
+	case MoveRemote:
+		base, ok := p.journal.Get(action.Path)
+		p.journal.Move(action.Path, action.Target)
+		err := p.state.RemoveTombstone(action.Target)
+		if err != nil {
+			return err
+		}
+		if ok && !base.Dir && !base.Remote.Hash.IsZero() {
+			// hashes of directory contents are moved with the directory
+			err = p.state.UpdateHash(action.Target, base.Remote.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		return p.putTombstone(action.Path)
+	case DeleteRemote:
+		p.journal.Remove(action.Path)
+		return p.putTombstone(action.Path)
+	case DeleteLocal, ConflictCopy:
+		p.journal.Remove(action.Path)
+	}
+	return nil
+}
+
+// putTombstone records that the path has been removed by this device
+func (p *Planner) putTombstone(remotepath string) error {
+	return p.state.PutTombstone(remotepath, core.Tombstone{
+		Deleted: time.Now().UTC(),
+		Device:  p.options.Device(),
+	})
+}
+
+func (p *Planner) recordDir(remotepath string) error {
+	id, err := p.local.ID(remotepath)
+	if err != nil {
+		return err
+	}
+	p.journal.Put(remotepath, journal.Entry{Dir: true, LocalID: id})
+	return nil
+}
+
+// recordFile records the synchronized versions of a file with the hash recorded in the state, checksums are not
+// calculated for recording
+func (p *Planner) recordFile(remotepath string, localinfo fs.FileInfo, remoteinfo fs.FileInfo) error {
+	hash, err := p.state.GetHash(remotepath)
+	if err != nil {
+		return err
+	}
+	id, err := p.local.ID(remotepath)
+	if err != nil {
+		return err
+	}
+	p.journal.Put(remotepath, journal.Entry{
+		Local:   journal.VersionOf(localinfo, hash),
+		Remote:  journal.VersionOf(remoteinfo, hash),
+		LocalID: id,
+	})
+	return nil
+}
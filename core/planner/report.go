@@ -0,0 +1,51 @@
+package planner
+
+// Report describes a plan without executing it, e.g. for a dry run
+type Report struct {
+	Actions []ReportEntry `json:"actions"`
+	// Counts is the number of actions by type
+	Counts map[ActionType]int `json:"counts"`
+}
+
+// ReportEntry is a single action of a report
+type ReportEntry struct {
+	Action ActionType `json:"action"`
+	Path   string     `json:"path"`
+	Target string     `json:"target,omitempty"`
+	Reason string     `json:"reason"`
+	// Size is the amount of data the action transfers or drops
+	Size int64 `json:"size,omitempty"`
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t ActionType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Report lists the actions of the plan that change either side
+func (p Plan) Report() Report {
+	report := Report{
+		Actions: []ReportEntry{},
+		Counts:  make(map[ActionType]int),
+	}
+	for _, action := range p {
+		if action.Type == RecordBase {
+			continue
+		}
+		entry := ReportEntry{
+			Action: action.Type,
+			Path:   action.Path,
+			Target: action.Target,
+			Reason: action.Reason,
+		}
+		switch action.Type {
+		case Upload:
+			entry.Size = action.LocalInfo.Size()
+		case CreatePlaceholder, Dehydrate:
+			entry.Size = action.RemoteInfo.Size()
+		}
+		report.Actions = append(report.Actions, entry)
+		report.Counts[action.Type]++
+	}
+	return report
+}
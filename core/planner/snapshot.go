@@ -0,0 +1,147 @@
+package planner
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/journal"
+)
+
+// snapshot is the remote tree as recorded in the journal, updated with the paths reported by a core.ChangeLister
+type snapshot struct {
+	entries map[string]fs.FileInfo
+}
+
+// baseInfo is the remote state of a path as recorded by the last synchronization
+type baseInfo struct {
+	name  string
+	entry journal.Entry
+}
+
+func (i *baseInfo) Name() string {
+	return i.name
+}
+
+func (i *baseInfo) Size() int64 {
+	return i.entry.Remote.Size
+}
+
+func (i *baseInfo) Mode() fs.FileMode {
+	if i.entry.Dir {
+		return fs.ModeDir | 0777
+	}
+	return 0666
+}
+
+// ModTime is the time reported by the remote, the time of the local source is applied like for files listed
+func (i *baseInfo) ModTime() time.Time {
+	if i.entry.Remote.Reported != 0 {
+		return time.Unix(i.entry.Remote.Reported, 0)
+	}
+	return time.Unix(i.entry.Remote.ModTime, 0)
+}
+
+func (i *baseInfo) IsDir() bool {
+	return i.entry.Dir
+}
+
+func (i *baseInfo) Sys() any {
+	return nil
+}
+
+// listChanges builds the remote tree from the journal and the changes since the cursor of the planner
+func (p *Planner) listChanges(changes []string) (*snapshot, error) {
+	s := &snapshot{
+		entries: make(map[string]fs.FileInfo),
+	}
+	p.journal.Range(func(remotepath string, entry journal.Entry) {
+		s.entries[remotepath] = &baseInfo{name: path.Base(remotepath), entry: entry}
+	})
+	for _, changed := range changes {
+		changed = strings.Trim(changed, "/")
+		if changed == "" {
+			continue
+		}
+		s.remove(changed)
+		err := utils.Walk(p.remote, changed, func(remotepath string, info fs.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			s.entries[remotepath] = info
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for dir := path.Dir(changed); dir != "."; dir = path.Dir(dir) {
+			if _, ok := s.entries[dir]; ok {
+				break
+			}
+			info, err := p.remote.Stat(dir)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.entries[dir] = info
+		}
+	}
+	return s, nil
+}
+
+// remove forgets the path and everything below it
+func (s *snapshot) remove(remotepath string) {
+	for p := range s.entries {
+		if p == remotepath || strings.HasPrefix(p, remotepath+"/") {
+			delete(s.entries, p)
+		}
+	}
+}
+
+func (s *snapshot) Stat(remotepath string) (fs.FileInfo, error) {
+	info, ok := s.entries[remotepath]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: remotepath, Err: os.ErrNotExist}
+	}
+	return info, nil
+}
+
+// comparePaths orders paths the same way as utils.Walk visits them: directories are followed by their contents
+func comparePaths(a, b string) int {
+	return slices.Compare(strings.Split(a, "/"), strings.Split(b, "/"))
+}
+
+// Walk visits the entries of the snapshot like utils.Walk visits a file system, excluding the root
+func (s *snapshot) Walk(walkFn filepath.WalkFunc) error {
+	paths := make([]string, 0, len(s.entries))
+	for p := range s.entries {
+		paths = append(paths, p)
+	}
+	slices.SortFunc(paths, comparePaths)
+	skipped := ""
+	for _, p := range paths {
+		if skipped != "" && strings.HasPrefix(p, skipped+"/") {
+			continue
+		}
+		info := s.entries[p]
+		err := walkFn(p, info, nil)
+		if err == filepath.SkipDir && info.IsDir() {
+			skipped = p
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package planner_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/balazsgrill/potatodrive/core/tasks"
+)
+
+// transferEnv runs uploads of the test environment on a task pool. Uploads of the failing path return an error
+// and clear its in-sync state like the engines do. The upload of the closing path closes the pool.
+type transferEnv struct {
+	*testEnv
+	pool    *tasks.TaskExecutor
+	failing string
+	closing string
+}
+
+func newTransferEnv(t *testing.T, failing string) *transferEnv {
+	return newTransferEnvOf(t, 3, failing)
+}
+
+func newTransferEnvOf(t *testing.T, workers int, failing string) *transferEnv {
+	pool := tasks.NewTaskPool(func(tasks.TaskState) {}, workers, 0)
+	go pool.Run()
+	t.Cleanup(pool.Close)
+	return &transferEnv{testEnv: newTestEnv(t), pool: pool, failing: failing}
+}
+
+func (e *transferEnv) Transfers() tasks.TaskConsumer {
+	return e.pool
+}
+
+func (e *transferEnv) UploadDropped(action planner.Action) error {
+	e.local.insync[action.Path] = false
+	return nil
+}
+
+func (e *transferEnv) Execute(action planner.Action) error {
+	if action.Type == planner.Upload && action.Path == e.closing {
+		e.pool.Close()
+	}
+	if action.Type == planner.Upload && action.Path == e.failing {
+		e.local.insync[action.Path] = false
+		return errors.New("upload failed")
+	}
+	return e.testEnv.Execute(action)
+}
+
+func TestFailedUploadDoesNotStopOthers(t *testing.T) {
+	env := newTransferEnv(t, "b.txt")
+	for _, path := range []string{"a.txt", "b.txt", "c.txt", "d/e.txt"} {
+		env.writeFile(env.local.fs, path, path, t0)
+	}
+
+	p := env.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Execute(plan, env)
+	if err == nil || !strings.Contains(err.Error(), "b.txt") {
+		t.Fatalf("expected failed upload of b.txt, got %v", err)
+	}
+	for _, path := range []string{"a.txt", "c.txt", "d/e.txt"} {
+		if _, ok := env.journal.Get(path); !ok {
+			t.Errorf("%s is not uploaded", path)
+		}
+	}
+	if _, ok := env.journal.Get("b.txt"); ok {
+		t.Error("failed upload is recorded")
+	}
+
+	// only the failed upload is retried
+	env.failing = ""
+	env.expect(
+		step{planner.SetInSync, "b.txt"},
+		step{planner.Upload, "b.txt"},
+	)
+}
+
+func TestFailedUploadDoesNotStopOthersWithoutPool(t *testing.T) {
+	env := newTestEnv(t)
+	for _, path := range []string{"a.txt", "b.txt"} {
+		env.writeFile(env.local.fs, path, path, t0)
+	}
+	failing := &transferEnv{testEnv: env, failing: "a.txt"}
+
+	p := env.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Execute(plan, executorOnly{failing})
+	if err == nil {
+		t.Fatal("expected failed upload")
+	}
+	if _, ok := env.journal.Get("b.txt"); !ok {
+		t.Error("b.txt is not uploaded")
+	}
+}
+
+// executorOnly hides the pool of an executor
+type executorOnly struct {
+	planner.Executor
+}
+
+func TestDroppedUploadsAreRetried(t *testing.T) {
+	// a single worker keeps the other uploads queued while the first one runs
+	env := newTransferEnvOf(t, 1, "")
+	env.closing = "a.txt"
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		env.writeFile(env.local.fs, path, path, t0)
+	}
+
+	p := env.planner()
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Execute(plan, env)
+	if !errors.Is(err, tasks.ErrDropped) {
+		t.Fatalf("expected dropped uploads, got %v", err)
+	}
+	if _, ok := env.journal.Get("a.txt"); !ok {
+		t.Error("a.txt is not uploaded")
+	}
+	for _, path := range []string{"b.txt", "c.txt"} {
+		if _, ok := env.journal.Get(path); ok {
+			t.Errorf("dropped upload of %s is recorded", path)
+		}
+	}
+
+	env.expect(
+		step{planner.SetInSync, "b.txt"},
+		step{planner.SetInSync, "c.txt"},
+		step{planner.Upload, "b.txt"},
+		step{planner.Upload, "c.txt"},
+	)
+}
@@ -0,0 +1,20 @@
+package filesystem
+
+func (instance *VirtualizationInstance) FileConflict(path string, conflictcopy string) {
+	if instance.callbacks != nil {
+		instance.callbacks.FileConflict(path, conflictcopy)
+	}
+}
+
+func (instance *VirtualizationInstance) FileNameCollision(path string, remotepath string) {
+	if instance.callbacks != nil {
+		instance.callbacks.FileNameCollision(path, remotepath)
+	}
+}
+
+// localChanged schedules a synchronization for a local change that could not be propagated by its notification
+func (instance *VirtualizationInstance) localChanged() {
+	if instance.changed != nil {
+		instance.changed()
+	}
+}
@@ -7,23 +7,31 @@ import (
 	"io/fs"
 	"os"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"C"
 
+	"github.com/balazsgrill/potatodrive/bindings/utils"
 	"github.com/balazsgrill/potatodrive/core"
 	"github.com/balazsgrill/potatodrive/core/projfs"
 	"github.com/google/uuid"
 	"github.com/spf13/afero"
 )
 import (
-	"bytes"
-	"crypto/md5"
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/ignore"
+	"github.com/balazsgrill/potatodrive/core/journal"
+	"github.com/balazsgrill/potatodrive/core/planner"
+	"github.com/balazsgrill/potatodrive/core/tasks"
+	"github.com/balazsgrill/potatodrive/core/trash"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/balazsgrill/potatodrive/core/versions"
 	"github.com/rs/zerolog"
 )
 
@@ -32,12 +40,30 @@ type VirtualizationInstance struct {
 	rootPath         string
 	fs               afero.Fs
 	remoteCacheState core.RemoteStateCache
+	journal          journal.Journal
+	options          core.Options
+	tombstones       core.TombstoneCollector
+	trash            *trash.Trash
+	versions         *versions.Versions
+	ignore           *ignore.Matcher
+	cursor           string
+	transfers        *tasks.TaskExecutor
+	uploader         *upload.Uploader
 	_instanceHandle  projfs.PRJ_NAMESPACE_VIRTUALIZATION_CONTEXT
 	enumerations     map[syscall.GUID]*enumerationSession
+	callbacks        core.FileStateCallbacks
+	changed          func()
 }
 
 // SetStateCallbacks implements core.Virtualization.
 func (instance *VirtualizationInstance) SetStateCallbacks(callbacks core.FileStateCallbacks) {
+	instance.callbacks = callbacks
+}
+
+// SetChangeCallback implements core.Virtualization. Local changes are propagated by notifications right away,
+// the callback is called for the ones that could not be, so they are picked up by a synchronization.
+func (instance *VirtualizationInstance) SetChangeCallback(callback func()) {
+	instance.changed = callback
 }
 
 type enumerationSession struct {
@@ -53,17 +79,62 @@ func (instance *VirtualizationInstance) Close() error {
 	}
 	projfs.PrjStopVirtualizing(instance._instanceHandle)
 	instance._instanceHandle = 0
+	instance.transfers.Close()
 	instance.Logger.Print("Stopped virtualization")
-	return nil
+	return core.CloseRemoteStateCache(instance.remoteCacheState)
 }
 
-func StartProjecting(rootPath string, filesystem afero.Fs, logger zerolog.Logger) (core.Virtualization, error) {
+func StartProjecting(rootPath string, filesystem afero.Fs, logger zerolog.Logger, options core.Options) (core.Virtualization, error) {
+	j, err := journal.OpenDir(options.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := core.OpenRemoteStateCache(filesystem, options)
+	if err != nil {
+		return nil, err
+	}
 	instance := &VirtualizationInstance{
 		Logger:           logger,
 		enumerations:     make(map[syscall.GUID]*enumerationSession),
-		remoteCacheState: core.HashFilesRemotely(filesystem),
+		remoteCacheState: state,
+		journal:          j,
+		options:          options,
+		versions:         versions.New(filesystem, options.Versions),
+	}
+	instance.uploader = &upload.Uploader{Remote: filesystem, Checkpoints: upload.OpenDir(options.DataDir), Versions: instance.versions, Hash: options.Hash}
+	instance.tombstones = core.TombstoneCollector{State: instance.remoteCacheState, Retention: options.TombstoneRetention}
+	instance.trash = trash.New(filesystem, options.Trash)
+	instance.transfers = tasks.NewTaskPool(instance.transferStateChanged, options.TransferWorkers(), core.LargeTransferSize)
+	go instance.transfers.Run()
+	err = instance.start(rootPath, filesystem)
+	if err != nil {
+		instance.transfers.Close()
+		core.CloseRemoteStateCache(state)
+		return nil, err
+	}
+	return instance, nil
+}
+
+// DryRun plans the synchronization of the local folder without starting the virtualization or changing either side
+func DryRun(rootPath string, filesystem afero.Fs, logger zerolog.Logger, options core.Options) (planner.Plan, error) {
+	j, err := journal.OpenDir(options.DataDir)
+	if err != nil {
+		return nil, err
 	}
-	return instance, instance.start(rootPath, filesystem)
+	state, err := core.OpenRemoteStateCache(filesystem, options)
+	if err != nil {
+		return nil, err
+	}
+	defer core.CloseRemoteStateCache(state)
+	instance := &VirtualizationInstance{
+		Logger:           logger,
+		rootPath:         rootPath,
+		fs:               filesystem,
+		remoteCacheState: state,
+		journal:          j,
+		options:          options,
+	}
+	return planner.New(filesystem, &localState{instance: instance}, instance.remoteCacheState, j, options).Plan()
 }
 
 func (instance *VirtualizationInstance) start(rootPath string, filesystem afero.Fs) error {
@@ -86,12 +157,13 @@ func (instance *VirtualizationInstance) start(rootPath string, filesystem afero.
 	instance.Logger.Printf("Starting virtualization of '%s' (%v)", rootPath, *id)
 	options := &projfs.PRJ_STARTVIRTUALIZING_OPTIONS{
 		NotificationMappings: &projfs.PRJ_NOTIFICATION_MAPPING{
-			NotificationBitMask: projfs.PRJ_NOTIFY_NEW_FILE_CREATED | projfs.PRJ_NOTIFY_FILE_OVERWRITTEN | projfs.PRJ_NOTIFY_FILE_HANDLE_CLOSED_FILE_DELETED | projfs.PRJ_NOTIFY_FILE_HANDLE_CLOSED_FILE_MODIFIED,
+			NotificationBitMask: projfs.PRJ_NOTIFY_NEW_FILE_CREATED | projfs.PRJ_NOTIFY_FILE_OVERWRITTEN | projfs.PRJ_NOTIFY_FILE_HANDLE_CLOSED_FILE_DELETED | projfs.PRJ_NOTIFY_FILE_HANDLE_CLOSED_FILE_MODIFIED | projfs.PRJ_NOTIFY_FILE_RENAMED,
 			NotificationRoot:    core.GetPointer(""),
 		},
 		NotificationMappingsCount: 1,
-		PoolThreadCount:           4,
-		ConcurrentThreadCount:     4,
+		// hydrations are run by the callback threads
+		PoolThreadCount:       uint32(instance.options.TransferWorkers()),
+		ConcurrentThreadCount: uint32(instance.options.TransferWorkers()),
 	}
 	hr = projfs.PrjStartVirtualizing(rootPath, instance.get_callbacks(), instance, options, &instance._instanceHandle)
 	err = core.ErrorByCode(hr)
@@ -99,7 +171,7 @@ func (instance *VirtualizationInstance) start(rootPath string, filesystem afero.
 		instance.Logger.Printf("Error starting virtualization: %s", err)
 		return err
 	}
-	err = instance.syncRemoteToLocal()
+	err = instance.PerformSynchronization()
 	if err != nil {
 		instance.Logger.Printf("Initial sync failed: %s", err)
 		return nil
@@ -131,152 +203,176 @@ func (instance *VirtualizationInstance) path_getNameLocal(path string) string {
 
 func (instance *VirtualizationInstance) PerformSynchronization() error {
 	// TODO propagate file sync state
-	err := instance.syncLocalToRemote()
+	p := planner.New(instance.fs, &localState{instance: instance}, instance.remoteCacheState, instance.journal, instance.options)
+	p.SetCursor(instance.cursor)
+	plan, err := p.Plan()
+	if err != nil {
+		return err
+	}
+	instance.ignore = p.Ignore()
+	err = p.Execute(plan, instance)
 	if err != nil {
 		return err
 	}
-	return instance.syncRemoteToLocal()
+	for _, collision := range p.NameCollisions() {
+		instance.Logger.Printf("'%s' differs from remote '%s' only by case or normalization, it is not synchronized under its own name", collision.Path, collision.RemotePath)
+		instance.FileNameCollision(instance.path_remoteToLocal(collision.Path), collision.RemotePath)
+	}
+	instance.cursor = p.Cursor()
+	err = instance.tombstones.Collect(time.Now())
+	if err != nil {
+		return err
+	}
+	err = instance.trash.Purge(time.Now())
+	if err != nil {
+		return err
+	}
+	return instance.versions.Purge(time.Now())
 }
 
-func (instance *VirtualizationInstance) syncRemoteToLocal() error {
-	return utils.Walk(instance.fs, "", func(path string, remoteinfo fs.FileInfo, err error) error {
-		instance.Logger.Printf("Syncing remote file '%s'", path)
-		if os.IsNotExist(err) {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-		if remoteinfo.IsDir() {
-			return nil
-		}
-		filename := instance.path_getNameRemote(path)
-		if strings.HasPrefix(filename, ".") {
-			return nil
-		}
-		localpath := instance.path_remoteToLocal(path)
-		var localstate projfs.PRJ_FILE_STATE
-		hr := projfs.PrjGetOnDiskFileState(localpath, &localstate)
-		if hr != 0 {
-			return core.ErrorByCode(hr)
-		}
+var _ planner.TransferExecutor = (*VirtualizationInstance)(nil)
 
-		if (localstate | (projfs.PRJ_FILE_STATE_FULL & projfs.PRJ_FILE_STATE_HYDRATED_PLACEHOLDER)) != 0 {
-			// check if remote is newer
-			localinfo, _ := os.Stat(localpath)
-			if localinfo.ModTime().UTC().Unix() < remoteinfo.ModTime().UTC().Unix() {
-				instance.Logger.Printf("Updating local file '%s'", path)
-				var placeholderInfo projfs.PRJ_PLACEHOLDER_INFO
-				FillInPlaceholderInfo(&placeholderInfo, remoteinfo)
-				//err = core.ErrorByCode(projfs.PrjWritePlaceholderInfo(instance._instanceHandle, path, &placeholderInfo, uint32(unsafe.Sizeof(placeholderInfo))))
-				err = instance.UpdateFileIfNeeded(path, &placeholderInfo, uint32(unsafe.Sizeof(placeholderInfo)), projfs.PRJ_UPDATE_ALLOW_DIRTY_METADATA|projfs.PRJ_UPDATE_ALLOW_DIRTY_DATA)
-				if err != nil {
-					return err
-				}
-			}
-		}
+// Transfers implements planner.TransferExecutor.
+func (instance *VirtualizationInstance) Transfers() tasks.TaskConsumer {
+	return instance.transfers
+}
+
+// UploadDropped implements planner.TransferExecutor. The file is not recorded in the journal, so it is uploaded
+// by the next synchronization.
+func (instance *VirtualizationInstance) UploadDropped(planner.Action) error {
+	return nil
+}
 
+func (instance *VirtualizationInstance) transferStateChanged(state tasks.TaskState) {
+	if state.Error != nil {
+		instance.Logger.Printf("Transfer of '%s' failed: %v", state.Name, state.Error)
+	}
+}
+
+// Execute implements planner.Executor.
+func (instance *VirtualizationInstance) Execute(action planner.Action) error {
+	instance.Logger.Printf("Executing %s", action)
+	switch action.Type {
+	case planner.CreatePlaceholder, planner.CreateLocalDir, planner.SetInSync:
+		// placeholders are projected on demand, there is no in-sync state to maintain
 		return nil
-	})
+	case planner.Dehydrate:
+		var placeholderInfo projfs.PRJ_PLACEHOLDER_INFO
+		FillInPlaceholderInfo(&placeholderInfo, action.RemoteInfo)
+		return instance.UpdateFileIfNeeded(action.Path, &placeholderInfo, uint32(unsafe.Sizeof(placeholderInfo)), projfs.PRJ_UPDATE_ALLOW_DIRTY_METADATA|projfs.PRJ_UPDATE_ALLOW_DIRTY_DATA)
+	case planner.DeleteLocal:
+		return os.RemoveAll(instance.path_remoteToLocal(action.Path))
+	case planner.ConflictCopy:
+		return instance.conflictCopy(action)
+	case planner.MkdirRemote:
+		return instance.fs.MkdirAll(action.Path, 0777)
+	case planner.MoveRemote:
+		return utils.Move(instance.fs, action.Path, action.Target)
+	case planner.DeleteRemote:
+		_, err := instance.trash.Move(action.Path, time.Now())
+		return err
+	case planner.Upload:
+		instance.Logger.Printf("Uploading file '%s'", action.Path)
+		return instance.streamLocalToRemote(action.Path)
+	}
+	return fmt.Errorf("unsupported action %s", action)
+}
+
+// conflictCopy saves the local version of a conflicting file to the target, then replaces it with the remote version
+func (instance *VirtualizationInstance) conflictCopy(action planner.Action) error {
+	instance.Logger.Printf("Conflict on '%s', local version is saved as '%s'", action.Path, action.Target)
+	localtarget := instance.path_remoteToLocal(action.Target)
+	err := copyFile(instance.path_remoteToLocal(action.Path), localtarget)
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(localtarget, action.LocalInfo.ModTime(), action.LocalInfo.ModTime())
+	if err != nil {
+		return err
+	}
+
+	var placeholderInfo projfs.PRJ_PLACEHOLDER_INFO
+	FillInPlaceholderInfo(&placeholderInfo, action.RemoteInfo)
+	err = instance.UpdateFileIfNeeded(action.Path, &placeholderInfo, uint32(unsafe.Sizeof(placeholderInfo)), projfs.PRJ_UPDATE_ALLOW_DIRTY_METADATA|projfs.PRJ_UPDATE_ALLOW_DIRTY_DATA)
+	if err != nil {
+		return err
+	}
+	instance.FileConflict(instance.path_remoteToLocal(action.Path), localtarget)
+	return nil
 }
 
-func (instance *VirtualizationInstance) localHash(remotepath string) ([]byte, error) {
+// copyFile streams the content of a local file to a new one
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeerr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeerr
+}
+
+func (instance *VirtualizationInstance) localHash(remotepath string, algorithm digest.Algorithm) (digest.Digest, error) {
 	// only calculate hash if file is not a placeholder
 	var localstate projfs.PRJ_FILE_STATE
 	hr := projfs.PrjGetOnDiskFileState(instance.path_remoteToLocal(remotepath), &localstate)
 	if hr != 0 {
-		return nil, core.ErrorByCode(hr)
+		return digest.Digest{}, core.ErrorByCode(hr)
 	}
 	if (localstate | (projfs.PRJ_FILE_STATE_FULL & projfs.PRJ_FILE_STATE_HYDRATED_PLACEHOLDER)) == 0 {
-		return nil, nil
+		return digest.Digest{}, nil
 	}
-	hash := md5.New()
 	f, err := os.Open(instance.path_remoteToLocal(remotepath))
 	if err != nil {
-		return nil, err
+		return digest.Digest{}, err
 	}
 	defer f.Close()
-	_, err = io.Copy(hash, f)
-	if err != nil {
-		return nil, err
-	}
-	return hash.Sum(nil), nil
+	return digest.Sum(algorithm, f)
 }
 
-func (instance *VirtualizationInstance) syncLocalToRemote() error {
-	return filepath.Walk(instance.rootPath, func(localpath string, localinfo fs.FileInfo, err error) error {
-		instance.Logger.Printf("Syncing local file '%s'", localpath)
-		if os.IsNotExist(err) {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+// localState provides the view of the virtualization root for the planner
+type localState struct {
+	instance *VirtualizationInstance
+}
 
-		path := instance.path_localToRemote(localpath)
-		if localinfo.IsDir() {
-			return instance.fs.MkdirAll(path, 0777)
-		}
-		if strings.HasPrefix(path, ".") {
-			return nil
-		}
+var _ planner.Local = (*localState)(nil)
 
-		var localstate projfs.PRJ_FILE_STATE
-		hr := projfs.PrjGetOnDiskFileState(localpath, &localstate)
-		if hr != 0 {
-			return core.ErrorByCode(hr)
-		}
+// Stat implements planner.Local.
+func (l *localState) Stat(path string) (planner.LocalFile, error) {
+	localinfo, err := os.Stat(l.instance.path_remoteToLocal(path))
+	if err != nil {
+		return planner.LocalFile{}, err
+	}
+	// ProjFS does not track in-sync state, every file on disk is compared with the remote
+	return planner.LocalFile{FileInfo: localinfo}, nil
+}
 
-		if (localstate | (projfs.PRJ_FILE_STATE_FULL & projfs.PRJ_FILE_STATE_HYDRATED_PLACEHOLDER)) != 0 {
-			// check if local is newer
-			remoteinfo, err := instance.fs.Stat(path)
-			if os.IsNotExist(err) {
-				// chek if hash file exists on remote
-
-				hash, err := instance.remoteCacheState.GetHash(path)
-				if err != nil {
-					return err
-				}
-				exists := len(hash) > 0
-				if exists {
-					localhash, err := instance.localHash(path)
-					if err != nil {
-						return err
-					}
-					if localhash == nil {
-						// local file does not exist, no need to upload
-						// TODO is this a tombstone?
-						return err
-					}
-					if bytes.Equal(hash, localhash) {
-						// hash is the same this file has been removed remotely, delete local file
-						return os.Remove(localpath)
-					}
-				}
-				// new local file, remote does not exist, or hash is different
-				instance.Logger.Printf("Uploading file '%s'", path)
-				return instance.streamLocalToRemote(path)
-			}
-			if err != nil {
-				return err
-			}
-			// info from walk return modification time of 2185 TODO: why?
-			localinfo, err := os.Stat(localpath)
-			if err != nil {
-				return err
-			}
-			localmodtime := localinfo.ModTime()
-			localtime := localmodtime.Unix()
-			remotetime := remoteinfo.ModTime().Unix()
-			if localtime > remotetime {
-				instance.Logger.Printf("Updating remote file '%s'", path)
-				return instance.streamLocalToRemote(path)
-			}
-		}
-		return nil
+// Walk implements planner.Local.
+func (l *localState) Walk(walkFn func(path string, file planner.LocalFile, err error) error) error {
+	return filepath.Walk(l.instance.rootPath, func(localpath string, localinfo fs.FileInfo, err error) error {
+		l.instance.Logger.Printf("Syncing local file '%s'", localpath)
+		return walkFn(l.instance.path_localToRemote(localpath), planner.LocalFile{FileInfo: localinfo}, err)
 	})
 }
 
+// Hash implements planner.Local.
+func (l *localState) Hash(path string, algorithm digest.Algorithm) (digest.Digest, error) {
+	return l.instance.localHash(path, algorithm)
+}
+
+// ID implements planner.Local. Identities are not tracked, renames are propagated by notifications.
+func (l *localState) ID(path string) (uint64, error) {
+	return 0, nil
+}
+
 func (instance *VirtualizationInstance) getVirtualizationInfoFileName() string {
 	return instance.rootPath + "\\.virtualization"
 }
@@ -341,62 +437,110 @@ func (instance *VirtualizationInstance) Notify(callbackData *projfs.PRJ_CALLBACK
 	// operation is done on file system
 	filename := instance.path_localToRemote(callbackData.GetFilePathName())
 	instance.Logger.Printf("Notify: %t %d %d '%s', %d", IsDirectory, callbackData.CommandId, notification, filename, *operationParameters)
+	if instance.ignore.Match(filename, IsDirectory) {
+		// excluded files are kept locally only
+		return 0
+	}
 	switch notification {
 
 	case projfs.PRJ_NOTIFICATION_NEW_FILE_CREATED:
 		if IsDirectory {
-			return returncode(instance.fs.Mkdir(filename, 0777))
+			return instance.notified(instance.fs.Mkdir(filename, 0777))
 		} else {
 			_, err := instance.fs.Create(filename)
-			if err != nil {
-				instance.Logger.Print(err)
-				return 1
-			}
-			return 0
+			return instance.notified(err)
 		}
 	case projfs.PRJ_NOTIFICATION_FILE_HANDLE_CLOSED_FILE_MODIFIED, projfs.PRJ_NOTIFICATION_FILE_OVERWRITTEN:
 		if !IsDirectory {
-			return returncode(instance.streamLocalToRemote(filename))
+			return instance.notified(instance.uploadModified(filename))
 		}
 	case projfs.PRJ_NOTIFICATION_FILE_HANDLE_CLOSED_FILE_DELETED:
-		return returncode(instance.fs.Remove(filename))
+		instance.journal.Remove(filename)
+		_, err := instance.trash.Move(filename, time.Now())
+		if err != nil {
+			return instance.notified(err)
+		}
+		return instance.notified(instance.remoteCacheState.PutTombstone(filename, core.Tombstone{
+			Deleted: time.Now().UTC(),
+			Device:  instance.options.Device(),
+		}))
+	case projfs.PRJ_NOTIFICATION_FILE_RENAMED:
+		destination := instance.path_localToRemote(core.GetString(destinationFileName))
+		if filename == "" || destination == "" || instance.ignore.Match(destination, IsDirectory) {
+			// moved across the boundary of the virtualization root, it is handled by the next synchronization
+			instance.localChanged()
+			return 0
+		}
+		err := utils.Move(instance.fs, filename, destination)
+		if err != nil {
+			instance.Logger.Printf("Move '%s' to '%s' failed: %v", filename, destination, err)
+			instance.localChanged()
+			return 1
+		}
+		instance.journal.Move(filename, destination)
+		return 0
 	}
 	return 0
 }
 
+// notified returns the result of a notification, failed ones are left to the next synchronization
+func (instance *VirtualizationInstance) notified(err error) uintptr {
+	if err != nil {
+		instance.Logger.Print(err)
+		instance.localChanged()
+	}
+	return returncode(err)
+}
+
+// streamLocalToRemote uploads the local file, an interrupted upload of the same version is continued
 func (instance *VirtualizationInstance) streamLocalToRemote(filename string) error {
-	file, err := os.Open(instance.path_remoteToLocal(filename))
+	_, _, err := instance.upload(filename)
+	return err
+}
+
+// uploadModified uploads a file changed outside of a synchronization and records the uploaded version in the
+// journal, so the next synchronization does not see it changed on both sides
+func (instance *VirtualizationInstance) uploadModified(filename string) error {
+	localinfo, hash, err := instance.upload(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	data := make([]byte, 1024*1024)
-	targetfile, err := instance.fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
+	remoteinfo, err := instance.fs.Stat(filename)
 	if err != nil {
 		return err
 	}
-	defer targetfile.Close()
-
-	hash := md5.New()
-	for {
-		n, err := file.Read(data)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-		_, err = hash.Write(data[:n])
-		if err != nil {
-			return err
-		}
-		_, err = targetfile.Write(data[:n])
-		if err != nil {
-			return err
-		}
+	remoteinfo, err = core.WithSourceModTime(instance.remoteCacheState, filename, remoteinfo)
+	if err != nil {
+		return err
 	}
+	instance.journal.Put(filename, journal.Entry{
+		Local:  journal.VersionOf(localinfo, hash),
+		Remote: journal.VersionOf(remoteinfo, hash),
+	})
+	return nil
+}
 
-	return instance.remoteCacheState.UpdateHash(filename, hash.Sum(nil))
+// upload uploads the local file and returns the uploaded version with its hash
+func (instance *VirtualizationInstance) upload(filename string) (fs.FileInfo, digest.Digest, error) {
+	file, err := os.Open(instance.path_remoteToLocal(filename))
+	if err != nil {
+		return nil, digest.Digest{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, digest.Digest{}, err
+	}
+	hash, err := instance.uploader.Upload(file, filename, nil)
+	if err != nil {
+		return nil, digest.Digest{}, err
+	}
+	err = instance.remoteCacheState.UpdateHash(filename, hash)
+	if err != nil {
+		return nil, digest.Digest{}, err
+	}
+	err = core.PreserveModTime(instance.fs, instance.remoteCacheState, filename, info.ModTime())
+	return info, hash, err
 }
 
 func (instance *VirtualizationInstance) QueryFileName(callbackData *projfs.PRJ_CALLBACK_DATA) uintptr {
@@ -458,7 +602,7 @@ func (instance *VirtualizationInstance) GetDirectoryEnumeration(callbackData *pr
 	for _, file := range files[session.sentcount:] {
 		session.sentcount += 1
 		fname := filepath.Base(file.Name())
-		if strings.HasPrefix(fname, ".") {
+		if instance.options.Skips(path.Join(filenamepath, fname)) || instance.ignore.Match(path.Join(filenamepath, fname), file.IsDir()) {
 			continue
 		}
 
@@ -509,7 +653,7 @@ func (instance *VirtualizationInstance) GetPlaceholderInfo(callbackData *projfs.
 	filename := instance.path_localToRemote(callbackData.GetFilePathName())
 	instance.Logger.Printf("GetPlaceholderInfo %s", filename)
 	stat, err := instance.fs.Stat(filename)
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) || (err == nil && instance.ignore.Match(filename, stat.IsDir())) {
 		return uintptr(0x80070002)
 	}
 	if err != nil {
@@ -523,6 +667,9 @@ func (instance *VirtualizationInstance) GetPlaceholderInfo(callbackData *projfs.
 func (instance *VirtualizationInstance) GetFileData(callbackData *projfs.PRJ_CALLBACK_DATA, byteOffset uint64, length uint32) uintptr {
 	filename := instance.path_localToRemote(callbackData.GetFilePathName())
 	instance.Logger.Printf("GetFileData %s[%d]@%d", filename, length, byteOffset)
+	if instance.ignore.Match(filename, false) {
+		return uintptr(0x80070002)
+	}
 	file, err := instance.fs.Open(filename)
 	if err != nil {
 		instance.Logger.Printf("Error opening file %s: %s", filename, err)
@@ -41,7 +41,7 @@ func (i *testInstance) start() {
 	started := make(chan bool)
 	var err error
 	go func() {
-		i.closer, err = filesystem.StartProjecting(i.location, i.fs, zerolog.New(zerolog.NewConsoleWriter()))
+		i.closer, err = filesystem.StartProjecting(i.location, i.fs, zerolog.New(zerolog.NewConsoleWriter()), core.Options{})
 		started <- true
 		<-i.closechan
 		i.closer.Close()
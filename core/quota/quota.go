@@ -0,0 +1,90 @@
+// Package quota selects hydrated files to be dehydrated to keep the local cache within its budget
+package quota
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a local file with its content available on disk
+type File struct {
+	Path       string
+	Size       int64
+	LastAccess time.Time
+}
+
+// Policy is the local cache budget of a binding, the zero value keeps everything
+type Policy struct {
+	// MaxSize is the maximum total size of hydrated files in bytes, unlimited if 0
+	MaxSize int64
+	// MaxAge is the period after an unused file is dehydrated, unlimited if 0
+	MaxAge time.Duration
+}
+
+// Enabled returns true if the policy may select any file
+func (p Policy) Enabled() bool {
+	return p.MaxSize > 0 || p.MaxAge > 0
+}
+
+// Select returns the files to dehydrate, least recently used first. Files unused for longer than MaxAge
+// are always selected, then further files until the rest fits into MaxSize.
+func (p Policy) Select(files []File, now time.Time) []File {
+	if !p.Enabled() {
+		return nil
+	}
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b File) int {
+		if c := a.LastAccess.Compare(b.LastAccess); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	var total int64
+	for _, f := range sorted {
+		total += f.Size
+	}
+	var result []File
+	for _, f := range sorted {
+		expired := p.MaxAge > 0 && now.Sub(f.LastAccess) > p.MaxAge
+		oversize := p.MaxSize > 0 && total > p.MaxSize
+		if !expired && !oversize {
+			// all remaining files are used more recently
+			break
+		}
+		result = append(result, f)
+		total -= f.Size
+	}
+	return result
+}
+
+var units = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"TB", 1 << 40}, {"T", 1 << 40},
+	{"GB", 1 << 30}, {"G", 1 << 30},
+	{"MB", 1 << 20}, {"M", 1 << 20},
+	{"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses sizes like "500MB", "20 GB" or "1.5T". Units are binary multiples, a number without unit is in bytes.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(number * multiplier), nil
+}
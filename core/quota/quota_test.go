@@ -0,0 +1,81 @@
+package quota_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/quota"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func daysAgo(days int) time.Time {
+	return now.Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+func paths(files []quota.File) []string {
+	var result []string
+	for _, f := range files {
+		result = append(result, f.Path)
+	}
+	return result
+}
+
+var files = []quota.File{
+	{Path: "recent.txt", Size: 300, LastAccess: daysAgo(1)},
+	{Path: "old.txt", Size: 100, LastAccess: daysAgo(40)},
+	{Path: "older.txt", Size: 200, LastAccess: daysAgo(60)},
+	{Path: "middle.txt", Size: 400, LastAccess: daysAgo(10)},
+}
+
+func expect(t *testing.T, policy quota.Policy, expected ...string) {
+	t.Helper()
+	selected := paths(policy.Select(files, now))
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("%+v: expected %v, got %v", policy, expected, selected)
+	}
+}
+
+func TestDisabledPolicyKeepsEverything(t *testing.T) {
+	expect(t, quota.Policy{})
+}
+
+func TestLeastRecentlyUsedFilesAreSelectedOverSize(t *testing.T) {
+	expect(t, quota.Policy{MaxSize: 1000})
+	expect(t, quota.Policy{MaxSize: 800}, "older.txt")
+	expect(t, quota.Policy{MaxSize: 700}, "older.txt", "old.txt")
+	expect(t, quota.Policy{MaxSize: 650}, "older.txt", "old.txt", "middle.txt")
+	expect(t, quota.Policy{MaxSize: 1}, "older.txt", "old.txt", "middle.txt", "recent.txt")
+}
+
+func TestUnusedFilesAreSelected(t *testing.T) {
+	expect(t, quota.Policy{MaxAge: 30 * 24 * time.Hour}, "older.txt", "old.txt")
+	expect(t, quota.Policy{MaxAge: 30 * 24 * time.Hour, MaxSize: 350}, "older.txt", "old.txt", "middle.txt")
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":      0,
+		"512":    512,
+		"10KB":   10 << 10,
+		"20 GB":  20 << 30,
+		"1.5g":   3 << 29,
+		"500M":   500 << 20,
+		"2T":     2 << 40,
+		" 100B ": 100,
+	}
+	for s, expected := range cases {
+		size, err := quota.ParseSize(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if size != expected {
+			t.Errorf("%q: expected %d, got %d", s, expected, size)
+		}
+	}
+	for _, s := range []string{"", "GB", "-1", "ten"} {
+		if _, err := quota.ParseSize(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -1,15 +1,86 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"path"
+	"strings"
+	"time"
 
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
 	"github.com/spf13/afero"
 )
 
 // RemoteStateCache is the contract for keeping track files seen by the client on the remote side.
 type RemoteStateCache interface {
-	UpdateHash(remotepath string, hash []byte) error
-	GetHash(remotepath string) ([]byte, error)
+	UpdateHash(remotepath string, hash digest.Digest) error
+	// GetHash returns the last known content hash of a remote file with its algorithm, zero if it is not known
+	GetHash(remotepath string) (digest.Digest, error)
+	// GetModTime returns the source modification time of an uploaded file, nil if it is not known
+	GetModTime(remotepath string) (*ModTime, error)
+	UpdateModTime(remotepath string, modtime ModTime) error
+	// GetTombstone returns the tombstone of a deleted path, nil if there is none
+	GetTombstone(remotepath string) (*Tombstone, error)
+	PutTombstone(remotepath string, tombstone Tombstone) error
+	RemoveTombstone(remotepath string) error
+	// ExpireTombstones removes all tombstones created before the given time
+	ExpireTombstones(before time.Time) error
+}
+
+// RecordLister is implemented by state caches keeping their records apart from the remote files, like the manifest
+// and the local database, so the records of files removed by other tools can be found
+type RecordLister interface {
+	// Recorded returns the remote paths with a hash or modification time recorded, in order
+	Recorded() ([]string, error)
+	// Forget removes the hash and modification time recorded of a remote path
+	Forget(remotepath string) error
+}
+
+// OpenRemoteStateCache returns the state cache of a binding at the configured location. The cache has to be
+// closed if it implements io.Closer. A read-only local state that has not been imported yet is read from the
+// hidden files on the remote it would be imported from.
+func OpenRemoteStateCache(remote afero.Fs, options Options) (RemoteStateCache, error) {
+	switch options.State {
+	case StateRemote, "":
+		return HashFilesRemotely(remote), nil
+	case StateLocal:
+		if options.StateDB == "" || options.ID == "" {
+			return nil, errors.New("local state requires a state database and a binding ID")
+		}
+		db, err := OpenStateDB(options.StateDB, options.ID, options.ReadOnly)
+		if options.ReadOnly && errors.Is(err, ErrStateNotImported) {
+			return HashFilesRemotely(remote), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if options.ReadOnly {
+			return db, nil
+		}
+		err = db.Import(remote)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	case StateManifest:
+		return NewManifestCache(remote), nil
+	}
+	return nil, fmt.Errorf("unknown state location: %s", options.State)
+}
+
+// CloseRemoteStateCache closes the state cache if it needs to be closed
+func CloseRemoteStateCache(state RemoteStateCache) error {
+	if closer, ok := state.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 type remoteHashFiles struct {
@@ -20,28 +91,153 @@ func HashFilesRemotely(fs afero.Fs) RemoteStateCache {
 	return &remoteHashFiles{fs: fs}
 }
 
-// GetHash implements RemoteStateCache.
-func (instance *remoteHashFiles) GetHash(remotepath string) ([]byte, error) {
-	hashpath := instance.path_hashFile(remotepath)
-	exists, err := afero.Exists(instance.fs, hashpath)
-	if err != nil {
-		return nil, err
+// GetHash implements RemoteStateCache. Hashes recorded by earlier versions are raw md5 sums in ".md5_" files.
+func (instance *remoteHashFiles) GetHash(remotepath string) (digest.Digest, error) {
+	data, err := afero.ReadFile(instance.fs, instance.path_hashFile(remotepath))
+	if err == nil {
+		return digest.ParseString(string(data))
 	}
-	if !exists {
-		return nil, nil
+	if !os.IsNotExist(err) {
+		return digest.Digest{}, err
+	}
+	data, err = afero.ReadFile(instance.fs, instance.path_legacyHashFile(remotepath))
+	if os.IsNotExist(err) {
+		return digest.Digest{}, nil
+	}
+	if err != nil {
+		return digest.Digest{}, err
 	}
-	return afero.ReadFile(instance.fs, hashpath)
+	return digest.Digest{Algorithm: digest.MD5, Sum: data}, nil
 }
 
 // UpdateHash implements RemoteStateCache.
-func (instance *remoteHashFiles) UpdateHash(remotepath string, hash []byte) error {
-	return afero.WriteFile(instance.fs, instance.path_hashFile(remotepath), hash, 0666)
+func (instance *remoteHashFiles) UpdateHash(remotepath string, hash digest.Digest) error {
+	err := afero.WriteFile(instance.fs, instance.path_hashFile(remotepath), []byte(hash.String()), 0666)
+	if err != nil {
+		return err
+	}
+	// the legacy record would be stale after the content has changed
+	err = instance.fs.Remove(instance.path_legacyHashFile(remotepath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
 }
 
 var _ RemoteStateCache = (*remoteHashFiles)(nil)
 
+// SidecarTarget returns the remote path a hidden file of HashFilesRemotely holds the hash or modification time of,
+// false if it is not such a file. Tombstones are not sidecars, the paths they belong to are deleted.
+func SidecarTarget(remotepath string) (string, bool) {
+	prefix, target, ok := metadata.SplitPrefix(path.Base(remotepath))
+	if !ok || !metadata.IsSidecar(prefix) {
+		return "", false
+	}
+	return path.Join(path.Dir(remotepath), target), true
+}
+
 func (instance *remoteHashFiles) path_hashFile(remotepath string) string {
 	fname := path.Base(remotepath)
 	dir := path.Dir(remotepath)
-	return dir + "/.md5_" + fname
+	return dir + "/" + metadata.HashPrefix + fname
+}
+
+func (instance *remoteHashFiles) path_legacyHashFile(remotepath string) string {
+	fname := path.Base(remotepath)
+	dir := path.Dir(remotepath)
+	return dir + "/" + metadata.LegacyHashPrefix + fname
+}
+
+func (instance *remoteHashFiles) path_modTimeFile(remotepath string) string {
+	fname := path.Base(remotepath)
+	dir := path.Dir(remotepath)
+	return dir + "/" + metadata.ModTimePrefix + fname
+}
+
+// GetModTime implements RemoteStateCache.
+func (instance *remoteHashFiles) GetModTime(remotepath string) (*ModTime, error) {
+	data, err := afero.ReadFile(instance.fs, instance.path_modTimeFile(remotepath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	modtime := &ModTime{}
+	err = json.Unmarshal(data, modtime)
+	if err != nil {
+		return nil, err
+	}
+	return modtime, nil
+}
+
+// UpdateModTime implements RemoteStateCache.
+func (instance *remoteHashFiles) UpdateModTime(remotepath string, modtime ModTime) error {
+	data, err := json.Marshal(modtime)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(instance.fs, instance.path_modTimeFile(remotepath), data, 0666)
+}
+
+func (instance *remoteHashFiles) path_tombstoneFile(remotepath string) string {
+	fname := path.Base(remotepath)
+	dir := path.Dir(remotepath)
+	return dir + "/" + metadata.TombstonePrefix + fname
+}
+
+// GetTombstone implements RemoteStateCache.
+func (instance *remoteHashFiles) GetTombstone(remotepath string) (*Tombstone, error) {
+	return instance.readTombstone(instance.path_tombstoneFile(remotepath))
+}
+
+func (instance *remoteHashFiles) readTombstone(tombstonepath string) (*Tombstone, error) {
+	data, err := afero.ReadFile(instance.fs, tombstonepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tombstone := &Tombstone{}
+	err = json.Unmarshal(data, tombstone)
+	if err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+// PutTombstone implements RemoteStateCache.
+func (instance *remoteHashFiles) PutTombstone(remotepath string, tombstone Tombstone) error {
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(instance.fs, instance.path_tombstoneFile(remotepath), data, 0666)
+}
+
+// RemoveTombstone implements RemoteStateCache.
+func (instance *remoteHashFiles) RemoveTombstone(remotepath string) error {
+	err := instance.fs.Remove(instance.path_tombstoneFile(remotepath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ExpireTombstones implements RemoteStateCache.
+func (instance *remoteHashFiles) ExpireTombstones(before time.Time) error {
+	return utils.Walk(instance.fs, "", func(filepath string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasPrefix(info.Name(), metadata.TombstonePrefix) {
+			return err
+		}
+		tombstone, err := instance.readTombstone(filepath)
+		if err != nil {
+			return err
+		}
+		if tombstone != nil && tombstone.Deleted.Before(before) {
+			return instance.fs.Remove(filepath)
+		}
+		return nil
+	})
 }
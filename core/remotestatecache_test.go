@@ -0,0 +1,48 @@
+package core_test
+
+import (
+	"crypto/md5"
+	"strings"
+	"testing"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/spf13/afero"
+)
+
+func TestLegacyHashIsReadAsMD5(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.MkdirAll("dir", 0777)
+	sum := md5.Sum([]byte("a"))
+	afero.WriteFile(fs, "dir/.md5_a.txt", sum[:], 0666)
+	state := core.HashFilesRemotely(fs)
+
+	hash, err := state.GetHash("dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := digest.Digest{Algorithm: digest.MD5, Sum: sum[:]}
+	if !hash.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, hash)
+	}
+
+	updated, _ := digest.Sum(digest.SHA256, strings.NewReader("b"))
+	err = state.UpdateHash("dir/a.txt", updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err = state.GetHash("dir/a.txt")
+	if err != nil || !hash.Equal(updated) {
+		t.Errorf("expected %v, got %v (%v)", updated, hash, err)
+	}
+	if exists, _ := afero.Exists(fs, "dir/.md5_a.txt"); exists {
+		t.Error("stale legacy hash is kept")
+	}
+}
+
+func TestUnknownHashIsZero(t *testing.T) {
+	hash, err := core.HashFilesRemotely(afero.NewMemMapFs()).GetHash("a.txt")
+	if err != nil || !hash.IsZero() {
+		t.Errorf("expected no hash, got %v (%v)", hash, err)
+	}
+}
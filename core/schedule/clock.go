@@ -0,0 +1,28 @@
+package schedule
+
+import "time"
+
+// Timer is a scheduled call that can be cancelled
+type Timer interface {
+	Stop() bool
+}
+
+// Clock is the source of time of a Scheduler, it is replaced in tests
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after the duration elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type systemClock struct{}
+
+// SystemClock is the real time
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,195 @@
+// Package schedule decides when a binding is synchronized
+package schedule
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInterval   = 30 * time.Second
+	DefaultDebounce   = 2 * time.Second
+	DefaultMaxBackoff = 15 * time.Minute
+	DefaultJitter     = 0.2
+)
+
+// Config are the timing parameters of a Scheduler
+type Config struct {
+	// Interval is the time between two synchronizations if nothing happens
+	Interval time.Duration
+	// Debounce is the time waited after a local change before synchronizing, it is restarted by every change
+	Debounce time.Duration
+	// MaxBackoff is the longest time waited after repeated failures, the wait time is doubled after every failure until then.
+	// It is never shorter than Interval.
+	MaxBackoff time.Duration
+	// Jitter is the fraction the wait time after a failure is randomly changed by
+	Jitter float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:   DefaultInterval,
+		Debounce:   DefaultDebounce,
+		MaxBackoff: DefaultMaxBackoff,
+		Jitter:     DefaultJitter,
+	}
+}
+
+// Scheduler runs a synchronization periodically, after local changes, or when requested. Synchronizations never overlap,
+// requests during a synchronization are served once it is finished.
+type Scheduler struct {
+	config Config
+	clock  Clock
+	sync   func() error
+	// Random returns a number in [0,1) to calculate jitter
+	Random func() float64
+
+	lock     sync.Mutex
+	timer    Timer
+	timerID  int
+	regular  time.Time
+	failures int
+	running  bool
+	// requested is the delay of the synchronization requested while running, negative if there was no request
+	requested time.Duration
+	closed    bool
+}
+
+func New(config Config, clock Clock, sync func() error) *Scheduler {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MaxBackoff < config.Interval {
+		config.MaxBackoff = max(DefaultMaxBackoff, config.Interval)
+	}
+	return &Scheduler{
+		config:    config,
+		clock:     clock,
+		sync:      sync,
+		Random:    rand.Float64,
+		requested: -1,
+	}
+}
+
+// Start runs the first synchronization right away
+func (s *Scheduler) Start() {
+	s.SyncNow()
+}
+
+// SyncNow requests a synchronization as soon as possible, even if the previous ones failed
+func (s *Scheduler) SyncNow() {
+	s.request(0)
+}
+
+// Changed reports a local change, a synchronization follows when no more changes are reported for the debounce time.
+// Changes do not shorten the wait time after a failure.
+func (s *Scheduler) Changed() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		return
+	}
+	s.requestLocked(s.config.Debounce)
+}
+
+func (s *Scheduler) request(delay time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requestLocked(delay)
+}
+
+func (s *Scheduler) requestLocked(delay time.Duration) {
+	if s.closed {
+		return
+	}
+	if s.running {
+		if s.requested < 0 || delay < s.requested {
+			s.requested = delay
+		}
+		return
+	}
+	at := s.clock.Now().Add(delay)
+	if !s.regular.IsZero() && at.After(s.regular) {
+		// the regular synchronization comes earlier
+		at = s.regular
+	}
+	s.scheduleLocked(at.Sub(s.clock.Now()))
+}
+
+func (s *Scheduler) scheduleLocked(delay time.Duration) {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timerID++
+	id := s.timerID
+	s.timer = s.clock.AfterFunc(delay, func() {
+		s.run(id)
+	})
+}
+
+func (s *Scheduler) run(id int) {
+	s.lock.Lock()
+	if s.closed || s.running || id != s.timerID {
+		// the timer has been replaced
+		s.lock.Unlock()
+		return
+	}
+	s.running = true
+	s.timer = nil
+	s.lock.Unlock()
+
+	err := s.sync()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running = false
+	if s.closed {
+		return
+	}
+	delay := s.config.Interval
+	if err != nil {
+		s.failures++
+		delay = s.backoff()
+	} else {
+		s.failures = 0
+	}
+	s.regular = s.clock.Now().Add(delay)
+	requested := s.requested
+	s.requested = -1
+	if requested >= 0 && (err == nil || requested == 0) && requested < delay {
+		delay = requested
+	}
+	s.scheduleLocked(delay)
+}
+
+// backoff returns the wait time after the current number of failures
+func (s *Scheduler) backoff() time.Duration {
+	delay := s.config.Interval
+	for i := 1; i < s.failures && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.config.MaxBackoff)
+	if s.config.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + s.config.Jitter*(2*s.Random()-1)))
+	}
+	return delay
+}
+
+// Failures returns the number of failed synchronizations since the last successful one
+func (s *Scheduler) Failures() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.failures
+}
+
+// Close stops scheduling, a running synchronization is not interrupted
+func (s *Scheduler) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package schedule_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/schedule"
+)
+
+// fakeClock runs timers synchronously when the time is advanced
+type fakeClock struct {
+	lock   sync.Mutex
+	start  time.Time
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &fakeClock{start: start, now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) schedule.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// next removes and returns the first timer due until the given time
+func (c *fakeClock) next(until time.Time) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index := -1
+	for i, t := range c.timers {
+		if !t.stopped && !t.at.After(until) && (index < 0 || t.at.Before(c.timers[index].at)) {
+			index = i
+		}
+	}
+	if index < 0 {
+		c.now = until
+		return nil
+	}
+	t := c.timers[index]
+	c.timers = append(c.timers[:index], c.timers[index+1:]...)
+	c.now = t.at
+	return t
+}
+
+// Advance moves the time forward and runs the timers that are due meanwhile
+func (c *fakeClock) Advance(d time.Duration) {
+	until := c.Now().Add(d)
+	for t := c.next(until); t != nil; t = c.next(until) {
+		t.stopped = true
+		t.f()
+	}
+}
+
+// recorder is a synchronization that records the times it has been run at
+type recorder struct {
+	clock *fakeClock
+	runs  []time.Duration
+	err   error
+}
+
+func (r *recorder) sync() error {
+	r.runs = append(r.runs, r.clock.Now().Sub(r.clock.start))
+	return r.err
+}
+
+func (r *recorder) expect(t *testing.T, runs ...time.Duration) {
+	t.Helper()
+	if len(runs) == 0 {
+		runs = nil
+	}
+	if !reflect.DeepEqual(r.runs, runs) {
+		t.Errorf("expected runs at %v, got %v", runs, r.runs)
+	}
+}
+
+const s = time.Second
+
+func newScheduler(config schedule.Config) (*schedule.Scheduler, *fakeClock, *recorder) {
+	clock := newFakeClock()
+	r := &recorder{clock: clock}
+	return schedule.New(config, clock, r.sync), clock, r
+}
+
+func TestRegularInterval(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 30 * s})
+	scheduler.Start()
+	clock.Advance(29 * s)
+	r.expect(t, 0)
+	clock.Advance(61 * s)
+	r.expect(t, 0, 30*s, 60*s, 90*s)
+}
+
+func TestBackoffAfterFailures(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 10 * s, MaxBackoff: 40 * s})
+	r.err = errors.New("offline")
+	scheduler.Start()
+	clock.Advance(110 * s)
+	r.expect(t, 0, 10*s, 30*s, 70*s, 110*s)
+	if scheduler.Failures() != 5 {
+		t.Errorf("expected 5 failures, got %d", scheduler.Failures())
+	}
+
+	r.err = nil
+	clock.Advance(50 * s)
+	r.expect(t, 0, 10*s, 30*s, 70*s, 110*s, 150*s, 160*s)
+	if scheduler.Failures() != 0 {
+		t.Errorf("failures are not reset")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 10 * s, Jitter: 0.5})
+	scheduler.Random = func() float64 { return 0 }
+	r.err = errors.New("offline")
+	scheduler.Start()
+	clock.Advance(15 * s)
+	r.expect(t, 0, 5*s, 15*s)
+}
+
+func TestChangesAreDebounced(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 30 * s, Debounce: 2 * s})
+	scheduler.Start()
+	clock.Advance(5 * s)
+	scheduler.Changed()
+	clock.Advance(1 * s)
+	scheduler.Changed()
+	clock.Advance(1 * s)
+	r.expect(t, 0)
+	clock.Advance(1 * s)
+	r.expect(t, 0, 8*s)
+
+	// continuous changes do not delay the regular synchronization
+	for i := 0; i < 40; i++ {
+		scheduler.Changed()
+		clock.Advance(1 * s)
+	}
+	r.expect(t, 0, 8*s, 38*s)
+}
+
+func TestChangesDoNotInterruptBackoff(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 30 * s, Debounce: 2 * s})
+	r.err = errors.New("offline")
+	scheduler.Start()
+	clock.Advance(0)
+	scheduler.Changed()
+	clock.Advance(10 * s)
+	r.expect(t, 0)
+
+	scheduler.SyncNow()
+	clock.Advance(0)
+	r.expect(t, 0, 10*s)
+}
+
+func TestRequestDuringSynchronization(t *testing.T) {
+	clock := newFakeClock()
+	var runs []time.Duration
+	var scheduler *schedule.Scheduler
+	scheduler = schedule.New(schedule.Config{Interval: 30 * s}, clock, func() error {
+		runs = append(runs, clock.Now().Sub(clock.start))
+		if len(runs) == 2 {
+			scheduler.SyncNow()
+		}
+		return nil
+	})
+	scheduler.Start()
+	clock.Advance(35 * s)
+	if !reflect.DeepEqual(runs, []time.Duration{0, 30 * s, 30 * s}) {
+		t.Errorf("unexpected runs %v", runs)
+	}
+}
+
+func TestClose(t *testing.T) {
+	scheduler, clock, r := newScheduler(schedule.Config{Interval: 30 * s})
+	scheduler.Start()
+	clock.Advance(0)
+	scheduler.Close()
+	scheduler.SyncNow()
+	clock.Advance(60 * s)
+	r.expect(t, 0)
+}
@@ -0,0 +1,402 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/spf13/afero"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StateDBFile is the name of the database of local states, next to the data directories of the bindings
+const StateDBFile = "state.db"
+
+var (
+	bucketHash      = []byte("hash")
+	bucketModTime   = []byte("mtime")
+	bucketTombstone = []byte("tomb")
+	bucketMeta      = []byte("meta")
+	keyImported     = []byte("imported")
+)
+
+// ErrStateNotImported is returned when a binding without state is opened read-only
+var ErrStateNotImported = errors.New("state of the binding has not been imported")
+
+// importBatch is the number of records imported in one transaction, other bindings sharing the database are not
+// blocked while the remote is read
+const importBatch = 1000
+
+// StateDB is a RemoteStateCache kept in an embedded database on the local disk, keyed by binding ID and remote
+// path. Nothing is written to the remote, so the state is not shared with other devices.
+type StateDB struct {
+	db      *sharedDB
+	binding []byte
+}
+
+var _ RemoteStateCache = (*StateDB)(nil)
+var _ RecordLister = (*StateDB)(nil)
+
+// sharedDB is a database opened by the bindings of this process, the file is locked while it is open
+type sharedDB struct {
+	*bolt.DB
+	path string
+	refs int
+}
+
+var stateDBs = struct {
+	sync.Mutex
+	open map[string]*sharedDB
+}{open: make(map[string]*sharedDB)}
+
+// OpenStateDB opens the state of a binding in the database at the given path, which is created if needed. Bindings
+// of the same process share the database. Other processes are locked out while it is open: opening fails after a
+// second if another process has it open, a read-only database can only be opened by other readers too. A read-only
+// state can only be opened if it has been imported before.
+func OpenStateDB(dbpath string, binding string, readonly bool) (*StateDB, error) {
+	db, err := openSharedDB(dbpath, readonly)
+	if err != nil {
+		return nil, err
+	}
+	s := &StateDB{db: db, binding: []byte(binding)}
+	if readonly {
+		err = db.View(func(tx *bolt.Tx) error {
+			meta := s.bucket(tx, bucketMeta)
+			if meta == nil || meta.Get(keyImported) == nil {
+				return ErrStateNotImported
+			}
+			return nil
+		})
+	} else {
+		err = db.Update(func(tx *bolt.Tx) error {
+			root, err := tx.CreateBucketIfNotExists(s.binding)
+			if err != nil {
+				return err
+			}
+			for _, bucket := range [][]byte{bucketHash, bucketModTime, bucketTombstone, bucketMeta} {
+				_, err := root.CreateBucketIfNotExists(bucket)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func openSharedDB(dbpath string, readonly bool) (*sharedDB, error) {
+	stateDBs.Lock()
+	defer stateDBs.Unlock()
+	if db, ok := stateDBs.open[dbpath]; ok {
+		if db.IsReadOnly() && !readonly {
+			return nil, fmt.Errorf("opening %s: it is open read-only", dbpath)
+		}
+		db.refs++
+		return db, nil
+	}
+	if readonly {
+		if _, err := os.Stat(dbpath); os.IsNotExist(err) {
+			return nil, ErrStateNotImported
+		}
+	} else {
+		err := os.MkdirAll(filepath.Dir(dbpath), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(dbpath, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: readonly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("opening %s: it is used by another process, like a running PotatoDrive: %w", dbpath, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dbpath, err)
+	}
+	shared := &sharedDB{DB: db, path: dbpath, refs: 1}
+	stateDBs.open[dbpath] = shared
+	return shared, nil
+}
+
+// Close releases the database, it is closed once no binding of the process uses it
+func (s *StateDB) Close() error {
+	stateDBs.Lock()
+	defer stateDBs.Unlock()
+	s.db.refs--
+	if s.db.refs > 0 {
+		return nil
+	}
+	delete(stateDBs.open, s.db.path)
+	return s.db.DB.Close()
+}
+
+// bucket returns a bucket of the binding, nil if it does not exist
+func (s *StateDB) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	root := tx.Bucket(s.binding)
+	if root == nil {
+		return nil
+	}
+	return root.Bucket(name)
+}
+
+// record is a value of a bucket to be imported
+type record struct {
+	bucket     []byte
+	remotepath string
+	data       []byte
+}
+
+// Import copies the hidden files of HashFilesRemotely into the database, unless it has been done before.
+// The remote is read outside of transactions and written in batches, the import is marked done at the end. An
+// interrupted import is done again by the next call. The files are left on the remote, other devices may still
+// use them.
+func (s *StateDB) Import(remote afero.Fs) error {
+	imported := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		imported = s.bucket(tx, bucketMeta).Get(keyImported) != nil
+		return nil
+	})
+	if err != nil || imported {
+		return err
+	}
+	var batch []record
+	add := func(bucket []byte, remotepath string, data []byte) error {
+		batch = append(batch, record{bucket: bucket, remotepath: remotepath, data: data})
+		if len(batch) < importBatch {
+			return nil
+		}
+		err := s.put(batch)
+		batch = batch[:0]
+		return err
+	}
+	files := &remoteHashFiles{fs: remote}
+	err = utils.Walk(remote, "", func(remotepath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if remotepath == "" {
+			return nil
+		}
+		if info.IsDir() {
+			if metadata.IsReserved(remotepath) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), metadata.TombstonePrefix) {
+			tombstone, err := files.readTombstone(remotepath)
+			if err != nil || tombstone == nil {
+				return err
+			}
+			data, err := json.Marshal(tombstone)
+			if err != nil {
+				return err
+			}
+			return add(bucketTombstone, path.Join(path.Dir(remotepath), strings.TrimPrefix(info.Name(), metadata.TombstonePrefix)), data)
+		}
+		if metadata.IsReserved(remotepath) {
+			return nil
+		}
+		hash, err := files.GetHash(remotepath)
+		if err != nil {
+			return err
+		}
+		if !hash.IsZero() {
+			err = add(bucketHash, remotepath, []byte(hash.String()))
+			if err != nil {
+				return err
+			}
+		}
+		modtime, err := files.GetModTime(remotepath)
+		if err != nil || modtime == nil {
+			return err
+		}
+		data, err := json.Marshal(modtime)
+		if err != nil {
+			return err
+		}
+		return add(bucketModTime, remotepath, data)
+	})
+	if err == nil {
+		err = s.put(batch)
+	}
+	if err != nil {
+		return fmt.Errorf("import state: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.bucket(tx, bucketMeta).Put(keyImported, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// put writes records in one transaction
+func (s *StateDB) put(records []record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, r := range records {
+			err := s.bucket(tx, r.bucket).Put([]byte(r.remotepath), r.data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putJSON(bucket *bolt.Bucket, remotepath string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(remotepath), data)
+}
+
+// getJSON reads a value of the bucket into target, returns false if there is none
+func (s *StateDB) getJSON(bucket []byte, remotepath string, target any) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the slice is only valid within the transaction
+		data = append(data, s.bucket(tx, bucket).Get([]byte(remotepath))...)
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, target)
+}
+
+func (s *StateDB) putJSON(bucket []byte, remotepath string, value any) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(s.bucket(tx, bucket), remotepath, value)
+	})
+}
+
+// GetHash implements RemoteStateCache.
+func (s *StateDB) GetHash(remotepath string) (digest.Digest, error) {
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value = string(s.bucket(tx, bucketHash).Get([]byte(remotepath)))
+		return nil
+	})
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	return digest.ParseString(value)
+}
+
+// UpdateHash implements RemoteStateCache.
+func (s *StateDB) UpdateHash(remotepath string, hash digest.Digest) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.bucket(tx, bucketHash).Put([]byte(remotepath), []byte(hash.String()))
+	})
+}
+
+// GetModTime implements RemoteStateCache.
+func (s *StateDB) GetModTime(remotepath string) (*ModTime, error) {
+	modtime := &ModTime{}
+	found, err := s.getJSON(bucketModTime, remotepath, modtime)
+	if err != nil || !found {
+		return nil, err
+	}
+	return modtime, nil
+}
+
+// UpdateModTime implements RemoteStateCache.
+func (s *StateDB) UpdateModTime(remotepath string, modtime ModTime) error {
+	return s.putJSON(bucketModTime, remotepath, modtime)
+}
+
+// GetTombstone implements RemoteStateCache.
+func (s *StateDB) GetTombstone(remotepath string) (*Tombstone, error) {
+	tombstone := &Tombstone{}
+	found, err := s.getJSON(bucketTombstone, remotepath, tombstone)
+	if err != nil || !found {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+// PutTombstone implements RemoteStateCache.
+func (s *StateDB) PutTombstone(remotepath string, tombstone Tombstone) error {
+	return s.putJSON(bucketTombstone, remotepath, tombstone)
+}
+
+// RemoveTombstone implements RemoteStateCache.
+func (s *StateDB) RemoveTombstone(remotepath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.bucket(tx, bucketTombstone).Delete([]byte(remotepath))
+	})
+}
+
+// ExpireTombstones implements RemoteStateCache.
+func (s *StateDB) ExpireTombstones(before time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := s.bucket(tx, bucketTombstone)
+		var expired [][]byte
+		err := bucket.ForEach(func(key []byte, data []byte) error {
+			tombstone := Tombstone{}
+			err := json.Unmarshal(data, &tombstone)
+			if err == nil && tombstone.Deleted.Before(before) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Recorded implements RecordLister.
+func (s *StateDB) Recorded() ([]string, error) {
+	var recorded []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketHash, bucketModTime} {
+			err := s.bucket(tx, bucket).ForEach(func(key []byte, _ []byte) error {
+				recorded = append(recorded, string(key))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	slices.Sort(recorded)
+	return slices.Compact(recorded), err
+}
+
+// Forget implements RecordLister.
+func (s *StateDB) Forget(remotepath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketHash, bucketModTime} {
+			err := s.bucket(tx, bucket).Delete([]byte(remotepath))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
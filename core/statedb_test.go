@@ -0,0 +1,273 @@
+package core_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/spf13/afero"
+)
+
+func TestLocalStateIsPersisted(t *testing.T) {
+	options := core.Options{ID: "a", StateDB: filepath.Join(t.TempDir(), core.StateDBFile), State: core.StateLocal}
+	remote := afero.NewMemMapFs()
+	state, err := core.OpenRemoteStateCache(remote, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := digest.Sum(digest.SHA256, strings.NewReader("a"))
+	deleted := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	state.UpdateHash("dir/a.txt", hash)
+	state.UpdateModTime("dir/a.txt", core.ModTime{Source: deleted, Remote: deleted, Size: 1})
+	state.PutTombstone("dir/b.txt", core.Tombstone{Deleted: deleted, Device: "a"})
+	err = core.CloseRemoteStateCache(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := afero.ReadDir(remote, "dir"); len(infos) != 0 {
+		t.Errorf("state is written to the remote: %v", infos)
+	}
+
+	state, err = core.OpenRemoteStateCache(remote, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.CloseRemoteStateCache(state)
+	if loaded, err := state.GetHash("dir/a.txt"); err != nil || !loaded.Equal(hash) {
+		t.Errorf("expected hash %v, got %v (%v)", hash, loaded, err)
+	}
+	if modtime, err := state.GetModTime("dir/a.txt"); err != nil || modtime == nil || modtime.Size != 1 {
+		t.Errorf("modification time is not persisted: %v (%v)", modtime, err)
+	}
+	if unknown, err := state.GetHash("dir/b.txt"); err != nil || !unknown.IsZero() {
+		t.Errorf("unknown file has hash %v (%v)", unknown, err)
+	}
+
+	err = state.ExpireTombstones(deleted.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone, err := state.GetTombstone("dir/b.txt"); err != nil || tombstone != nil {
+		t.Errorf("tombstone is not expired: %v (%v)", tombstone, err)
+	}
+}
+
+func TestRemoteStateIsImported(t *testing.T) {
+	remote := afero.NewMemMapFs()
+	remote.MkdirAll("dir", 0777)
+	afero.WriteFile(remote, "dir/a.txt", []byte("a"), 0666)
+	afero.WriteFile(remote, "b.txt", []byte("b"), 0666)
+	files := core.HashFilesRemotely(remote)
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader("a"))
+	files.UpdateHash("dir/a.txt", hash)
+	deleted := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	files.UpdateModTime("b.txt", core.ModTime{Source: deleted, Remote: deleted, Size: 1})
+	files.PutTombstone("dir/c.txt", core.Tombstone{Deleted: deleted, Device: "a"})
+
+	dbpath := filepath.Join(t.TempDir(), core.StateDBFile)
+	db, err := core.OpenStateDB(dbpath, "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Import(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := db.GetHash("dir/a.txt"); !loaded.Equal(hash) {
+		t.Errorf("hash is imported as %v", loaded)
+	}
+	if modtime, _ := db.GetModTime("b.txt"); modtime == nil || !modtime.Source.Equal(deleted) {
+		t.Errorf("modification time is imported as %v", modtime)
+	}
+	if tombstone, _ := db.GetTombstone("dir/c.txt"); tombstone == nil || tombstone.Device != "a" {
+		t.Errorf("tombstone is imported as %v", tombstone)
+	}
+
+	// files written by other devices since are not imported again
+	files.UpdateHash("b.txt", hash)
+	err = db.Import(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := db.GetHash("b.txt"); !loaded.IsZero() {
+		t.Error("state is imported again")
+	}
+}
+
+func TestBindingsShareStateDB(t *testing.T) {
+	dbpath := filepath.Join(t.TempDir(), core.StateDBFile)
+	a, err := core.OpenStateDB(dbpath, "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := core.OpenStateDB(dbpath, "b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader("a"))
+	a.UpdateHash("a.txt", hash)
+	if loaded, _ := b.GetHash("a.txt"); !loaded.IsZero() {
+		t.Errorf("state of another binding is visible: %v", loaded)
+	}
+
+	// the database stays open for the other binding
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := a.GetHash("a.txt"); err != nil || !loaded.Equal(hash) {
+		t.Errorf("expected hash %v, got %v (%v)", hash, loaded, err)
+	}
+	err = a.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnlyLocalState(t *testing.T) {
+	options := core.Options{ID: "a", StateDB: filepath.Join(t.TempDir(), core.StateDBFile), State: core.StateLocal, ReadOnly: true}
+	remote := afero.NewMemMapFs()
+	afero.WriteFile(remote, "a.txt", []byte("a"), 0666)
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader("a"))
+	core.HashFilesRemotely(remote).UpdateHash("a.txt", hash)
+
+	// the state has not been imported yet, the hidden files are read
+	state, err := core.OpenRemoteStateCache(remote, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := state.GetHash("a.txt"); !loaded.Equal(hash) {
+		t.Errorf("expected hash %v, got %v", hash, loaded)
+	}
+	core.CloseRemoteStateCache(state)
+	if _, err := os.Stat(options.StateDB); !os.IsNotExist(err) {
+		t.Errorf("database is created by a reader: %v", err)
+	}
+
+	options.ReadOnly = false
+	state, err = core.OpenRemoteStateCache(remote, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.UpdateHash("b.txt", hash)
+	core.CloseRemoteStateCache(state)
+
+	options.ReadOnly = true
+	state, err = core.OpenRemoteStateCache(remote, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.CloseRemoteStateCache(state)
+	if _, ok := state.(*core.StateDB); !ok {
+		t.Fatalf("imported state is read from %T", state)
+	}
+	if loaded, _ := state.GetHash("b.txt"); !loaded.Equal(hash) {
+		t.Errorf("expected hash %v, got %v", hash, loaded)
+	}
+	if err := state.UpdateHash("c.txt", hash); err == nil {
+		t.Error("read-only state is changed")
+	}
+}
+
+// importingFs runs a function when the first hash file is read, and fails to read the broken file
+type importingFs struct {
+	afero.Fs
+	during func()
+	broken string
+}
+
+func (f *importingFs) Open(name string) (afero.File, error) {
+	if f.broken != "" && path.Base(name) == f.broken {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("broken")}
+	}
+	if f.during != nil && strings.HasPrefix(path.Base(name), metadata.HashPrefix) {
+		during := f.during
+		f.during = nil
+		during()
+	}
+	return f.Fs.Open(name)
+}
+
+func TestImportDoesNotBlockOtherBindings(t *testing.T) {
+	remote := &importingFs{Fs: afero.NewMemMapFs()}
+	afero.WriteFile(remote, "a.txt", []byte("a"), 0666)
+	hash, _ := digest.Sum(digest.MD5, strings.NewReader("a"))
+	core.HashFilesRemotely(remote).UpdateHash("a.txt", hash)
+	dbpath := filepath.Join(t.TempDir(), core.StateDBFile)
+	importing, err := core.OpenStateDB(dbpath, "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer importing.Close()
+	other, err := core.OpenStateDB(dbpath, "b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	remote.during = func() {
+		written := make(chan error, 1)
+		go func() {
+			written <- other.UpdateHash("b.txt", hash)
+		}()
+		select {
+		case err := <-written:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("state of another binding is blocked by the import")
+		}
+	}
+	err = importing.Import(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := importing.GetHash("a.txt"); !loaded.Equal(hash) {
+		t.Errorf("hash is imported as %v", loaded)
+	}
+}
+
+func TestInterruptedImportIsDoneAgain(t *testing.T) {
+	remote := &importingFs{Fs: afero.NewMemMapFs(), broken: metadata.HashPrefix + "b.txt"}
+	files := core.HashFilesRemotely(remote.Fs)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		afero.WriteFile(remote, name, []byte(name), 0666)
+		hash, _ := digest.Sum(digest.MD5, strings.NewReader(name))
+		files.UpdateHash(name, hash)
+	}
+	dbpath := filepath.Join(t.TempDir(), core.StateDBFile)
+	db, err := core.OpenStateDB(dbpath, "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Import(remote)
+	if err == nil {
+		t.Fatal("unreadable hash file is imported")
+	}
+	if _, err := core.OpenStateDB(dbpath, "a", true); !errors.Is(err, core.ErrStateNotImported) {
+		t.Errorf("interrupted import is marked done: %v", err)
+	}
+
+	remote.broken = ""
+	err = db.Import(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		hash, _ := digest.Sum(digest.MD5, strings.NewReader(name))
+		if loaded, _ := db.GetHash(name); !loaded.Equal(hash) {
+			t.Errorf("hash of %s is imported as %v", name, loaded)
+		}
+	}
+}
@@ -1,44 +1,140 @@
 package tasks
 
+import "sync"
+
 type TaskConsumer interface {
 	AddTask(task Task)
 }
 
+// SizedTask is a task that transfers a known amount of data
+type SizedTask interface {
+	Task
+	Size() int64
+}
+
+// TaskExecutor runs queued tasks on a bounded number of workers
 type TaskExecutor struct {
-	tasks    chan Task
-	cancel   chan bool
-	listener TaskStateListener
-	idcount  uint64
+	lock      sync.Mutex
+	cond      *sync.Cond
+	small     []Task
+	large     []Task
+	workers   int
+	largeSize int64
+	// runningLarge is the number of workers busy with a large task
+	runningLarge int
+	closed       bool
+	listener     TaskStateListener
+	idcount      uint64
 }
 
+// NewTaskExecutor creates an executor that runs tasks one by one in the order they were added
 func NewTaskExecutor(listener TaskStateListener) *TaskExecutor {
-	return &TaskExecutor{
-		tasks:    make(chan Task, 1000),
-		cancel:   make(chan bool),
-		listener: listener,
+	return NewTaskPool(listener, 1, 0)
+}
+
+// NewTaskPool creates an executor that runs up to the given number of tasks in parallel.
+// Sized tasks larger than largeSize are run by all but one worker, so small tasks do not
+// have to wait until large ones are finished. Every task is considered small if largeSize is 0.
+func NewTaskPool(listener TaskStateListener, workers int, largeSize int64) *TaskExecutor {
+	e := &TaskExecutor{
+		workers:   max(workers, 1),
+		largeSize: largeSize,
+		listener:  listener,
 	}
+	e.cond = sync.NewCond(&e.lock)
+	return e
 }
 
-// AddTask attempts to insert a task into the executor.
-// May be blocking if task queue is full
+// AddTask inserts a task into the queue of the executor. Tasks added after Close are not run,
+// only their Done method is called with ErrDropped.
 func (e *TaskExecutor) AddTask(task Task) {
-	// TODO check if executor is still running
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		task.Done(ErrDropped)
+		return
+	}
 	state := task.Init(e.idcount)
 	e.idcount++
-	e.tasks <- task
+	if e.isLarge(task) {
+		e.large = append(e.large, task)
+	} else {
+		e.small = append(e.small, task)
+	}
+	e.cond.Signal()
+	e.lock.Unlock()
 	e.listener(state)
 }
 
+func (e *TaskExecutor) isLarge(task Task) bool {
+	sized, ok := task.(SizedTask)
+	return ok && e.largeSize > 0 && sized.Size() > e.largeSize
+}
+
+// Run executes queued tasks until the executor is closed
 func (e *TaskExecutor) Run() {
-	defer close(e.tasks)
+	var wg sync.WaitGroup
+	wg.Add(e.workers)
+	for range e.workers {
+		go func() {
+			defer wg.Done()
+			e.work()
+		}()
+	}
+	wg.Wait()
+}
+
+func (e *TaskExecutor) work() {
 	for {
-		select {
-		case task := <-e.tasks:
-			task.Run(e.listener)
-			task.Done()
-		case <-e.cancel:
-			// TODO stop task loop gracefully
+		task, large := e.next()
+		if task == nil {
 			return
 		}
+		task.Run(e.listener)
+		task.Done(nil)
+		if large {
+			e.lock.Lock()
+			e.runningLarge--
+			e.cond.Broadcast()
+			e.lock.Unlock()
+		}
+	}
+}
+
+// next waits for a task that can be run, returns nil if the executor is closed
+func (e *TaskExecutor) next() (Task, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for {
+		if e.closed {
+			return nil, false
+		}
+		if len(e.small) > 0 {
+			task := e.small[0]
+			e.small = e.small[1:]
+			return task, false
+		}
+		if len(e.large) > 0 && (e.workers == 1 || e.runningLarge < e.workers-1) {
+			task := e.large[0]
+			e.large = e.large[1:]
+			e.runningLarge++
+			return task, true
+		}
+		e.cond.Wait()
+	}
+}
+
+// Close stops the executor. Running tasks are finished, tasks still in the queue are dropped
+// without being run, only their Done method is called with ErrDropped.
+func (e *TaskExecutor) Close() {
+	e.lock.Lock()
+	e.closed = true
+	dropped := append(e.small, e.large...)
+	e.small = nil
+	e.large = nil
+	e.cond.Broadcast()
+	e.lock.Unlock()
+	for _, task := range dropped {
+		task.Done(ErrDropped)
 	}
 }
@@ -0,0 +1,155 @@
+package tasks_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/tasks"
+)
+
+func startPool(t *testing.T, workers int, largeSize int64, listener tasks.TaskStateListener) *tasks.TaskExecutor {
+	pool := tasks.NewTaskPool(listener, workers, largeSize)
+	stopped := make(chan bool)
+	go func() {
+		pool.Run()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		pool.Close()
+		<-stopped
+	})
+	return pool
+}
+
+func ignoreState(tasks.TaskState) {}
+
+func waitFor(t *testing.T, done <-chan bool, message string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal(message)
+	}
+}
+
+func TestTasksRunInParallel(t *testing.T) {
+	pool := startPool(t, 3, 0, ignoreState)
+	var started sync.WaitGroup
+	started.Add(3)
+	release := make(chan bool)
+	finished := make(chan bool, 3)
+	for range 3 {
+		pool.AddTask(tasks.WrapTask(pool, func(tasks.TaskContext) error {
+			started.Done()
+			<-release
+			finished <- true
+			return nil
+		}, 0, "task"))
+	}
+	allstarted := make(chan bool)
+	go func() {
+		started.Wait()
+		close(allstarted)
+	}()
+	waitFor(t, allstarted, "tasks are not running in parallel")
+	close(release)
+	for range 3 {
+		waitFor(t, finished, "tasks did not finish")
+	}
+}
+
+func TestSmallTasksDoNotWaitForLargeOnes(t *testing.T) {
+	pool := startPool(t, 2, 100, ignoreState)
+	release := make(chan bool)
+	defer close(release)
+	largestarted := make(chan bool, 2)
+	for range 2 {
+		pool.AddTask(tasks.WrapSizedTask(pool, func(tasks.TaskContext) error {
+			largestarted <- true
+			<-release
+			return nil
+		}, 0, "large", 1000))
+	}
+	waitFor(t, largestarted, "large task did not start")
+
+	small := make(chan bool)
+	pool.AddTask(tasks.WrapSizedTask(pool, func(tasks.TaskContext) error {
+		close(small)
+		return nil
+	}, 0, "small", 10))
+	waitFor(t, small, "small task is blocked by large tasks")
+
+	select {
+	case <-largestarted:
+		t.Fatal("more large tasks are running than allowed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFailedTaskDoesNotStopOthers(t *testing.T) {
+	var lock sync.Mutex
+	var failed []string
+	pool := startPool(t, 2, 0, func(state tasks.TaskState) {
+		if state.Error != nil {
+			lock.Lock()
+			failed = append(failed, state.Name)
+			lock.Unlock()
+		}
+	})
+	var done sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d"} {
+		done.Add(1)
+		task := tasks.WrapTask(pool, func(tasks.TaskContext) error {
+			if name == "b" {
+				return errors.New("failed")
+			}
+			return nil
+		}, 0, name).(*tasks.WrappedTask)
+		task.OnDone = func(err error) {
+			if (err != nil) != (name == "b") {
+				t.Errorf("task %s is done with %v", name, err)
+			}
+			done.Done()
+		}
+		pool.AddTask(task)
+	}
+	done.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(failed) != 1 || failed[0] != "b" {
+		t.Errorf("unexpected failed tasks: %v", failed)
+	}
+}
+
+func TestDroppedTasksAreDone(t *testing.T) {
+	pool := tasks.NewTaskPool(ignoreState, 2, 0)
+	var done sync.WaitGroup
+	done.Add(2)
+	queued := tasks.WrapSizedTask(pool, func(tasks.TaskContext) error {
+		t.Error("queued task is run after close")
+		return nil
+	}, 0, "queued", 0)
+	queued.OnDone = dropped(t, &done)
+	pool.AddTask(queued)
+	pool.Close()
+
+	late := tasks.WrapSizedTask(pool, func(tasks.TaskContext) error {
+		t.Error("task is run after close")
+		return nil
+	}, 0, "late", 0)
+	late.OnDone = dropped(t, &done)
+	pool.AddTask(late)
+	pool.Run()
+	done.Wait()
+}
+
+func dropped(t *testing.T, done *sync.WaitGroup) func(error) {
+	return func(err error) {
+		if !errors.Is(err, tasks.ErrDropped) {
+			t.Errorf("dropped task is done with %v", err)
+		}
+		done.Done()
+	}
+}
@@ -1,9 +1,15 @@
 package tasks
 
+import "errors"
+
+// ErrDropped is passed to Task.Done if the task has been dropped by a closed executor without being run
+var ErrDropped = errors.New("task is dropped by a closed executor")
+
 type Task interface {
 	Init(ID uint64) TaskState
 	Run(TaskStateListener)
-	Done()
+	// Done is called after the task has been run, or with ErrDropped if it has been dropped
+	Done(err error)
 }
 
 type TaskStateListener func(state TaskState)
@@ -39,7 +45,11 @@ type AtomicTask func(TaskContext) error
 type WrappedTask struct {
 	AtomicTask
 	TaskConsumer
-	State    TaskState
+	State TaskState
+	// OnDone is called with the error of the task when it is finished, or with ErrDropped when it is dropped
+	// by the executor
+	OnDone   func(err error)
+	size     int64
 	listener TaskStateListener
 }
 
@@ -72,8 +82,18 @@ func (wt *WrappedTask) Run(listener TaskStateListener) {
 	}
 }
 
-func (wt *WrappedTask) Done() {
+func (wt *WrappedTask) Done(err error) {
+	if err == nil {
+		err = wt.State.Error
+	}
+	if wt.OnDone != nil {
+		wt.OnDone(err)
+	}
+}
 
+// Size implements SizedTask
+func (wt *WrappedTask) Size() int64 {
+	return wt.size
 }
 
 func AddAtomicTask(taskContext TaskContext, task AtomicTask, typeID int64, name string) {
@@ -90,3 +110,16 @@ func WrapTask(executor TaskConsumer, task AtomicTask, typeID int64, name string)
 		},
 	}
 }
+
+// WrapSizedTask wraps a task that transfers the given amount of data
+func WrapSizedTask(executor TaskConsumer, task AtomicTask, typeID int64, name string, size int64) *WrappedTask {
+	return &WrappedTask{
+		TaskConsumer: executor,
+		AtomicTask:   task,
+		State: TaskState{
+			TypeID: typeID,
+			Name:   name,
+		},
+		size: size,
+	}
+}
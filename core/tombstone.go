@@ -0,0 +1,38 @@
+package core
+
+import (
+	"time"
+)
+
+// DefaultTombstoneRetention is the period tombstones are kept for if not configured otherwise
+const DefaultTombstoneRetention = 30 * 24 * time.Hour
+
+// TombstoneCollectInterval is the minimal time between two garbage collections of tombstones
+const TombstoneCollectInterval = 24 * time.Hour
+
+// Tombstone records the deletion of a remote file or directory, so clients that were offline
+// at the time of the deletion remove their local copy instead of uploading it again.
+type Tombstone struct {
+	Deleted time.Time `json:"deleted"`
+	Device  string    `json:"device"`
+}
+
+// Expired returns true if the tombstone is older than the retention period. Tombstones never expire if retention is 0.
+func (t *Tombstone) Expired(retention time.Duration, now time.Time) bool {
+	return retention > 0 && t.Deleted.Add(retention).Before(now)
+}
+
+// TombstoneCollector removes expired tombstones at most once in every TombstoneCollectInterval
+type TombstoneCollector struct {
+	State     RemoteStateCache
+	Retention time.Duration
+	last      time.Time
+}
+
+func (c *TombstoneCollector) Collect(now time.Time) error {
+	if c.Retention <= 0 || now.Sub(c.last) < TombstoneCollectInterval {
+		return nil
+	}
+	c.last = now
+	return c.State.ExpireTombstones(now.Add(-c.Retention))
+}
@@ -0,0 +1,41 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/spf13/afero"
+)
+
+func TestTombstonesAreCollected(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fs := afero.NewMemMapFs()
+	fs.MkdirAll("dir", 0777)
+	state := core.HashFilesRemotely(fs)
+	state.PutTombstone("old.txt", core.Tombstone{Deleted: now.Add(-48 * time.Hour), Device: "a"})
+	state.PutTombstone("dir/old.txt", core.Tombstone{Deleted: now.Add(-48 * time.Hour), Device: "a"})
+	state.PutTombstone("dir/new.txt", core.Tombstone{Deleted: now.Add(-time.Hour), Device: "b"})
+
+	collector := &core.TombstoneCollector{State: state, Retention: 24 * time.Hour}
+	err := collector.Collect(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, kept := range map[string]bool{"old.txt": false, "dir/old.txt": false, "dir/new.txt": true} {
+		tombstone, err := state.GetTombstone(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (tombstone != nil) != kept {
+			t.Errorf("%s: expected kept=%t, got %v", path, kept, tombstone)
+		}
+	}
+
+	// collection is not repeated within the interval
+	state.PutTombstone("old.txt", core.Tombstone{Deleted: now.Add(-48 * time.Hour), Device: "a"})
+	collector.Collect(now.Add(time.Hour))
+	if tombstone, _ := state.GetTombstone("old.txt"); tombstone == nil {
+		t.Error("collected again within interval")
+	}
+}
@@ -0,0 +1,217 @@
+// Package trash keeps remote files deleted by synchronization in a hidden folder of the remote, so they can be restored
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/spf13/afero"
+)
+
+// Dir is the remote folder deleted files are moved to. Each day of deletions has its own folder in it,
+// deleted files and directories are kept there by their original path with '/' escaped.
+const Dir = metadata.Dir + "/trash"
+
+// DefaultRetention is the period deleted files are kept for if not configured otherwise
+const DefaultRetention = 30 * 24 * time.Hour
+
+// PurgeInterval is the minimal time between two purges of the trash
+const PurgeInterval = time.Hour
+
+const dayLayout = "2006-01-02"
+
+// Policy limits the content of the trash, the zero value keeps everything
+type Policy struct {
+	// Retention is the period deleted files are kept for, unlimited if 0
+	Retention time.Duration
+	// MaxSize is the maximum total size of the trash in bytes, unlimited if 0
+	MaxSize int64
+}
+
+// Item is a file or directory deleted from the remote
+type Item struct {
+	// Path identifies the item in the trash, like "2024-01-02/docs%2Fa.txt"
+	Path string `json:"path"`
+	// Original is the remote path the item was deleted from
+	Original string `json:"original"`
+	// Deleted is the day of the deletion
+	Deleted time.Time `json:"deleted"`
+	// Size is the total size of the files of the item
+	Size int64 `json:"size"`
+	Dir  bool  `json:"dir,omitempty"`
+}
+
+// Trash of a remote file system
+type Trash struct {
+	fs     afero.Fs
+	policy Policy
+	lock   sync.Mutex
+	last   time.Time
+}
+
+func New(fs afero.Fs, policy Policy) *Trash {
+	return &Trash{fs: fs, policy: policy}
+}
+
+// Move moves a remote file or directory to the folder of the day in the trash and returns its path in the trash.
+// Repeated deletions of the same path on the same day are kept in separate folders, like "2024-01-02-2".
+func (t *Trash) Move(remotepath string, now time.Time) (string, error) {
+	day := now.UTC().Format(dayLayout)
+	name := url.PathEscape(remotepath)
+	for i := 1; ; i++ {
+		folder := day
+		if i > 1 {
+			folder = fmt.Sprintf("%s-%d", day, i)
+		}
+		item := path.Join(folder, name)
+		exists, err := afero.Exists(t.fs, path.Join(Dir, item))
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return item, utils.Move(t.fs, remotepath, path.Join(Dir, item))
+		}
+	}
+}
+
+// List returns the items of the trash, oldest first
+func (t *Trash) List() ([]Item, error) {
+	folders, err := t.readDir(Dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []Item
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+		day, err := time.Parse(dayLayout, folder.Name()[:min(len(folder.Name()), len(dayLayout))])
+		if err != nil {
+			// not created by the trash
+			continue
+		}
+		entries, err := t.readDir(path.Join(Dir, folder.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			original, err := url.PathUnescape(entry.Name())
+			if err != nil {
+				continue
+			}
+			item := Item{
+				Path:     path.Join(folder.Name(), entry.Name()),
+				Original: original,
+				Deleted:  day,
+				Size:     entry.Size(),
+				Dir:      entry.IsDir(),
+			}
+			if item.Dir {
+				item.Size, err = t.size(path.Join(Dir, item.Path))
+				if err != nil {
+					return nil, err
+				}
+			}
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// readDir returns the entries of a remote folder sorted by name, nothing if it does not exist
+func (t *Trash) readDir(dir string) ([]fs.FileInfo, error) {
+	entries, err := afero.ReadDir(t.fs, dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+func (t *Trash) size(dir string) (int64, error) {
+	var size int64
+	err := utils.Walk(t.fs, dir, func(_ string, info fs.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return err
+	})
+	return size, err
+}
+
+// Restore moves an item of the trash back to its original path, which must not exist
+func (t *Trash) Restore(item string) (string, error) {
+	original, err := url.PathUnescape(path.Base(item))
+	if err != nil {
+		return "", err
+	}
+	exists, err := afero.Exists(t.fs, original)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("%s already exists", original)
+	}
+	err = utils.Move(t.fs, path.Join(Dir, item), original)
+	if err != nil {
+		return "", err
+	}
+	t.removeEmpty(path.Dir(item))
+	return original, nil
+}
+
+// Purge removes the items older than the retention period, then the oldest ones until the rest fits into
+// the size limit. It does nothing if the trash has been purged within PurgeInterval.
+func (t *Trash) Purge(now time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if (t.policy.Retention <= 0 && t.policy.MaxSize <= 0) || now.Sub(t.last) < PurgeInterval {
+		return nil
+	}
+	t.last = now
+	items, err := t.List()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	var errs []error
+	folders := make(map[string]bool)
+	for _, item := range items {
+		// the whole day is kept for the retention period
+		expired := t.policy.Retention > 0 && now.Sub(item.Deleted.Add(24*time.Hour)) > t.policy.Retention
+		oversize := t.policy.MaxSize > 0 && total > t.policy.MaxSize
+		if !expired && !oversize {
+			// all remaining items are newer
+			break
+		}
+		err = t.fs.RemoveAll(path.Join(Dir, item.Path))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		total -= item.Size
+		folders[path.Dir(item.Path)] = true
+	}
+	for folder := range folders {
+		t.removeEmpty(folder)
+	}
+	return errors.Join(errs...)
+}
+
+// removeEmpty removes a folder of the trash if it has no items left
+func (t *Trash) removeEmpty(folder string) {
+	dir := path.Join(Dir, folder)
+	if entries, err := afero.ReadDir(t.fs, dir); err == nil && len(entries) == 0 {
+		t.fs.Remove(dir)
+	}
+}
@@ -0,0 +1,138 @@
+package trash_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/trash"
+	"github.com/spf13/afero"
+)
+
+var day1 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newRemote(t *testing.T) afero.Fs {
+	// MemMapFs does not rename directory contents
+	fs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	fs.MkdirAll("docs/sub", 0777)
+	afero.WriteFile(fs, "docs/a.txt", []byte("aaaa"), 0666)
+	afero.WriteFile(fs, "docs/sub/b.txt", []byte("bb"), 0666)
+	afero.WriteFile(fs, "c.txt", []byte("c"), 0666)
+	return fs
+}
+
+func list(t *testing.T, tr *trash.Trash) []trash.Item {
+	t.Helper()
+	items, err := tr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestDeletedItemIsRestored(t *testing.T) {
+	fs := newRemote(t)
+	tr := trash.New(fs, trash.Policy{})
+	item, err := tr.Move("docs/sub", day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(fs, "docs/sub/b.txt"); exists {
+		t.Error("deleted directory is still there")
+	}
+
+	items := list(t, tr)
+	expected := trash.Item{Path: item, Original: "docs/sub", Deleted: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Size: 2, Dir: true}
+	if len(items) != 1 || items[0] != expected {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+
+	original, err := tr.Restore(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original != "docs/sub" {
+		t.Errorf("restored to %s", original)
+	}
+	if data, _ := afero.ReadFile(fs, "docs/sub/b.txt"); string(data) != "bb" {
+		t.Error("content is not restored")
+	}
+	if len(list(t, tr)) != 0 {
+		t.Error("restored item is left in the trash")
+	}
+}
+
+func TestRestoreDoesNotOverwrite(t *testing.T) {
+	fs := newRemote(t)
+	tr := trash.New(fs, trash.Policy{})
+	item, err := tr.Move("c.txt", day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afero.WriteFile(fs, "c.txt", []byte("new"), 0666)
+	_, err = tr.Restore(item)
+	if err == nil {
+		t.Error("existing file is overwritten")
+	}
+}
+
+func TestRepeatedDeletionsAreKept(t *testing.T) {
+	fs := newRemote(t)
+	tr := trash.New(fs, trash.Policy{})
+	tr.Move("c.txt", day1)
+	afero.WriteFile(fs, "c.txt", []byte("cc"), 0666)
+	tr.Move("c.txt", day1.Add(time.Hour))
+
+	items := list(t, tr)
+	if len(items) != 2 || items[0].Size != 1 || items[1].Size != 2 {
+		t.Fatalf("expected both versions, got %v", items)
+	}
+	for _, item := range items {
+		if item.Original != "c.txt" {
+			t.Errorf("%s is deleted from %s", item.Path, item.Original)
+		}
+	}
+}
+
+func TestPurgeByRetention(t *testing.T) {
+	fs := newRemote(t)
+	tr := trash.New(fs, trash.Policy{Retention: 48 * time.Hour})
+	tr.Move("docs", day1)
+	tr.Move("c.txt", day1.Add(48*time.Hour))
+
+	err := tr.Purge(day1.Add(72 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := list(t, tr)
+	if len(items) != 1 || items[0].Original != "c.txt" {
+		t.Errorf("expected only c.txt to be kept, got %v", items)
+	}
+	if exists, _ := afero.DirExists(fs, trash.Dir+"/2024-01-01"); exists {
+		t.Error("empty day is kept")
+	}
+}
+
+func TestPurgeBySize(t *testing.T) {
+	fs := newRemote(t)
+	tr := trash.New(fs, trash.Policy{MaxSize: 3})
+	tr.Move("docs/a.txt", day1)
+	tr.Move("docs/sub", day1.Add(24*time.Hour))
+	tr.Move("c.txt", day1.Add(48*time.Hour))
+
+	err := tr.Purge(day1.Add(48 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := list(t, tr)
+	if len(items) != 2 || items[0].Original != "docs/sub" || items[1].Original != "c.txt" {
+		t.Errorf("expected the oldest item to be removed, got %v", items)
+	}
+
+	// purging is not repeated within the interval
+	afero.WriteFile(fs, "c.txt", []byte("cccc"), 0666)
+	tr.Move("c.txt", day1.Add(48*time.Hour))
+	tr.Purge(day1.Add(48*time.Hour + time.Minute))
+	if len(list(t, tr)) != 3 {
+		t.Error("purged again within interval")
+	}
+}
@@ -0,0 +1,131 @@
+package upload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// Checkpoint is the progress of an upload that has not been finished yet
+type Checkpoint struct {
+	// Size and ModTime identify the version of the local file being uploaded
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+	// Offset is the number of bytes acknowledged by the remote
+	Offset int64 `json:"offset"`
+	// Session identifies a native upload of the remote, like an S3 multipart upload
+	Session string `json:"session,omitempty"`
+}
+
+// Store keeps the checkpoints of unfinished uploads by remote path
+type Store interface {
+	Get(name string) (Checkpoint, bool)
+	Put(name string, checkpoint Checkpoint) error
+	Remove(name string) error
+}
+
+type memoryStore struct {
+	lock        sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewStore creates a store that keeps checkpoints in memory only
+func NewStore() Store {
+	return &memoryStore{
+		checkpoints: make(map[string]Checkpoint),
+	}
+}
+
+func (s *memoryStore) Get(name string) (Checkpoint, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	checkpoint, ok := s.checkpoints[name]
+	return checkpoint, ok
+}
+
+func (s *memoryStore) Put(name string, checkpoint Checkpoint) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.checkpoints[name] = checkpoint
+	return nil
+}
+
+func (s *memoryStore) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.checkpoints, name)
+	return nil
+}
+
+// dirStore keeps each checkpoint in a separate file, so uploads running in parallel do not rewrite each others state
+type dirStore struct {
+	fs  afero.Fs
+	dir string
+}
+
+type storedCheckpoint struct {
+	Name string `json:"name"`
+	Checkpoint
+}
+
+// Open returns a store that keeps checkpoints in the given folder of the file system
+func Open(fs afero.Fs, dir string) Store {
+	return &dirStore{fs: fs, dir: dir}
+}
+
+// OpenDir returns the store kept in the given data folder, or an in-memory one if no folder is given
+func OpenDir(datadir string) Store {
+	if datadir == "" {
+		return NewStore()
+	}
+	return Open(afero.NewOsFs(), filepath.Join(datadir, "uploads"))
+}
+
+func (s *dirStore) filename(name string) string {
+	hash := md5.Sum([]byte(name))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+func (s *dirStore) Get(name string) (Checkpoint, bool) {
+	data, err := afero.ReadFile(s.fs, s.filename(name))
+	if err != nil {
+		return Checkpoint{}, false
+	}
+	var stored storedCheckpoint
+	err = json.Unmarshal(data, &stored)
+	if err != nil || stored.Name != name {
+		return Checkpoint{}, false
+	}
+	return stored.Checkpoint, true
+}
+
+func (s *dirStore) Put(name string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(storedCheckpoint{Name: name, Checkpoint: checkpoint})
+	if err != nil {
+		return err
+	}
+	err = s.fs.MkdirAll(s.dir, 0777)
+	if err != nil {
+		return err
+	}
+	filename := s.filename(name)
+	tmpfile := filename + ".tmp"
+	err = afero.WriteFile(s.fs, tmpfile, data, 0666)
+	if err != nil {
+		return err
+	}
+	return s.fs.Rename(tmpfile, filename)
+}
+
+func (s *dirStore) Remove(name string) error {
+	err := s.fs.Remove(s.filename(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
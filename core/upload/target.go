@@ -0,0 +1,151 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+// Target receives the content of a remote file being uploaded
+type Target interface {
+	// Write appends data to the content uploaded so far
+	io.Writer
+	// Offset is the number of bytes acknowledged by the remote, an interrupted upload continues from here
+	Offset() int64
+	// Session identifies the native upload of the remote, it is empty if the offset is enough to continue
+	Session() string
+	// Commit finishes the upload, the remote file has exactly the written content afterwards
+	Commit() error
+	// Close releases the target without finishing the upload, so it can be continued later
+	Close() error
+}
+
+// Resumer is implemented by remote file systems with native support for resumable uploads
+type Resumer interface {
+	// ResumeUpload continues the upload of the checkpoint, or starts a new one if it can not be continued
+	ResumeUpload(name string, checkpoint Checkpoint) (Target, error)
+	// AbortUpload drops the data of an upload that will not be continued
+	AbortUpload(name string, checkpoint Checkpoint) error
+}
+
+// TemporaryPrefix starts the names of hidden files uploads are written to before they are renamed to the target
+const TemporaryPrefix = metadata.TemporaryPrefix
+
+// TemporaryRetention is the time after an untouched temporary file is considered to be left by a crashed upload
+const TemporaryRetention = 24 * time.Hour
+
+// IsTemporary returns true if the remote path is a temporary file of an upload
+func IsTemporary(name string) bool {
+	return strings.HasPrefix(path.Base(name), TemporaryPrefix)
+}
+
+// OpenTarget continues the upload of the checkpoint, or starts a new one. Remote file systems implementing
+// Resumer are used natively. Others are written to a temporary file next to the target, which is renamed
+// over it once the whole content is written, so readers never see a partial file. The temporary file of
+// the checkpoint is continued from its offset if it is long enough.
+func OpenTarget(fs afero.Fs, name string, checkpoint Checkpoint) (Target, error) {
+	if resumer, ok := fs.(Resumer); ok {
+		return resumer.ResumeUpload(name, checkpoint)
+	}
+	if IsTemporary(checkpoint.Session) && checkpoint.Offset > 0 {
+		target, err := resumeFile(fs, name, checkpoint)
+		if err == nil {
+			return target, nil
+		}
+		fs.Remove(checkpoint.Session)
+	}
+	temp := path.Join(path.Dir(name), TemporaryPrefix+uuid.NewString())
+	file, err := fs.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &fileTarget{fs: fs, name: name, temp: temp, file: file}, nil
+}
+
+func resumeFile(fs afero.Fs, name string, checkpoint Checkpoint) (Target, error) {
+	file, err := fs.OpenFile(checkpoint.Session, os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() < checkpoint.Offset {
+		err = errors.New("temporary file is shorter than the checkpoint")
+	}
+	if err == nil {
+		_, err = file.Seek(checkpoint.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileTarget{fs: fs, name: name, temp: checkpoint.Session, file: file, offset: checkpoint.Offset}, nil
+}
+
+// AbortTarget drops the data of an upload that will not be continued
+func AbortTarget(fs afero.Fs, name string, checkpoint Checkpoint) error {
+	if resumer, ok := fs.(Resumer); ok {
+		return resumer.AbortUpload(name, checkpoint)
+	}
+	if IsTemporary(checkpoint.Session) {
+		return fs.Remove(checkpoint.Session)
+	}
+	return nil
+}
+
+// fileTarget writes a temporary file and renames it to the target
+type fileTarget struct {
+	fs     afero.Fs
+	name   string
+	temp   string
+	file   afero.File
+	offset int64
+}
+
+func (t *fileTarget) Write(p []byte) (int, error) {
+	n, err := t.file.Write(p)
+	t.offset += int64(n)
+	return n, err
+}
+
+func (t *fileTarget) Offset() int64 {
+	return t.offset
+}
+
+func (t *fileTarget) Session() string {
+	return t.temp
+}
+
+func (t *fileTarget) Commit() error {
+	// a continued upload may have written past the checkpoint before
+	info, err := t.file.Stat()
+	if err == nil && info.Size() > t.offset {
+		err = t.file.Truncate(t.offset)
+		if err != nil {
+			t.file.Close()
+			return err
+		}
+	}
+	err = t.file.Close()
+	if err != nil {
+		return err
+	}
+	err = t.fs.Rename(t.temp, t.name)
+	if err != nil {
+		// some remotes can not rename over an existing file
+		if _, staterr := t.fs.Stat(t.name); staterr == nil && t.fs.Remove(t.name) == nil {
+			err = t.fs.Rename(t.temp, t.name)
+		}
+	}
+	return err
+}
+
+func (t *fileTarget) Close() error {
+	return t.file.Close()
+}
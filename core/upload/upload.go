@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/spf13/afero"
+)
+
+// ChunkSize is the amount of data uploaded between two checkpoints
+const ChunkSize = 1024 * 1024
+
+// Uploader copies local files to the remote. A checkpoint is recorded after each chunk,
+// interrupted uploads of the same local version continue from the last acknowledged offset.
+type Uploader struct {
+	Remote      afero.Fs
+	Checkpoints Store
+	// Versions saves the previous content of the remote file before it is replaced, it may be nil
+	Versions Keeper
+	// Hash is the algorithm of the returned content hash, defaults to digest.Default
+	Hash digest.Algorithm
+}
+
+// ErrChecksumMismatch is returned if the checksum calculated by the remote differs from the uploaded content
+var ErrChecksumMismatch = errors.New("checksum of the remote file does not match the uploaded content")
+
+// Keeper saves the content of a remote file before it is replaced by an upload
+type Keeper interface {
+	Keep(name string) error
+}
+
+// Upload copies the content of the local file to the remote file name and returns its content hash.
+// Progress is called after each chunk with the percentage of data uploaded, it may be nil.
+func (u *Uploader) Upload(local afero.File, name string, progress func(percent int)) (digest.Digest, error) {
+	info, err := local.Stat()
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	version := Checkpoint{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+
+	checkpoint, ok := u.Checkpoints.Get(name)
+	if ok && (checkpoint.Size != version.Size || checkpoint.ModTime != version.ModTime) {
+		// the local file has been changed since, the partial upload is useless
+		AbortTarget(u.Remote, name, checkpoint)
+		checkpoint = version
+	}
+	if !ok {
+		checkpoint = version
+	}
+
+	target, err := OpenTarget(u.Remote, name, checkpoint)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if target.Offset() > version.Size {
+		// the remote has acknowledged more than the local file holds, start over
+		target.Close()
+		AbortTarget(u.Remote, name, checkpoint)
+		target, err = OpenTarget(u.Remote, name, version)
+		if err != nil {
+			return digest.Digest{}, err
+		}
+	}
+	hash, err := u.copy(local, target, version, name, progress)
+	if err == nil && u.Versions != nil {
+		err = u.Versions.Keep(name)
+	}
+	if err != nil {
+		target.Close()
+		return digest.Digest{}, err
+	}
+	err = target.Commit()
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	err = u.Checkpoints.Remove(name)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	err = u.verify(name, hash)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	return hash, nil
+}
+
+// verify compares the hash of the uploaded content to the checksum calculated by the remote, if it can
+func (u *Uploader) verify(name string, hash digest.Digest) error {
+	native, err := digest.Native(u.Remote, name, hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	if !native.IsZero() && !native.Equal(hash) {
+		return fmt.Errorf("%s: %w", name, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// copy writes the local file to the target from its offset and returns the hash of the whole content
+func (u *Uploader) copy(local afero.File, target Target, version Checkpoint, name string, progress func(int)) (digest.Digest, error) {
+	offset := target.Offset()
+	// the part uploaded before is not read back from the remote
+	hash := u.Hash.New()
+	if hash == nil {
+		return digest.Digest{}, fmt.Errorf("unsupported hash algorithm: %s", u.Hash)
+	}
+	_, err := io.Copy(hash, io.NewSectionReader(local, 0, offset))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	_, err = local.Seek(offset, io.SeekStart)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	checkpoint := version
+	checkpoint.Offset = offset
+	checkpoint.Session = target.Session()
+	if checkpoint.Session != "" {
+		// remember the native upload right away, so it can be continued or aborted later
+		err = u.Checkpoints.Put(name, checkpoint)
+		if err != nil {
+			return digest.Digest{}, err
+		}
+	}
+
+	data := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(local, data)
+		if err == io.EOF {
+			return digest.Of(u.Hash, hash), nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return digest.Digest{}, err
+		}
+		hash.Write(data[:n])
+		_, err = target.Write(data[:n])
+		if err != nil {
+			return digest.Digest{}, err
+		}
+		if target.Offset() != checkpoint.Offset || target.Session() != checkpoint.Session {
+			checkpoint.Offset = target.Offset()
+			checkpoint.Session = target.Session()
+			err = u.Checkpoints.Put(name, checkpoint)
+			if err != nil {
+				return digest.Digest{}, err
+			}
+		}
+		if progress != nil && version.Size > 0 {
+			offset += int64(n)
+			progress(int(100 * offset / version.Size))
+		}
+	}
+}
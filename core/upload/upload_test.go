@@ -0,0 +1,274 @@
+package upload_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/digest"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+var errCut = errors.New("connection lost")
+
+// faultyFs cuts the stream of written data after a number of bytes, a negative limit never cuts
+type faultyFs struct {
+	afero.Fs
+	limit   int64
+	written int64
+}
+
+func (f *faultyFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, fs: f}, nil
+}
+
+type faultyFile struct {
+	afero.File
+	fs *faultyFs
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.fs.limit < 0 {
+		n, err := f.File.Write(p)
+		f.fs.written += int64(n)
+		return n, err
+	}
+	if int64(len(p)) <= f.fs.limit {
+		n, err := f.File.Write(p)
+		f.fs.written += int64(n)
+		f.fs.limit -= int64(n)
+		return n, err
+	}
+	n, _ := f.File.Write(p[:f.fs.limit])
+	f.fs.written += int64(n)
+	f.fs.limit = 0
+	return n, errCut
+}
+
+type testEnv struct {
+	t        *testing.T
+	local    afero.Fs
+	remote   *faultyFs
+	uploader *upload.Uploader
+	content  []byte
+}
+
+func newTestEnv(t *testing.T, size int) *testEnv {
+	e := &testEnv{
+		t:      t,
+		local:  afero.NewMemMapFs(),
+		remote: &faultyFs{Fs: afero.NewMemMapFs(), limit: -1},
+	}
+	e.uploader = &upload.Uploader{Remote: e.remote, Checkpoints: upload.Open(afero.NewMemMapFs(), "uploads")}
+	e.writeLocal(size, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	return e
+}
+
+func (e *testEnv) writeLocal(size int, modtime time.Time) {
+	e.content = make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(e.content)
+	err := afero.WriteFile(e.local, "file.bin", e.content, 0666)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	err = e.local.Chtimes("file.bin", modtime, modtime)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *testEnv) upload() (digest.Digest, error) {
+	file, err := e.local.Open("file.bin")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer file.Close()
+	return e.uploader.Upload(file, "file.bin", nil)
+}
+
+func (e *testEnv) uploaded() {
+	e.t.Helper()
+	hash, err := e.upload()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	expected, _ := digest.Sum(e.uploader.Hash, bytes.NewReader(e.content))
+	if !hash.Equal(expected) {
+		e.t.Errorf("wrong hash is returned: %v", hash)
+	}
+	data, err := afero.ReadFile(e.remote, "file.bin")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if !bytes.Equal(data, e.content) {
+		e.t.Errorf("remote content differs, %d bytes instead of %d", len(data), len(e.content))
+	}
+	if _, ok := e.uploader.Checkpoints.Get("file.bin"); ok {
+		e.t.Error("checkpoint is kept after the upload is finished")
+	}
+	e.noTemporaryFiles()
+}
+
+func (e *testEnv) noTemporaryFiles() {
+	e.t.Helper()
+	infos, err := afero.ReadDir(e.remote, "")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	for _, info := range infos {
+		if upload.IsTemporary(info.Name()) {
+			e.t.Errorf("temporary file %s is left", info.Name())
+		}
+	}
+}
+
+func TestInterruptedUploadIsResumed(t *testing.T) {
+	size := 5*upload.ChunkSize + upload.ChunkSize/2
+	env := newTestEnv(t, size)
+	env.remote.limit = 3*upload.ChunkSize + 100
+
+	_, err := env.upload()
+	if !errors.Is(err, errCut) {
+		t.Fatalf("expected cut upload, got %v", err)
+	}
+	checkpoint, ok := env.uploader.Checkpoints.Get("file.bin")
+	if !ok || checkpoint.Offset != 3*upload.ChunkSize {
+		t.Fatalf("expected checkpoint at 3 chunks, got %v", checkpoint)
+	}
+	if exists, _ := afero.Exists(env.remote, "file.bin"); exists {
+		t.Error("partial upload is visible at the target")
+	}
+
+	env.remote.limit = -1
+	env.remote.written = 0
+	env.uploaded()
+	if env.remote.written != int64(size-3*upload.ChunkSize) {
+		t.Errorf("resumed upload wrote %d bytes", env.remote.written)
+	}
+}
+
+func TestChangedFileIsUploadedFromStart(t *testing.T) {
+	env := newTestEnv(t, 4*upload.ChunkSize)
+	env.remote.limit = 2 * upload.ChunkSize
+	_, err := env.upload()
+	if err == nil {
+		t.Fatal("expected cut upload")
+	}
+
+	env.writeLocal(3*upload.ChunkSize, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC))
+	env.remote.limit = -1
+	env.remote.written = 0
+	env.uploaded()
+	if env.remote.written != int64(3*upload.ChunkSize) {
+		t.Errorf("upload of changed file wrote %d bytes", env.remote.written)
+	}
+}
+
+func TestTargetIsReplacedAtOnce(t *testing.T) {
+	env := newTestEnv(t, 2*upload.ChunkSize)
+	previous := []byte("previous version")
+	err := afero.WriteFile(env.remote, "file.bin", previous, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.remote.limit = upload.ChunkSize + 10
+	_, err = env.upload()
+	if err == nil {
+		t.Fatal("expected cut upload")
+	}
+	data, err := afero.ReadFile(env.remote, "file.bin")
+	if err != nil || !bytes.Equal(data, previous) {
+		t.Errorf("previous version is changed by a partial upload: %v", err)
+	}
+	env.remote.limit = -1
+	env.uploaded()
+}
+
+func TestShorterVersionReplacesRemoteContent(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	err := afero.WriteFile(env.remote, "file.bin", make([]byte, 5000), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.uploaded()
+}
+
+func TestHashOfResumedUpload(t *testing.T) {
+	env := newTestEnv(t, 3*upload.ChunkSize)
+	env.uploader.Hash = digest.SHA256
+	env.remote.limit = upload.ChunkSize + 10
+	_, err := env.upload()
+	if err == nil {
+		t.Fatal("expected cut upload")
+	}
+	env.remote.limit = -1
+	env.uploaded()
+}
+
+func TestEmptyFile(t *testing.T) {
+	env := newTestEnv(t, 0)
+	env.uploaded()
+}
+
+// checksummingFs calculates checksums like a remote storing the content it has received, or a corrupted version
+type checksummingFs struct {
+	*faultyFs
+	corrupt bool
+}
+
+func (c *checksummingFs) Checksum(name string, algorithm digest.Algorithm) (digest.Digest, error) {
+	data, err := afero.ReadFile(c.faultyFs, name)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if c.corrupt {
+		data[0]++
+	}
+	return digest.Sum(algorithm, bytes.NewReader(data))
+}
+
+func TestUploadIsVerifiedByRemoteChecksum(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	remote := &checksummingFs{faultyFs: env.remote}
+	env.uploader.Remote = remote
+	env.uploaded()
+
+	remote.corrupt = true
+	_, err := env.upload()
+	if !errors.Is(err, upload.ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestCheckpointsArePersisted(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	checkpoint := upload.Checkpoint{Size: 10, ModTime: 20, Offset: 5, Session: "id"}
+	err := upload.Open(fs, "uploads").Put("a/b.txt", checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := upload.Open(fs, "uploads")
+	loaded, ok := store.Get("a/b.txt")
+	if !ok || loaded != checkpoint {
+		t.Errorf("expected %v, got %v", checkpoint, loaded)
+	}
+	if _, ok := store.Get("a/c.txt"); ok {
+		t.Error("unknown checkpoint is found")
+	}
+	err = store.Remove("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("a/b.txt"); ok {
+		t.Error("removed checkpoint is found")
+	}
+}
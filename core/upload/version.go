@@ -0,0 +1,38 @@
+package upload
+
+import (
+	"errors"
+
+	"github.com/spf13/afero"
+)
+
+// ErrVersionChanged is returned by a conditional write if the file has been changed since it was read
+var ErrVersionChanged = errors.New("file has been changed since it was read")
+
+// Versioner is implemented by remote file systems that can replace a file only if it has not been changed since it
+// was read, like S3 by the ETag of the object
+type Versioner interface {
+	// ReadVersion returns the content of a file with its version
+	ReadVersion(name string) ([]byte, string, error)
+	// WriteVersion replaces the file if its version is still the given one, or creates it if the version is empty
+	// and the file does not exist. ErrVersionChanged is returned otherwise.
+	WriteVersion(name string, data []byte, version string) error
+}
+
+// ReadVersion reads a file with its version, the version is empty if the file system does not support versions
+func ReadVersion(fs afero.Fs, name string) ([]byte, string, error) {
+	if versioner, ok := fs.(Versioner); ok {
+		return versioner.ReadVersion(name)
+	}
+	data, err := afero.ReadFile(fs, name)
+	return data, "", err
+}
+
+// WriteVersion replaces a file if its version is still the given one, see Versioner. errors.ErrUnsupported is
+// returned if the file system does not support versions.
+func WriteVersion(fs afero.Fs, name string, data []byte, version string) error {
+	if versioner, ok := fs.(Versioner); ok {
+		return versioner.WriteVersion(name, data, version)
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,223 @@
+// Package versions keeps the previous content of remote files replaced by uploads in a hidden folder of the remote
+package versions
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/bindings/utils"
+	"github.com/balazsgrill/potatodrive/core/metadata"
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/spf13/afero"
+)
+
+// Dir is the remote folder previous versions are kept in, by the path of the file and the time it was replaced
+// like ".potatodrive/versions/docs/a.txt/2024-01-02T150405.000Z"
+const Dir = metadata.Dir + "/versions"
+
+// PurgeInterval is the minimal time between two purges of all versions
+const PurgeInterval = 24 * time.Hour
+
+const timeLayout = "2006-01-02T150405.000Z"
+
+// Policy decides which versions are kept, versioning is disabled by the zero value
+type Policy struct {
+	// Count is the number of the most recent versions kept of each file
+	Count int
+	// MaxAge is the period versions are kept for, regardless of their number
+	MaxAge time.Duration
+}
+
+// Enabled returns true if previous versions are kept
+func (p Policy) Enabled() bool {
+	return p.Count > 0 || p.MaxAge > 0
+}
+
+// Version is a previous content of a remote file
+type Version struct {
+	// Name identifies the version of the file
+	Name string `json:"name"`
+	// Replaced is the time the content was overwritten
+	Replaced time.Time `json:"replaced"`
+	Size     int64     `json:"size"`
+}
+
+// Versions of the files of a remote file system
+type Versions struct {
+	fs     afero.Fs
+	policy Policy
+	lock   sync.Mutex
+	last   time.Time
+}
+
+var _ upload.Keeper = (*Versions)(nil)
+
+func New(fs afero.Fs, policy Policy) *Versions {
+	return &Versions{fs: fs, policy: policy}
+}
+
+// Keep implements upload.Keeper by saving the current content of the remote file as a new version,
+// then removing the versions of the file not kept by the policy. It does nothing if versioning is disabled.
+func (v *Versions) Keep(name string) error {
+	if !v.policy.Enabled() {
+		return nil
+	}
+	return v.keep(name, time.Now())
+}
+
+func (v *Versions) keep(name string, now time.Time) error {
+	err := v.save(name, now)
+	if err != nil {
+		return err
+	}
+	return v.prune(name, now)
+}
+
+// save copies the current content of a remote file to a new version
+func (v *Versions) save(name string, now time.Time) error {
+	info, err := v.fs.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	dir := path.Join(Dir, name)
+	err = v.fs.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	return copyFile(v.fs, name, path.Join(dir, now.UTC().Format(timeLayout)))
+}
+
+// copyFile writes the content of a remote file to a new one, which appears only once complete
+func copyFile(fs afero.Fs, source string, name string) error {
+	file, err := fs.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	target, err := upload.OpenTarget(fs, name, upload.Checkpoint{})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, file)
+	if err != nil {
+		target.Close()
+		upload.AbortTarget(fs, name, upload.Checkpoint{Session: target.Session()})
+		return err
+	}
+	return target.Commit()
+}
+
+// List returns the versions of a remote file, most recent first
+func (v *Versions) List(name string) ([]Version, error) {
+	entries, err := afero.ReadDir(v.fs, path.Join(Dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result []Version
+	// names are sorted by time
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		replaced, err := time.Parse(timeLayout, entry.Name())
+		if err != nil || entry.IsDir() {
+			// versions of files below a path that used to be a file
+			continue
+		}
+		result = append(result, Version{Name: entry.Name(), Replaced: replaced, Size: entry.Size()})
+	}
+	return result, nil
+}
+
+// Restore replaces the content of a remote file with one of its versions, the current content is kept as a new version
+func (v *Versions) Restore(name string, version string) error {
+	source := path.Join(Dir, name, version)
+	if _, err := v.fs.Stat(source); err != nil {
+		return err
+	}
+	now := time.Now()
+	// the restored version must not be pruned before it is copied
+	err := v.save(name, now)
+	if err != nil {
+		return err
+	}
+	err = copyFile(v.fs, source, name)
+	if err != nil {
+		return err
+	}
+	return v.prune(name, now)
+}
+
+// prune removes the versions of a file that are neither among the most recent Count ones nor younger than MaxAge
+func (v *Versions) prune(name string, now time.Time) error {
+	if !v.policy.Enabled() {
+		return nil
+	}
+	list, err := v.List(name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, version := range list {
+		if i < v.policy.Count || (v.policy.MaxAge > 0 && now.Sub(version.Replaced) < v.policy.MaxAge) {
+			continue
+		}
+		err = v.fs.Remove(path.Join(Dir, name, version.Name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	dir := path.Join(Dir, name)
+	if entries, err := afero.ReadDir(v.fs, dir); err == nil && len(entries) == 0 {
+		v.fs.Remove(dir)
+	}
+	return errors.Join(errs...)
+}
+
+// Purge applies the policy to the versions of all files, so versions of files not replaced again expire as well.
+// It does nothing if versioning is disabled or the versions have been purged within PurgeInterval.
+func (v *Versions) Purge(now time.Time) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !v.policy.Enabled() || now.Sub(v.last) < PurgeInterval {
+		return nil
+	}
+	v.last = now
+	files := make(map[string]bool)
+	err := utils.Walk(v.fs, Dir, func(filepath string, info fs.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if _, perr := time.Parse(timeLayout, info.Name()); perr == nil {
+			files[path.Dir(filepath)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for dir := range files {
+		name := dir[len(Dir)+1:]
+		err = v.prune(name, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
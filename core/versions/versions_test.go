@@ -0,0 +1,131 @@
+package versions_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/balazsgrill/potatodrive/core/upload"
+	"github.com/balazsgrill/potatodrive/core/versions"
+	"github.com/spf13/afero"
+)
+
+type testEnv struct {
+	t        *testing.T
+	local    afero.Fs
+	remote   afero.Fs
+	versions *versions.Versions
+}
+
+func newTestEnv(t *testing.T, policy versions.Policy) *testEnv {
+	remote := afero.NewMemMapFs()
+	return &testEnv{
+		t:        t,
+		local:    afero.NewMemMapFs(),
+		remote:   remote,
+		versions: versions.New(remote, policy),
+	}
+}
+
+// upload writes the content to the local file and uploads it to the remote
+func (e *testEnv) upload(content string) {
+	e.t.Helper()
+	afero.WriteFile(e.local, "a.txt", []byte(content), 0666)
+	file, err := e.local.Open("a.txt")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer file.Close()
+	uploader := &upload.Uploader{Remote: e.remote, Checkpoints: upload.NewStore(), Versions: e.versions}
+	_, err = uploader.Upload(file, "a.txt", nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	// versions are named by the time, with millisecond precision
+	time.Sleep(2 * time.Millisecond)
+}
+
+// expect checks the content of the versions, most recent first
+func (e *testEnv) expect(contents ...string) []versions.Version {
+	e.t.Helper()
+	list, err := e.versions.List("a.txt")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if len(list) != len(contents) {
+		e.t.Fatalf("expected %d versions, got %v", len(contents), list)
+	}
+	for i, version := range list {
+		data, err := afero.ReadFile(e.remote, versions.Dir+"/a.txt/"+version.Name)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		if string(data) != contents[i] || version.Size != int64(len(contents[i])) {
+			e.t.Errorf("version %d: expected %s, got %s", i, contents[i], string(data))
+		}
+	}
+	return list
+}
+
+func TestPreviousContentIsKept(t *testing.T) {
+	env := newTestEnv(t, versions.Policy{Count: 5})
+	env.upload("one")
+	env.expect()
+	env.upload("two")
+	env.upload("three")
+	env.expect("two", "one")
+	if data, _ := afero.ReadFile(env.remote, "a.txt"); string(data) != "three" {
+		t.Errorf("remote file has %s", string(data))
+	}
+}
+
+func TestVersioningIsOptional(t *testing.T) {
+	env := newTestEnv(t, versions.Policy{})
+	env.upload("one")
+	env.upload("two")
+	env.expect()
+	if exists, _ := afero.DirExists(env.remote, versions.Dir); exists {
+		t.Error("versions folder is created")
+	}
+}
+
+func TestLastVersionsAreKept(t *testing.T) {
+	env := newTestEnv(t, versions.Policy{Count: 2})
+	for _, content := range []string{"one", "two", "three", "four"} {
+		env.upload(content)
+	}
+	env.expect("three", "two")
+}
+
+func TestOldVersionsExpire(t *testing.T) {
+	env := newTestEnv(t, versions.Policy{MaxAge: time.Hour})
+	env.upload("one")
+	env.upload("two")
+	env.upload("three")
+	env.expect("two", "one")
+
+	err := env.versions.Purge(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.expect()
+	if exists, _ := afero.DirExists(env.remote, versions.Dir+"/a.txt"); exists {
+		t.Error("empty folder of versions is kept")
+	}
+}
+
+func TestVersionIsRestored(t *testing.T) {
+	env := newTestEnv(t, versions.Policy{Count: 2})
+	env.upload("one")
+	env.upload("two")
+	env.upload("three")
+	list := env.expect("two", "one")
+
+	err := env.versions.Restore("a.txt", list[1].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(env.remote, "a.txt"); string(data) != "one" {
+		t.Errorf("remote file has %s", string(data))
+	}
+	env.expect("three", "two")
+}
@@ -1,24 +1,29 @@
 package core
 
 import (
-	"encoding/binary"
 	"io"
-	"syscall"
 )
 
 type Virtualization interface {
 	io.Closer
 	PerformSynchronization() error
 	SetStateCallbacks(callbacks FileStateCallbacks)
+	// SetChangeCallback sets the function called when local files are changed, so a synchronization can be scheduled
+	SetChangeCallback(callback func())
 }
 
-func BytesToGuid(b []byte) *syscall.GUID {
-	return &syscall.GUID{
-		Data1: binary.LittleEndian.Uint32(b[0:4]),
-		Data2: binary.LittleEndian.Uint16(b[4:6]),
-		Data3: binary.LittleEndian.Uint16(b[6:8]),
-		Data4: ([8]byte)(b[8:16]),
-	}
+type FileStateCallbacks interface {
+	FileSynchronizing(path string)
+	FileDone(path string)
+	FileRemoved(path string)
+	FileError(path string, err error)
+	FileDownloading(path string, progress int)
+	FileUploading(path string, progress int)
+	// FileConflict is reported when a file was changed on both sides and the local version has been saved as a conflicted copy
+	FileConflict(path string, conflictcopy string)
+	// FileNameCollision is reported when a remote file is projected under a disambiguated local name, or a local file is
+	// not uploaded, because its name differs from another one only by case or Unicode normalization
+	FileNameCollision(path string, remotepath string)
 }
 
 type ConnectionState struct {
@@ -7,6 +7,7 @@ toolchain go1.23.6
 require (
 	github.com/apache/thrift v0.21.0
 	github.com/aws/aws-sdk-go v1.54.20
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/fclairamb/afero-s3 v0.3.1
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/go-ole/go-ole v1.2.6
@@ -20,9 +21,11 @@ require (
 	github.com/rs/zerolog v1.33.0
 	github.com/saltosystems/winrt-go v0.0.0-20240510082706-db61b37f5877
 	github.com/spf13/afero v1.6.0
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/crypto v0.36.0
 	golang.org/x/oauth2 v0.28.0
 	golang.org/x/sys v0.31.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -35,7 +38,6 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/api v0.222.0 // indirect
 	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
@@ -97,7 +97,7 @@ func (instance *testInstance) start(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	instance.virtualization, err = filesystem.StartProjecting(instance.fsdir, fs, instancecontext.Logger)
+	instance.virtualization, err = filesystem.StartProjecting(instance.fsdir, fs, instancecontext.Logger, core.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/balazsgrill/potatodrive/bindings"
 	"github.com/balazsgrill/potatodrive/bindings/gphotos"
+	"github.com/balazsgrill/potatodrive/core"
+	"github.com/balazsgrill/potatodrive/core/digest"
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
 )
@@ -64,6 +66,46 @@ func (cc *configUI) configPanel(databinder **walk.DataBinder, configProvider bin
 						ColumnSpan: 2,
 						Model:      []string{bindings.APIType_CFAPI, bindings.APIType_PRJFS, bindings.APIType_CFAPI_Simplfied},
 					},
+					Label{Text: "Conflict resolution:"},
+					ComboBox{
+						Value:      Bind("Base.Conflict"),
+						ColumnSpan: 2,
+						Model:      []string{string(core.ConflictKeepBoth), string(core.ConflictPreferLocal), string(core.ConflictPreferRemote)},
+					},
+					Label{Text: "Keep deletions for:"},
+					LineEdit{Text: Bind("Base.TombstoneRetention"), ColumnSpan: 2},
+					Label{Text: "Excluded files:"},
+					LineEdit{Text: Bind("Base.Ignore"), ColumnSpan: 2},
+					Label{Text: "Synchronize every:"},
+					LineEdit{Text: Bind("Base.SyncInterval"), ColumnSpan: 2},
+					Label{Text: "Parallel transfers:"},
+					LineEdit{Text: Bind("Base.Transfers"), ColumnSpan: 2},
+					Label{Text: "Local cache size:"},
+					LineEdit{Text: Bind("Base.CacheSize"), ColumnSpan: 2},
+					Label{Text: "Dehydrate unused files after:"},
+					LineEdit{Text: Bind("Base.CacheMaxAge"), ColumnSpan: 2},
+					Label{Text: "Keep trashed files for:"},
+					LineEdit{Text: Bind("Base.TrashRetention"), ColumnSpan: 2},
+					Label{Text: "Trash size:"},
+					LineEdit{Text: Bind("Base.TrashSize"), ColumnSpan: 2},
+					Label{Text: "Previous versions kept:"},
+					LineEdit{Text: Bind("Base.Versions"), ColumnSpan: 2},
+					Label{Text: "Keep previous versions for:"},
+					LineEdit{Text: Bind("Base.VersionMaxAge"), ColumnSpan: 2},
+					Label{Text: "Content hash:"},
+					ComboBox{
+						Value:      Bind("Base.Hash"),
+						ColumnSpan: 2,
+						Model:      []string{string(digest.MD5), string(digest.SHA256), string(digest.XXHash)},
+					},
+					Label{Text: "Keep state of remote files:"},
+					ComboBox{
+						Value:      Bind("Base.State"),
+						ColumnSpan: 2,
+						Model:      []string{string(core.StateRemote), string(core.StateLocal), string(core.StateManifest)},
+					},
+					Label{Text: "Skip hidden files:"},
+					CheckBox{Checked: Bind("Base.SkipHidden"), ColumnSpan: 2},
 				},
 			},
 			Composite{